@@ -16,24 +16,40 @@ package artifactorysecrets
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
-	"strings"
+	"net/http"
+	"net/url"
+	"sync"
 	"time"
 
-	"github.com/jfrog/jfrog-client-go/access"
+	"github.com/hashicorp/vault/sdk/logical"
 	accessauth "github.com/jfrog/jfrog-client-go/access/auth"
 	accessservices "github.com/jfrog/jfrog-client-go/access/services"
 	"github.com/jfrog/jfrog-client-go/artifactory"
 	artauth "github.com/jfrog/jfrog-client-go/artifactory/auth"
 	"github.com/jfrog/jfrog-client-go/artifactory/services"
+	artutils "github.com/jfrog/jfrog-client-go/artifactory/services/utils"
 	"github.com/jfrog/jfrog-client-go/auth"
 	artconfig "github.com/jfrog/jfrog-client-go/config"
+	"github.com/jfrog/jfrog-client-go/http/jfroghttpclient"
+	"github.com/jfrog/jfrog-client-go/utils/errorutils"
 	"github.com/jfrog/jfrog-client-go/utils/log"
+	"golang.org/x/net/http/httpproxy"
 )
 
 const (
 	clientTTL = 30 * time.Minute
+
+	// permissionTargetsCacheTTL is how long the permission targets fetched for the
+	// permission checks of roles with static groups are reused
+	permissionTargetsCacheTTL = time.Minute
+
+	// adminTokenTTL is the lifetime of the admin tokens a username and password are
+	// exchanged for
+	adminTokenTTL   = time.Hour
+	adminTokenScope = "applied-permissions/admin"
 )
 
 type Client interface {
@@ -42,19 +58,36 @@ type Client interface {
 	CreateOrUpdatePermissionTarget(role *RoleStorageEntry, pt *PermissionTarget, ptName string) error
 	DeletePermissionTarget(ptName string) error
 	CreateToken(tokenReq TokenCreateEntry, role *RoleStorageEntry) (auth.CreateTokenResponseData, error)
+	RevokeToken(tokenID string) error
+	ChangePassword(username, oldPassword, newPassword string) error
+	ArtifactoryVersion() (string, error)
+	RepositoryExists(repoKey string) (bool, error)
+	GroupExists(name string) (bool, error)
+	GetGroupsPermissionTargets(groups []string) (map[string][]PermissionTarget, error)
 	Valid() bool
 }
 
 type artifactoryClient struct {
-	client       artifactory.ArtifactoryServicesManager
-	accessClient *access.AccessServicesManager
+	client        artifactory.ArtifactoryServicesManager
+	accessClient  *jfroghttpclient.JfrogHttpClient
+	accessDetails auth.ServiceDetails
 
 	expiration time.Time
+
+	// permissionTargets caches the permission targets of Artifactory until
+	// permissionTargetsExpiration, as listing them fetches each one
+	permissionTargetsLock       sync.Mutex
+	permissionTargets           []*services.PermissionTargetParams
+	permissionTargetsExpiration time.Time
 }
 
 var _ Client = &artifactoryClient{}
 
-func NewClient(config *ConfigStorageEntry) (Client, error) {
+// NewClient builds a client authenticated with the credentials of the config. With an OIDC
+// provider configured, a plugin identity token from sysView is exchanged for an access token.
+// A username and password are exchanged for a short-lived admin access token. Either way,
+// the client expires before the access token does.
+func NewClient(ctx context.Context, config *ConfigStorageEntry, sysView logical.SystemView) (Client, error) {
 	if config == nil {
 		return nil, fmt.Errorf("artifactory backend configuration has not been set up")
 	}
@@ -65,29 +98,45 @@ func NewClient(config *ConfigStorageEntry) (Client, error) {
 
 	log.SetLogger(log.NewLogger(log.INFO, io.Discard))
 
+	httpClient, err := newHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+
 	artifactoryDetails := artauth.NewArtifactoryDetails()
 	artifactoryDetails.SetUrl(ensureArtifactoryURL(config.BaseURL))
 
 	// For Access microservice
 	accessDetails := accessauth.NewAccessDetails()
-	accessDetails.SetUrl(ensureAccessURL(config.BaseURL))
-
-	if config.BearerToken != "" {
+	accessDetails.SetUrl(config.accessURL())
+
+	if config.OIDCProviderName != "" {
+		accessToken, expiresAt, err := exchangeIdentityToken(ctx, config, sysView, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		artifactoryDetails.SetAccessToken(accessToken)
+		accessDetails.SetAccessToken(accessToken)
+		ac.expiration = accessTokenClientExpiration(time.Now(), expiresAt)
+	} else if config.BearerToken != "" {
 		artifactoryDetails.SetAccessToken(config.BearerToken)
 		accessDetails.SetAccessToken(config.BearerToken)
 	} else if config.Username != "" && config.Password != "" {
-		artifactoryDetails.SetUser(config.Username)
-		artifactoryDetails.SetPassword(config.Password)
-		accessDetails.SetUser(config.Username)
-		accessDetails.SetPassword(config.Password)
+		accessToken, expiresAt, err := exchangePassword(config, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		artifactoryDetails.SetAccessToken(accessToken)
+		accessDetails.SetAccessToken(accessToken)
+		ac.expiration = accessTokenClientExpiration(time.Now(), expiresAt)
 	} else {
-		return nil, fmt.Errorf("bearer token and/or username/password not configured")
+		return nil, fmt.Errorf("bearer token, username/password or OIDC provider not configured")
 	}
 
 	// Note: do not reuse Vault request context here as this client is cached between requests.
 	artifactoryServiceConfig, err := artconfig.NewConfigBuilder().
 		SetServiceDetails(artifactoryDetails).
-		SetOverallRequestTimeout(config.ClientTimeout).
+		SetHttpClient(httpClient).
 		// SetDryRun(false).
 		SetContext(context.Background()).
 		SetThreads(1).
@@ -104,23 +153,138 @@ func NewClient(config *ConfigStorageEntry) (Client, error) {
 
 	ac.client = client
 
-	accessServicesConfig, err := artconfig.NewConfigBuilder().
-		SetServiceDetails(accessDetails).
+	accessClient, err := newAccessClient(accessDetails, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	ac.accessClient = accessClient
+	ac.accessDetails = accessDetails
+	return ac, nil
+}
+
+// newHTTPClient returns the HTTP client of the Artifactory and Access services. Requests go
+// through the proxy of the config, or the proxy of the environment if it has none.
+func newHTTPClient(config *ConfigStorageEntry) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.ProxyURL != "" {
+		proxyFunc, err := proxyFunc(config.ProxyURL, config.NoProxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = proxyFunc
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   config.ClientTimeout,
+	}, nil
+}
+
+// proxyFunc returns a proxy selector sending requests through proxyURL, except for the
+// hosts matching noProxy, a comma-separated list in the format of the NO_PROXY variable
+func proxyFunc(proxyURL, noProxy string) (func(*http.Request) (*url.URL, error), error) {
+	if _, err := parseProxyURL(proxyURL); err != nil {
+		return nil, err
+	}
+
+	selector := (&httpproxy.Config{
+		HTTPProxy:  proxyURL,
+		HTTPSProxy: proxyURL,
+		NoProxy:    noProxy,
+	}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return selector(req.URL)
+	}, nil
+}
+
+func parseProxyURL(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url - %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy url - unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy url - missing host")
+	}
+	return u, nil
+}
+
+// newAccessClient returns the client of the Access service. It is built like the one of
+// access.New, which ignores custom HTTP clients.
+func newAccessClient(accessDetails auth.ServiceDetails, httpClient *http.Client) (*jfroghttpclient.JfrogHttpClient, error) {
+	accessClient, err := jfroghttpclient.JfrogClientBuilder().
+		SetHttpClient(httpClient).
+		AppendPreRequestInterceptor(accessDetails.RunPreRequestFunctions).
 		SetContext(context.Background()).
-		SetThreads(1).
 		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build access client - %w", err)
+	}
+	return accessClient, nil
+}
 
+// createAccessToken creates an access token with the Access service
+func createAccessToken(accessClient *jfroghttpclient.JfrogHttpClient, accessDetails auth.ServiceDetails, params accessservices.CreateTokenParams) (auth.CreateTokenResponseData, error) {
+	tokenService := accessservices.NewTokenService(accessClient)
+	tokenService.ServiceDetails = accessDetails
+	return tokenService.CreateAccessToken(params)
+}
+
+// exchangePassword creates a short-lived admin access token for the user of the config,
+// authenticated with its password. It returns the access token and its expiry, zero if
+// the token doesn't expire.
+func exchangePassword(config *ConfigStorageEntry, httpClient *http.Client) (string, time.Time, error) {
+	accessDetails := accessauth.NewAccessDetails()
+	accessDetails.SetUrl(config.accessURL())
+	accessDetails.SetUser(config.Username)
+	accessDetails.SetPassword(config.Password)
+
+	accessClient, err := newAccessClient(accessDetails, httpClient)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build access service config - %w", err)
+		return "", time.Time{}, err
 	}
 
-	accessClient, err := access.New(accessServicesConfig)
+	now := time.Now()
+	expiresIn := uint(adminTokenTTL.Seconds())
+	resp, err := createAccessToken(accessClient, accessDetails, accessservices.CreateTokenParams{
+		CommonTokenParams: auth.CommonTokenParams{
+			Scope:     adminTokenScope,
+			ExpiresIn: &expiresIn,
+			TokenType: "access_token",
+		},
+		Username:    config.Username,
+		Description: fmt.Sprintf("Admin token of %s", pluginPrefix),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to build access client - %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to exchange the password of %s for an admin token - %w", config.Username, err)
 	}
 
-	ac.accessClient = accessClient
-	return ac, nil
+	var expiresAt time.Time
+	if resp.ExpiresIn != nil && *resp.ExpiresIn > 0 {
+		expiresAt = now.Add(time.Duration(*resp.ExpiresIn) * time.Second)
+	}
+	return resp.AccessToken, expiresAt, nil
+}
+
+// accessTokenClientExpiration returns when a client authenticated with an exchanged access
+// token must be replaced. The token is refreshed once a fifth of its lifetime is left, and
+// at least as often as clients authenticated with stored credentials.
+func accessTokenClientExpiration(now, tokenExpiresAt time.Time) time.Time {
+	expiration := now.Add(clientTTL)
+	if tokenExpiresAt.IsZero() {
+		return expiration
+	}
+
+	refreshAt := now.Add(tokenExpiresAt.Sub(now) * 4 / 5)
+	if refreshAt.Before(expiration) {
+		return refreshAt
+	}
+	return expiration
 }
 
 func (ac *artifactoryClient) Valid() bool {
@@ -186,15 +350,9 @@ func (ac *artifactoryClient) DeletePermissionTarget(ptName string) error {
 func (ac *artifactoryClient) CreateToken(tokenReq TokenCreateEntry, role *RoleStorageEntry) (auth.CreateTokenResponseData, error) {
 	expiresIn := uint(tokenReq.TTL.Seconds())
 
-	var groups []string
-	if len(role.PermissionTargets) > 0 {
-		groups = append(groups, groupName(role))
-	}
-	groups = append(groups, role.Groups...)
-
 	params := accessservices.CreateTokenParams{
 		CommonTokenParams: auth.CommonTokenParams{
-			Scope:     fmt.Sprintf("applied-permissions/groups:%s", strings.Join(groups, ",")),
+			Scope:     tokenScope(role),
 			ExpiresIn: &expiresIn,
 			TokenType: "access_token",
 			Audience:  "*@*",
@@ -202,6 +360,142 @@ func (ac *artifactoryClient) CreateToken(tokenReq TokenCreateEntry, role *RoleSt
 		Username:    tokenUsername(role.Name),
 		Description: fmt.Sprintf("Generated from %s", pluginPrefix),
 	}
+	if role.IncludeReferenceToken {
+		params.IncludeReferenceToken = ptr(true)
+	}
+
+	return createAccessToken(ac.accessClient, ac.accessDetails, params)
+}
+
+// RevokeToken revokes an access token by its ID. Tokens that no longer exist are
+// considered revoked.
+func (ac *artifactoryClient) RevokeToken(tokenID string) error {
+	httpDetails := ac.accessDetails.CreateHttpClientDetails()
+	resp, body, err := ac.accessClient.SendDelete(ac.accessDetails.GetUrl()+"api/v1/tokens/"+url.PathEscape(tokenID), nil, &httpDetails)
+	if err != nil {
+		return err
+	}
+	return errorutils.CheckResponseStatusWithBody(resp, body, http.StatusOK, http.StatusNoContent, http.StatusNotFound)
+}
+
+// ChangePassword changes the password of a user, given its current password
+func (ac *artifactoryClient) ChangePassword(username, oldPassword, newPassword string) error {
+	body, err := json.Marshal(map[string]string{
+		"userName":     username,
+		"oldPassword":  oldPassword,
+		"newPassword1": newPassword,
+		"newPassword2": newPassword,
+	})
+	if err != nil {
+		return err
+	}
+
+	details := ac.client.GetConfig().GetServiceDetails()
+	httpDetails := details.CreateHttpClientDetails()
+	artutils.SetContentType("application/json", &httpDetails.Headers)
+	resp, respBody, err := ac.client.Client().SendPost(details.GetUrl()+"api/security/users/authorization/changePassword", body, &httpDetails)
+	if err != nil {
+		return err
+	}
+	return errorutils.CheckResponseStatusWithBody(resp, respBody, http.StatusOK)
+}
+
+func (ac *artifactoryClient) ArtifactoryVersion() (string, error) {
+	return ac.client.GetVersion()
+}
+
+func (ac *artifactoryClient) RepositoryExists(repoKey string) (bool, error) {
+	return ac.client.IsRepoExists(repoKey)
+}
+
+func (ac *artifactoryClient) GroupExists(name string) (bool, error) {
+	params := services.GroupParams{
+		GroupDetails: services.Group{
+			Name: name,
+		},
+	}
+	group, err := ac.client.GetGroup(params)
+	if err != nil {
+		return false, err
+	}
+	return group != nil, nil
+}
+
+// GetGroupsPermissionTargets returns the repository permissions granted to each of the
+// groups, one PermissionTarget named after each Artifactory permission target referencing
+// the group. The permission targets are fetched at most once per permissionTargetsCacheTTL.
+func (ac *artifactoryClient) GetGroupsPermissionTargets(groups []string) (map[string][]PermissionTarget, error) {
+	list, err := ac.listPermissionTargets()
+	if err != nil {
+		return nil, err
+	}
+
+	pts := make(map[string][]PermissionTarget, len(groups))
+	for _, params := range list {
+		if params.Repo == nil || params.Repo.Actions == nil {
+			continue
+		}
+		for _, group := range groups {
+			ops := params.Repo.Actions.Groups[group]
+			if len(ops) == 0 {
+				continue
+			}
+			pts[group] = append(pts[group], PermissionTarget{
+				Name: params.Name,
+				Repo: &Permission{
+					IncludePatterns: params.Repo.IncludePatterns,
+					ExcludePatterns: params.Repo.ExcludePatterns,
+					Repositories:    params.Repo.Repositories,
+					Operations:      ops,
+				},
+			})
+		}
+	}
+
+	return pts, nil
+}
+
+// listPermissionTargets returns all the permission targets of Artifactory, from the cache
+// unless it expired
+func (ac *artifactoryClient) listPermissionTargets() ([]*services.PermissionTargetParams, error) {
+	ac.permissionTargetsLock.Lock()
+	defer ac.permissionTargetsLock.Unlock()
+
+	if ac.permissionTargets != nil && time.Now().Before(ac.permissionTargetsExpiration) {
+		return ac.permissionTargets, nil
+	}
+
+	details := ac.client.GetConfig().GetServiceDetails()
+	httpDetails := details.CreateHttpClientDetails()
+	resp, body, _, err := ac.client.Client().SendGet(details.GetUrl()+"api/v2/security/permissions", true, &httpDetails)
+	if err != nil {
+		return nil, err
+	}
+	if err = errorutils.CheckResponseStatusWithBody(resp, body, http.StatusOK); err != nil {
+		return nil, err
+	}
+
+	var names []struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(body, &names); err != nil {
+		return nil, fmt.Errorf("failed to parse permission targets list - %w", err)
+	}
+
+	list := make([]*services.PermissionTargetParams, 0, len(names))
+	for _, item := range names {
+		params, err := ac.client.GetPermissionTarget(item.Name)
+		if err != nil {
+			return nil, err
+		}
+		// deleted since it was listed
+		if params == nil {
+			continue
+		}
+		list = append(list, params)
+	}
 
-	return ac.accessClient.CreateAccessToken(params)
+	ac.permissionTargets = list
+	ac.permissionTargetsExpiration = time.Now().Add(permissionTargetsCacheTTL)
+	return list, nil
 }
@@ -16,12 +16,22 @@ package artifactorysecrets
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/vault/sdk/helper/pluginidentityutil"
+	"github.com/hashicorp/vault/sdk/helper/pluginutil"
 	"github.com/hashicorp/vault/sdk/logical"
+	accessservices "github.com/jfrog/jfrog-client-go/access/services"
 	"github.com/jfrog/jfrog-client-go/artifactory"
 	"github.com/jfrog/jfrog-client-go/auth"
 	"github.com/stretchr/testify/assert"
@@ -34,14 +44,14 @@ func TestNewClientFail(t *testing.T) {
 	t.Parallel()
 	t.Run("no_config", func(t *testing.T) {
 
-		c, err := NewClient(nil)
+		c, err := NewClient(context.Background(), nil, nil)
 		assert.Error(t, err, "nil config should thrown an error when retrieving Artifactory client")
 		assert.Nil(t, c)
 	})
 
 	t.Run("empty_config", func(t *testing.T) {
 		config := &ConfigStorageEntry{}
-		c, err := NewClient(config)
+		c, err := NewClient(context.Background(), config, nil)
 		assert.Error(t, err, "NewClient should return an error if config is missing auth")
 		assert.Nil(t, c, "NewClient should return nil client on error")
 	})
@@ -90,7 +100,7 @@ func TestArtAccNewClient(t *testing.T) {
 		test := test // capture range var
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
-			c, err := NewClient(test.config)
+			c, err := NewClient(context.Background(), test.config, nil)
 			assert.NoError(t, err)
 			assert.NotNil(t, c)
 			assert.True(t, c.Valid())
@@ -137,7 +147,504 @@ func TestValid(t *testing.T) {
 	}
 }
 
-type mockArtifactoryClient struct{}
+// identityTokenSystemView is a system view issuing plugin identity tokens, as Vault Enterprise does
+type identityTokenSystemView struct {
+	*logical.StaticSystemView
+	err      error
+	requests atomic.Int32
+}
+
+func (s *identityTokenSystemView) GenerateIdentityToken(_ context.Context, req *pluginutil.IdentityTokenRequest) (*pluginutil.IdentityTokenResponse, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.requests.Add(1)
+	return &pluginutil.IdentityTokenResponse{
+		Token: pluginutil.IdentityToken(fmt.Sprintf("identity-token.%s.%d", req.Audience, int64(req.TTL/time.Second))),
+		TTL:   req.TTL,
+	}, nil
+}
+
+// fakeArtifactory is a stand-in Artifactory issuing access tokens. It exchanges identity
+// tokens of the "artifactory" audience at its OIDC token endpoint for the "vault" provider,
+// and the password of its "admin" user at its token endpoint. Its version is only served to
+// the access tokens it issued. It serves a "deploy" permission target granted to the "ci"
+// group, counting the fetches of permission targets.
+type fakeArtifactory struct {
+	*httptest.Server
+	expiresIn int64
+
+	mu       sync.Mutex
+	password string
+
+	oidcExchanges     atomic.Int32
+	passwordExchanges atomic.Int32
+
+	permissionTargetFetches atomic.Int32
+}
+
+func newFakeArtifactory(t *testing.T, expiresIn int64) *fakeArtifactory {
+	t.Helper()
+	fa := &fakeArtifactory{expiresIn: expiresIn, password: "adminpassword"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/access/api/v1/oidc/token", func(w http.ResponseWriter, r *http.Request) {
+		var req oidcTokenExchangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || r.Method != http.MethodPost {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if req.GrantType != oidcTokenExchangeGrantType || req.SubjectTokenType != oidcSubjectTokenType ||
+			req.ProviderName != "vault" || !strings.HasPrefix(req.SubjectToken, "identity-token.artifactory.") {
+			http.Error(w, `{"errors": [{"message": "invalid token"}]}`, http.StatusUnauthorized)
+			return
+		}
+		fa.writeToken(w, fmt.Sprintf("access-token-%d", fa.oidcExchanges.Add(1)))
+	})
+	mux.HandleFunc("/access/api/v1/tokens", func(w http.ResponseWriter, r *http.Request) {
+		var req accessservices.CreateTokenParams
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || r.Method != http.MethodPost {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if !fa.authenticated(r) || req.Username != "admin" || req.Scope != adminTokenScope {
+			http.Error(w, `{"errors": [{"message": "unauthorized"}]}`, http.StatusUnauthorized)
+			return
+		}
+		fa.writeToken(w, fmt.Sprintf("admin-token-%d", fa.passwordExchanges.Add(1)))
+	})
+	mux.HandleFunc("/artifactory/api/security/users/authorization/changePassword", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer admin-token-") {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fa.mu.Lock()
+		defer fa.mu.Unlock()
+		if req["userName"] != "admin" || req["oldPassword"] != fa.password || req["newPassword1"] != req["newPassword2"] {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		fa.password = req["newPassword1"]
+	})
+	mux.HandleFunc("/artifactory/api/system/version", func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer access-token-") && !strings.HasPrefix(auth, "Bearer admin-token-") {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"version": "7.77.3", "revision": "77703900"}`))
+	})
+	mux.HandleFunc("/artifactory/api/v2/security/permissions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"name": "deploy"}]`))
+	})
+	mux.HandleFunc("/artifactory/api/v2/security/permissions/deploy", func(w http.ResponseWriter, r *http.Request) {
+		fa.permissionTargetFetches.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"name": "deploy", "repo": {"repositories": ["libs-release"], "actions": {"groups": {"ci": ["read", "write"]}}}}`))
+	})
+
+	fa.Server = httptest.NewServer(mux)
+	t.Cleanup(fa.Close)
+	return fa
+}
+
+func (fa *fakeArtifactory) authenticated(r *http.Request) bool {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	username, password, ok := r.BasicAuth()
+	return ok && username == "admin" && password == fa.password
+}
+
+func (fa *fakeArtifactory) writeToken(w http.ResponseWriter, accessToken string) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(oidcTokenExchangeResponse{
+		AccessToken: accessToken,
+		ExpiresIn:   fa.expiresIn,
+		TokenType:   "Bearer",
+	})
+}
+
+func TestNewClientIdentityToken(t *testing.T) {
+	t.Parallel()
+	server := newFakeArtifactory(t, 3600)
+	config := &ConfigStorageEntry{
+		BaseURL:          server.URL + "/artifactory/",
+		ClientTimeout:    5 * time.Second,
+		OIDCProviderName: "vault",
+		PluginIdentityTokenParams: pluginidentityutil.PluginIdentityTokenParams{
+			IdentityTokenAudience: "artifactory",
+			IdentityTokenTTL:      10 * time.Minute,
+		},
+	}
+
+	t.Run("exchange", func(t *testing.T) {
+		sysView := &identityTokenSystemView{StaticSystemView: logical.TestSystemView()}
+		before := server.oidcExchanges.Load()
+		c, err := NewClient(context.Background(), config, sysView)
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), sysView.requests.Load())
+		assert.Equal(t, before+1, server.oidcExchanges.Load())
+
+		// the exchanged access token authenticates the client
+		version, err := c.ArtifactoryVersion()
+		require.NoError(t, err)
+		assert.Equal(t, "7.77.3", version)
+
+		// the access token is refreshed before it expires
+		ac := c.(*artifactoryClient)
+		assert.True(t, ac.Valid())
+		assert.WithinDuration(t, time.Now().Add(clientTTL), ac.expiration, 5*time.Second)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		rejected := *config
+		rejected.OIDCProviderName = "unknown"
+		c, err := NewClient(context.Background(), &rejected, &identityTokenSystemView{StaticSystemView: logical.TestSystemView()})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "401")
+		assert.Nil(t, c)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		sysView := &identityTokenSystemView{
+			StaticSystemView: logical.TestSystemView(),
+			err:              pluginidentityutil.ErrPluginWorkloadIdentityUnsupported,
+		}
+		c, err := NewClient(context.Background(), config, sysView)
+		require.ErrorIs(t, err, pluginidentityutil.ErrPluginWorkloadIdentityUnsupported)
+		assert.Nil(t, c)
+	})
+}
+
+func TestGetGroupsPermissionTargetsCache(t *testing.T) {
+	t.Parallel()
+	server := newFakeArtifactory(t, 3600)
+	c, err := NewClient(context.Background(), &ConfigStorageEntry{
+		BaseURL:       server.URL + "/artifactory/",
+		ClientTimeout: 5 * time.Second,
+		BearerToken:   "access-token-static",
+	}, logical.TestSystemView())
+	require.NoError(t, err)
+
+	expected := map[string][]PermissionTarget{
+		"ci": {{
+			Name: "deploy",
+			Repo: &Permission{
+				Repositories: []string{"libs-release"},
+				Operations:   []string{"read", "write"},
+			},
+		}},
+	}
+	pts, err := c.GetGroupsPermissionTargets([]string{"ci", "other"})
+	require.NoError(t, err)
+	assert.Equal(t, expected, pts)
+	assert.Equal(t, int32(1), server.permissionTargetFetches.Load())
+
+	// the permission targets are reused for the next checks
+	pts, err = c.GetGroupsPermissionTargets([]string{"ci"})
+	require.NoError(t, err)
+	assert.Equal(t, expected, pts)
+	assert.Equal(t, int32(1), server.permissionTargetFetches.Load())
+
+	// and fetched again once expired
+	c.(*artifactoryClient).permissionTargetsExpiration = time.Now().Add(-time.Second)
+	_, err = c.GetGroupsPermissionTargets([]string{"ci"})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), server.permissionTargetFetches.Load())
+}
+
+func TestNewClientPasswordExchange(t *testing.T) {
+	t.Parallel()
+	server := newFakeArtifactory(t, 600)
+
+	t.Run("exchange", func(t *testing.T) {
+		c, err := NewClient(context.Background(), &ConfigStorageEntry{
+			BaseURL:  server.URL,
+			Username: "admin",
+			Password: "adminpassword",
+		}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), server.passwordExchanges.Load())
+
+		// calls are authenticated with the admin token rather than the password
+		version, err := c.ArtifactoryVersion()
+		require.NoError(t, err)
+		assert.Equal(t, "7.77.3", version)
+
+		ac := c.(*artifactoryClient)
+		assert.Equal(t, "admin-token-1", ac.accessDetails.GetAccessToken())
+		assert.Empty(t, ac.accessDetails.GetPassword())
+		assert.WithinDuration(t, time.Now().Add(8*time.Minute), ac.expiration, 5*time.Second)
+	})
+
+	t.Run("wrong_password", func(t *testing.T) {
+		c, err := NewClient(context.Background(), &ConfigStorageEntry{
+			BaseURL:  server.URL,
+			Username: "admin",
+			Password: "wrong",
+		}, nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to exchange the password of admin")
+		assert.Nil(t, c)
+	})
+}
+
+// fakeProxy is a stand-in forward proxy serving the requests to Artifactory hosts with a
+// fake Artifactory, and recording the "host path" of each request
+type fakeProxy struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func newFakeProxy(t *testing.T, upstream http.Handler) *fakeProxy {
+	t.Helper()
+	fp := &fakeProxy{}
+	fp.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fp.mu.Lock()
+		fp.requests = append(fp.requests, r.URL.Host+" "+r.URL.Path)
+		fp.mu.Unlock()
+		upstream.ServeHTTP(w, r)
+	}))
+	t.Cleanup(fp.Close)
+	return fp
+}
+
+func TestNewClientProxy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		config   ConfigStorageEntry
+		expected []string
+	}{
+		{
+			name: "proxy",
+			config: ConfigStorageEntry{
+				BaseURL: "http://artifactory.example.test/",
+			},
+			expected: []string{
+				"artifactory.example.test /access/api/v1/tokens",
+				"artifactory.example.test /artifactory/api/system/version",
+			},
+		},
+		{
+			name: "access_url",
+			config: ConfigStorageEntry{
+				BaseURL:   "http://artifactory.example.test/artifactory/",
+				AccessURL: "http://access.example.test",
+			},
+			expected: []string{
+				"access.example.test /access/api/v1/tokens",
+				"artifactory.example.test /artifactory/api/system/version",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			server := newFakeArtifactory(t, 3600)
+			proxy := newFakeProxy(t, server.Config.Handler)
+
+			config := test.config
+			config.Username = "admin"
+			config.Password = "adminpassword"
+			config.ProxyURL = proxy.URL
+			config.ClientTimeout = 5 * time.Second
+
+			c, err := NewClient(context.Background(), &config, nil)
+			require.NoError(t, err)
+			version, err := c.ArtifactoryVersion()
+			require.NoError(t, err)
+			assert.Equal(t, "7.77.3", version)
+
+			proxy.mu.Lock()
+			defer proxy.mu.Unlock()
+			assert.Equal(t, test.expected, proxy.requests)
+		})
+	}
+}
+
+func TestProxyFunc(t *testing.T) {
+	t.Parallel()
+	proxy, err := proxyFunc("http://proxy.example.com:3128", "direct.example.com,.internal.example.com,10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		url     string
+		proxied bool
+	}{
+		{url: "https://artifactory.example.com/artifactory/", proxied: true},
+		{url: "http://artifactory.example.com/artifactory/", proxied: true},
+		{url: "https://direct.example.com/artifactory/"},
+		{url: "https://artifactory.internal.example.com/artifactory/"},
+		{url: "https://10.1.2.3/artifactory/"},
+		{url: "http://127.0.0.1:8081/artifactory/"},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.url, func(t *testing.T) {
+			t.Parallel()
+			req, err := http.NewRequest(http.MethodGet, test.url, nil)
+			require.NoError(t, err)
+			proxyURL, err := proxy(req)
+			require.NoError(t, err)
+			if test.proxied {
+				require.NotNil(t, proxyURL)
+				assert.Equal(t, "proxy.example.com:3128", proxyURL.Host)
+			} else {
+				assert.Nil(t, proxyURL)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"ftp://proxy.example.com", "proxy.example.com:3128", "http://"} {
+		_, err := proxyFunc(invalid, "")
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestAccessTokenClientExpiration(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+
+	tests := []struct {
+		name           string
+		tokenExpiresAt time.Time
+		expected       time.Time
+	}{
+		{
+			name:     "no_expiry",
+			expected: now.Add(clientTTL),
+		},
+		{
+			name:           "short_lived",
+			tokenExpiresAt: now.Add(10 * time.Minute),
+			expected:       now.Add(8 * time.Minute),
+		},
+		{
+			name:           "long_lived",
+			tokenExpiresAt: now.Add(24 * time.Hour),
+			expected:       now.Add(clientTTL),
+		},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, test.expected, accessTokenClientExpiration(now, test.tokenExpiresAt))
+		})
+	}
+}
+
+func TestGetClientRefreshesIdentityToken(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server := newFakeArtifactory(t, 60)
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	config.System = &identityTokenSystemView{StaticSystemView: logical.TestSystemView()}
+	b, err := Factory(ctx, config)
+	require.NoError(t, err)
+	backend := b.(*ArtifactoryBackend)
+
+	testConfigUpdate(t, backend, config.StorageView, map[string]interface{}{
+		"base_url":                server.URL,
+		"oidc_provider_name":      "vault",
+		"identity_token_audience": "artifactory",
+	})
+
+	c, err := backend.getClient(ctx, config.StorageView)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(48*time.Second), c.(*artifactoryClient).expiration, 5*time.Second)
+
+	cached, err := backend.getClient(ctx, config.StorageView)
+	require.NoError(t, err)
+	assert.Same(t, c, cached)
+	assert.Equal(t, int32(1), server.oidcExchanges.Load())
+
+	// once the refresh point of the access token is reached, a new one is exchanged
+	c.(*artifactoryClient).expiration = time.Now().Add(-time.Second)
+	refreshed, err := backend.getClient(ctx, config.StorageView)
+	require.NoError(t, err)
+	assert.NotSame(t, c, refreshed)
+	assert.Equal(t, int32(2), server.oidcExchanges.Load())
+
+	version, err := refreshed.ArtifactoryVersion()
+	require.NoError(t, err)
+	assert.Equal(t, "7.77.3", version)
+}
+
+type mockArtifactoryClient struct {
+	missingRepositories    []string
+	missingGroups          []string
+	groupPermissionTargets map[string][]PermissionTarget
+	version                string
+
+	// failPermissionTargets are the permission target names failing to be created or deleted
+	failPermissionTargets []string
+
+	// passwords are the passwords of users changed through the client. failChangePassword
+	// rejects changes.
+	passwords          map[string]string
+	failChangePassword bool
+
+	// changePasswordHook runs at the start of a password change
+	changePasswordHook func()
+
+	// failCreateToken rejects token requests
+	failCreateToken bool
+
+	mu            sync.Mutex
+	issuedTokens  int
+	revokedTokens []string
+	deletedGroups []string
+
+	// permissionTargets are the names of the existing permission targets
+	permissionTargets map[string]bool
+
+	// requestDelay is the simulated duration of a permission target request.
+	// inFlight and maxInFlight count the concurrent permission target requests.
+	requestDelay time.Duration
+	inFlight     int
+	maxInFlight  int
+}
+
+// permissionTargetRequest simulates a permission target round trip
+func (ac *mockArtifactoryClient) permissionTargetRequest(ptName string, exists bool) error {
+	ac.mu.Lock()
+	ac.inFlight++
+	ac.maxInFlight = max(ac.maxInFlight, ac.inFlight)
+	ac.mu.Unlock()
+
+	time.Sleep(ac.requestDelay)
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.inFlight--
+
+	if slices.Contains(ac.failPermissionTargets, ptName) {
+		return fmt.Errorf("mock failure for %s", ptName)
+	}
+	if ac.permissionTargets == nil {
+		ac.permissionTargets = map[string]bool{}
+	}
+	if exists {
+		ac.permissionTargets[ptName] = true
+	} else {
+		delete(ac.permissionTargets, ptName)
+	}
+	return nil
+}
 
 var _ Client = &mockArtifactoryClient{}
 
@@ -150,16 +657,80 @@ func (ac *mockArtifactoryClient) CreateOrReplaceGroup(role *RoleStorageEntry) er
 }
 
 func (ac *mockArtifactoryClient) DeleteGroup(role *RoleStorageEntry) error {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.deletedGroups = append(ac.deletedGroups, groupName(role))
 	return nil
 }
 func (ac *mockArtifactoryClient) CreateOrUpdatePermissionTarget(role *RoleStorageEntry, pt *PermissionTarget, ptName string) error {
-	return nil
+	return ac.permissionTargetRequest(ptName, true)
 }
 func (ac *mockArtifactoryClient) DeletePermissionTarget(ptName string) error {
-	return nil
+	return ac.permissionTargetRequest(ptName, false)
 }
 func (ac *mockArtifactoryClient) CreateToken(tokenReq TokenCreateEntry, role *RoleStorageEntry) (auth.CreateTokenResponseData, error) {
-	return auth.CreateTokenResponseData{}, nil
+	if ac.failCreateToken {
+		return auth.CreateTokenResponseData{}, fmt.Errorf("token creation failed")
+	}
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.issuedTokens++
+
+	resp := auth.CreateTokenResponseData{
+		CommonTokenParams: auth.CommonTokenParams{AccessToken: "mocktoken"},
+		TokenId:           fmt.Sprintf("mocktoken-%d", ac.issuedTokens),
+	}
+	if role.IncludeReferenceToken {
+		resp.ReferenceToken = "mockreftoken"
+	}
+	return resp, nil
+}
+
+func (ac *mockArtifactoryClient) ArtifactoryVersion() (string, error) {
+	if ac.version == "" {
+		return "7.77.3", nil
+	}
+	return ac.version, nil
+}
+
+func (ac *mockArtifactoryClient) RevokeToken(tokenID string) error {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.revokedTokens = append(ac.revokedTokens, tokenID)
+	return nil
+}
+
+func (ac *mockArtifactoryClient) ChangePassword(username, oldPassword, newPassword string) error {
+	if ac.changePasswordHook != nil {
+		ac.changePasswordHook()
+	}
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if ac.failChangePassword {
+		return fmt.Errorf("password change rejected")
+	}
+	if ac.passwords == nil {
+		ac.passwords = map[string]string{}
+	}
+	ac.passwords[username] = newPassword
+	return nil
+}
+
+func (ac *mockArtifactoryClient) RepositoryExists(repoKey string) (bool, error) {
+	return !slices.Contains(ac.missingRepositories, repoKey), nil
+}
+func (ac *mockArtifactoryClient) GroupExists(name string) (bool, error) {
+	return !slices.Contains(ac.missingGroups, name), nil
+}
+
+func (ac *mockArtifactoryClient) GetGroupsPermissionTargets(groups []string) (map[string][]PermissionTarget, error) {
+	pts := make(map[string][]PermissionTarget, len(groups))
+	for _, group := range groups {
+		if groupPts, ok := ac.groupPermissionTargets[group]; ok {
+			pts[group] = groupPts
+		}
+	}
+	return pts, nil
 }
 
 // getAccClient returns the underlying artifactory services manager for full access to the Artifactory API.
@@ -20,17 +20,38 @@ import (
 	"sync"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
 	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/helper/salt"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
 // ArtifactoryBackend is the backend for artifactory plugin
 type ArtifactoryBackend struct {
 	*framework.Backend
-	view      logical.Storage
-	client    Client
+	view   logical.Storage
+	client Client
+
+	// newClient creates the Artifactory client from the config, replaced in tests
+	newClient func(ctx context.Context, config *ConfigStorageEntry, sysView logical.SystemView) (Client, error)
+
 	lock      sync.RWMutex
 	roleLocks []*locksutil.LockEntry
+
+	// configLock serializes config writes, deletions and password rotations, so that the
+	// stored password is the last one set in Artifactory and a config write doesn't put
+	// back the password it read before a rotation
+	configLock sync.Mutex
+
+	// tokenLocks serialize the token issuance of a role to enforce its quotas
+	tokenLocks []*locksutil.LockEntry
+
+	// backendUUID is the UUID of the mount, a source of its default object prefix
+	backendUUID string
+
+	// salt keys the fingerprints of the admin credentials, loaded on first use
+	salt     *salt.Salt
+	saltLock sync.RWMutex
 }
 
 func (b *ArtifactoryBackend) getClient(ctx context.Context, s logical.Storage) (Client, error) {
@@ -55,7 +76,7 @@ func (b *ArtifactoryBackend) getClient(ctx context.Context, s logical.Storage) (
 		return nil, err
 	}
 
-	c, err := NewClient(config)
+	c, err := b.newClient(ctx, config, b.System())
 	if err != nil {
 		return nil, err
 	}
@@ -75,6 +96,87 @@ func (b *ArtifactoryBackend) invalidate(ctx context.Context, key string) {
 	switch key {
 	case configPrefix:
 		b.reset()
+	case salt.DefaultLocation:
+		b.saltLock.Lock()
+		b.salt = nil
+		b.saltLock.Unlock()
+	}
+}
+
+// getSalt returns the salt of the mount, creating it on first use
+func (b *ArtifactoryBackend) getSalt(ctx context.Context, s logical.Storage) (*salt.Salt, error) {
+	b.saltLock.RLock()
+	if b.salt != nil {
+		defer b.saltLock.RUnlock()
+		return b.salt, nil
+	}
+	b.saltLock.RUnlock()
+
+	b.saltLock.Lock()
+	defer b.saltLock.Unlock()
+	if b.salt != nil {
+		return b.salt, nil
+	}
+
+	mountSalt, err := salt.NewSalt(ctx, s, &salt.Config{
+		HashFunc: salt.SHA256Hash,
+		Location: salt.DefaultLocation,
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.salt = mountSalt
+	return mountSalt, nil
+}
+
+// initialize runs once the backend is mounted and its storage is available. It upgrades
+// the stored entries to the current schema, then renames the Artifactory objects of roles.
+func (b *ArtifactoryBackend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
+	if !b.WriteSafeReplicationState() {
+		return nil
+	}
+
+	if err := b.migrateStorageSchema(ctx, req.Storage); err != nil {
+		// entries left behind are upgraded on read and retried on the next run
+		b.Logger().Warn("unable to upgrade the schema of storage entries", "error", err)
+	}
+	_, err := b.migrateRoleObjectNames(ctx, req.Storage)
+	return err
+}
+
+// periodicFunc prunes the records of expired tokens. The records are local to each
+// cluster, so performance secondaries prune their own.
+func (b *ArtifactoryBackend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	if !b.localWriteSafeReplicationState() {
+		return nil
+	}
+	return b.pruneIssuedTokens(ctx, req.Storage)
+}
+
+// localWriteSafeReplicationState returns true if the node can write to local storage,
+// which unlike replicated storage includes the active node of a performance secondary
+func (b *ArtifactoryBackend) localWriteSafeReplicationState() bool {
+	return !b.System().ReplicationState().HasState(consts.ReplicationDRSecondary | consts.ReplicationPerformanceStandby)
+}
+
+// forwardedOperation is an operation that changes Artifactory objects before writing to
+// replicated storage. It is forwarded to the active node of the primary cluster up front,
+// rather than on its first storage write, which would apply the Artifactory changes twice.
+func forwardedOperation(callback framework.OperationFunc) *framework.PathOperation {
+	return &framework.PathOperation{
+		Callback:                    callback,
+		ForwardPerformanceStandby:   true,
+		ForwardPerformanceSecondary: true,
+	}
+}
+
+// localOperation is an operation that changes Artifactory tokens and writes only to local
+// storage. Performance secondaries handle it themselves, standbys forward it to their
+// active node.
+func localOperation(callback framework.OperationFunc) *framework.PathOperation {
+	return &framework.PathOperation{
+		Callback:                  callback,
+		ForwardPerformanceStandby: true,
 	}
 }
 
@@ -90,20 +192,47 @@ func Factory(ctx context.Context, c *logical.BackendConfig) (logical.Backend, er
 // Backend export the function to create backend and configure
 func Backend(conf *logical.BackendConfig) *ArtifactoryBackend {
 	backend := &ArtifactoryBackend{
-		view:      conf.StorageView,
-		roleLocks: locksutil.CreateLocks(),
+		view:        conf.StorageView,
+		newClient:   NewClient,
+		roleLocks:   locksutil.CreateLocks(),
+		tokenLocks:  locksutil.CreateLocks(),
+		backendUUID: conf.BackendUUID,
 	}
 
 	backend.Backend = &framework.Backend{
 		BackendType: logical.TypeLogical,
 		Help:        strings.TrimSpace(backendHelp),
+		PathsSpecial: &logical.Paths{
+			// the config holds the admin credentials of Artifactory
+			SealWrapStorage: []string{
+				configPrefix,
+			},
+			// token issuance is handled by each cluster, which tracks the quotas and
+			// the issued tokens of its roles on its own
+			LocalStorage: []string{
+				tokenUsagePrefix + "/",
+				issuedTokensPrefix + "/",
+			},
+		},
 		Paths: framework.PathAppend(
 			pathConfig(backend),
+			pathConfigRotateRoot(backend),
 			pathRole(backend),
 			pathRoleList(backend),
+			pathRoleCheck(backend),
+			pathRoleHistory(backend),
+			pathRoleTemplate(backend),
+			pathRolesSync(backend),
+			pathRolesExport(backend),
 			pathToken(backend),
+			pathTokenIssued(backend),
 		),
-		Invalidate: backend.invalidate,
+		Secrets: []*framework.Secret{
+			secretDockerConfigJSON(backend),
+		},
+		Invalidate:     backend.invalidate,
+		InitializeFunc: backend.initialize,
+		PeriodicFunc:   backend.periodicFunc,
 	}
 
 	return backend
@@ -16,9 +16,13 @@ package artifactorysecrets
 import (
 	"context"
 	"os"
+	"slices"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/vault/sdk/helper/consts"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -32,12 +36,29 @@ func getTestBackend(t *testing.T, mockArtifactory bool) (logical.Backend, logica
 	require.NoError(t, err, "unable to create backend")
 
 	if mockArtifactory {
-		b.(*ArtifactoryBackend).client = &mockArtifactoryClient{}
+		useMockClient(b, &mockArtifactoryClient{})
 	}
 
 	return b, config.StorageView
 }
 
+// getMockClient returns the mock client of the backend
+func getMockClient(t *testing.T, b logical.Backend) *mockArtifactoryClient {
+	t.Helper()
+	client, err := b.(*ArtifactoryBackend).newClient(context.Background(), nil, nil)
+	require.NoError(t, err)
+	return client.(*mockArtifactoryClient)
+}
+
+// useMockClient makes the backend use the mock client, including after it is reset
+func useMockClient(b logical.Backend, client *mockArtifactoryClient) {
+	backend := b.(*ArtifactoryBackend)
+	backend.client = client
+	backend.newClient = func(context.Context, *ConfigStorageEntry, logical.SystemView) (Client, error) {
+		return client, nil
+	}
+}
+
 // newArtAccEnv returns a new request and test backend with a real Artifactory configured
 func newArtAccEnv(t *testing.T) (*logical.Request, logical.Backend) {
 	t.Helper()
@@ -71,3 +92,206 @@ func newArtMockEnv(t *testing.T) (*logical.Request, logical.Backend) {
 	return req, backend
 
 }
+
+func TestInitializeMigratesPermissionTargetNames(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, b := newArtMockEnv(t)
+
+	legacy := RoleStorageEntry{
+		Name:                 "legacy_role",
+		RoleID:               roleID("legacy_role"),
+		RawPermissionTargets: `[{"repo": {"repositories": ["ANY"], "operations": ["read"]}}]`,
+		PermissionTargets: []PermissionTarget{
+			{Repo: &Permission{Repositories: []string{"ANY"}, Operations: []string{"read"}}},
+		},
+	}
+	require.NoError(t, legacy.save(ctx, req))
+	assert.Equal(t, []string{"vault-plugin.pt0.legacy_role"}, legacy.permissionTargetNames())
+
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: req.Storage}))
+
+	role, err := getRoleEntry(ctx, req.Storage, "legacy_role")
+	require.NoError(t, err)
+	assert.Equal(t, permissionTargetNames("", "legacy_role", legacy.PermissionTargets), role.PermissionTargetNames)
+	assert.Equal(t, legacy.Version, role.Version, "migrations should not bump the version")
+
+	versions, err := listRoleRevisionVersions(ctx, req.Storage, "legacy_role")
+	require.NoError(t, err)
+	assert.Equal(t, []int{legacy.Version}, versions, "migrations should not record a revision")
+}
+
+func TestInitializeMigratesStorageSchema(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, b := newArtMockEnv(t)
+
+	require.NoError(t, req.Storage.Put(ctx, &logical.StorageEntry{Key: configPrefix, Value: []byte(configFormatBaseline)}))
+	require.NoError(t, req.Storage.Put(ctx, &logical.StorageEntry{Key: "roles/legacy", Value: []byte(roleFormatBaseline)}))
+
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: req.Storage}))
+
+	cfg, err := b.(*ArtifactoryBackend).getConfig(ctx, req.Storage)
+	require.NoError(t, err)
+	assert.Equal(t, configSchemaVersion, cfg.SchemaVersion)
+
+	role, err := getRoleEntry(ctx, req.Storage, "legacy")
+	require.NoError(t, err)
+	assert.Equal(t, roleSchemaVersion, role.SchemaVersion)
+	assert.Equal(t, permissionTargetNames("", "legacy", role.PermissionTargets), role.PermissionTargetNames,
+		"the permission targets of an upgraded role should be renamed")
+}
+
+func TestPathsSpecial(t *testing.T) {
+	t.Parallel()
+	b := Backend(logical.TestBackendConfig())
+
+	special := b.SpecialPaths()
+	require.NotNil(t, special)
+	assert.Equal(t, []string{configPrefix}, special.SealWrapStorage)
+	assert.ElementsMatch(t, []string{"token-usage/", "tokens/"}, special.LocalStorage)
+}
+
+func TestReplicationForwarding(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		state      consts.ReplicationState
+		localMount bool
+		operation  logical.Operation
+		path       string
+		data       map[string]interface{}
+		forwarded  bool
+	}{
+		{name: "standby_config_write", state: consts.ReplicationPerformanceStandby, operation: logical.UpdateOperation, path: "config", data: map[string]interface{}{"max_ttl": "60s"}, forwarded: true},
+		{name: "standby_config_read", state: consts.ReplicationPerformanceStandby, operation: logical.ReadOperation, path: "config"},
+		{name: "standby_role_create", state: consts.ReplicationPerformanceStandby, operation: logical.CreateOperation, path: "roles/new_role", forwarded: true},
+		{name: "standby_role_read", state: consts.ReplicationPerformanceStandby, operation: logical.ReadOperation, path: "roles/test_role"},
+		{name: "standby_token", state: consts.ReplicationPerformanceStandby, operation: logical.UpdateOperation, path: "token/test_role", forwarded: true},
+		{name: "secondary_role_update", state: consts.ReplicationPerformanceSecondary, operation: logical.UpdateOperation, path: "roles/test_role", forwarded: true},
+		{name: "secondary_role_patch", state: consts.ReplicationPerformanceSecondary, operation: logical.PatchOperation, path: "roles/test_role", forwarded: true},
+		{name: "secondary_role_delete", state: consts.ReplicationPerformanceSecondary, operation: logical.DeleteOperation, path: "roles/test_role", forwarded: true},
+		{name: "secondary_rollback", state: consts.ReplicationPerformanceSecondary, operation: logical.UpdateOperation, path: "roles/test_role/rollback", data: map[string]interface{}{"version": 1}, forwarded: true},
+		{name: "secondary_template_write", state: consts.ReplicationPerformanceSecondary, operation: logical.UpdateOperation, path: "role-templates/tmpl", forwarded: true},
+		{name: "secondary_template_delete", state: consts.ReplicationPerformanceSecondary, operation: logical.DeleteOperation, path: "role-templates/tmpl", forwarded: true},
+		{name: "secondary_sync", state: consts.ReplicationPerformanceSecondary, operation: logical.UpdateOperation, path: rolesSyncPath, forwarded: true},
+		{name: "secondary_import", state: consts.ReplicationPerformanceSecondary, operation: logical.UpdateOperation, path: importPath, forwarded: true},
+		{name: "secondary_config_write", state: consts.ReplicationPerformanceSecondary, operation: logical.UpdateOperation, path: "config", data: map[string]interface{}{"max_ttl": "60s"}, forwarded: true},
+		{name: "secondary_token", state: consts.ReplicationPerformanceSecondary, operation: logical.UpdateOperation, path: "token/test_role"},
+		{name: "secondary_local_mount_role_update", state: consts.ReplicationPerformanceSecondary, localMount: true, operation: logical.UpdateOperation, path: "roles/test_role", data: map[string]interface{}{"groups": "group2"}},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			req, b := newArtMockEnv(t)
+			testConfigUpdate(t, b, req.Storage, map[string]interface{}{
+				"base_url":     "https://example.jfrog.io/example",
+				"bearer_token": "mybearertoken",
+			})
+			mustRoleCreate(req, b, t, "test_role", map[string]interface{}{
+				"groups": []string{"group1"},
+			})
+
+			sysView := b.(*ArtifactoryBackend).System().(*logical.StaticSystemView)
+			sysView.ReplicationStateVal = test.state
+			sysView.LocalMountVal = test.localMount
+
+			req.Operation = test.operation
+			req.Path = test.path
+			req.Data = test.data
+			resp, err := b.HandleRequest(context.Background(), req)
+			if test.forwarded {
+				assert.ErrorIs(t, err, logical.ErrReadOnly)
+				return
+			}
+			require.NoError(t, err)
+			require.False(t, resp.IsError(), "unexpected error response %v", resp)
+		})
+	}
+}
+
+func TestPeriodicFuncReplication(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		state  consts.ReplicationState
+		pruned bool
+	}{
+		{name: "primary", pruned: true},
+		{name: "performance_secondary", state: consts.ReplicationPerformanceSecondary, pruned: true},
+		{name: "performance_standby", state: consts.ReplicationPerformanceStandby},
+		{name: "dr_secondary", state: consts.ReplicationDRSecondary},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			req, b := newArtMockEnv(t)
+			backend := b.(*ArtifactoryBackend)
+			backend.System().(*logical.StaticSystemView).ReplicationStateVal = test.state
+
+			expired := IssuedTokenEntry{
+				TokenID:   "expired-token",
+				RoleName:  "test_role",
+				IssuedAt:  time.Now().Add(-2 * time.Hour),
+				ExpiresAt: time.Now().Add(-time.Hour),
+			}
+			require.NoError(t, expired.save(ctx, req.Storage))
+
+			require.NoError(t, backend.periodicFunc(ctx, req))
+
+			token, err := getIssuedTokenEntry(ctx, req.Storage, "test_role", "expired-token")
+			require.NoError(t, err)
+			assert.Equal(t, test.pruned, token == nil)
+		})
+	}
+}
+
+func TestRoleDeleteReplicationWarning(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		state      consts.ReplicationState
+		localMount bool
+		warned     bool
+	}{
+		{name: "unreplicated"},
+		{name: "performance_primary", state: consts.ReplicationPerformancePrimary, warned: true},
+		{name: "performance_primary_local_mount", state: consts.ReplicationPerformancePrimary, localMount: true},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			req, b := newArtMockEnv(t)
+			testConfigUpdate(t, b, req.Storage, map[string]interface{}{
+				"base_url":     "https://example.jfrog.io/example",
+				"bearer_token": "mybearertoken",
+			})
+			mustRoleCreate(req, b, t, "test_role", map[string]interface{}{
+				"groups": []string{"group1"},
+			})
+
+			sysView := b.(*ArtifactoryBackend).System().(*logical.StaticSystemView)
+			sysView.ReplicationStateVal = test.state
+			sysView.LocalMountVal = test.localMount
+
+			resp, err := testRoleDelete(req, b, t, "test_role")
+			require.NoError(t, err)
+			var warnings []string
+			if resp != nil {
+				require.False(t, resp.IsError(), "unexpected error response %v", resp)
+				warnings = resp.Warnings
+			}
+			assert.Equal(t, test.warned, slices.Contains(warnings, remoteTokensWarning))
+		})
+	}
+}
@@ -18,11 +18,16 @@ import (
 	"context"
 	"time"
 
+	"github.com/hashicorp/vault/sdk/helper/pluginidentityutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
 const (
 	configPrefix = "config"
+
+	authTypeOIDC             = "oidc"
+	authTypeBearerToken      = "bearer_token"
+	authTypeUsernamePassword = "username_password"
 )
 
 // ConfigStorageEntry structure represents the config as it is stored within vault
@@ -33,6 +38,37 @@ type ConfigStorageEntry struct {
 	Password      string        `json:"password" structs:"password" mapstructure:"password"`
 	MaxTTL        time.Duration `json:"max_ttl" structs:"max_ttl" mapstructure:"max_ttl"`
 	ClientTimeout time.Duration `json:"client_timeout" structs:"client_timeout" mapstructure:"client_timeout"`
+
+	// MaxParallelRequests bounds the concurrent Artifactory requests applying the permission
+	// targets of a role. Configs saved before it was introduced hold 0, meaning sequential.
+	MaxParallelRequests int `json:"max_parallel_requests" structs:"max_parallel_requests" mapstructure:"max_parallel_requests"`
+
+	// ObjectPrefix is part of the names of the groups and permission targets created by the
+	// mount. Configs saved before it was introduced hold "", naming objects without a prefix.
+	ObjectPrefix string `json:"object_prefix" structs:"object_prefix" mapstructure:"object_prefix"`
+
+	// AccessURL overrides the URL of the Access service, derived from BaseURL otherwise
+	AccessURL string `json:"access_url" structs:"access_url" mapstructure:"access_url"`
+
+	// ProxyURL is the proxy of the requests to Artifactory, except for the hosts matching
+	// NoProxy. The proxy of the environment is used without it.
+	ProxyURL string `json:"proxy_url" structs:"proxy_url" mapstructure:"proxy_url"`
+	NoProxy  string `json:"no_proxy" structs:"no_proxy" mapstructure:"no_proxy"`
+
+	// OIDCProviderName is the Artifactory OIDC integration exchanging plugin identity tokens
+	// for access tokens. When set, the client authenticates without stored credentials.
+	OIDCProviderName string `json:"oidc_provider_name" structs:"oidc_provider_name" mapstructure:"oidc_provider_name"`
+
+	pluginidentityutil.PluginIdentityTokenParams
+
+	// OperationPresets are the operation presets of the mount, next to the built-in ones
+	OperationPresets map[string][]string `json:"operation_presets,omitempty" structs:"operation_presets" mapstructure:"operation_presets"`
+
+	// ExpiryWarningWindow is how long before the expiry of bearer_token config reads warn
+	ExpiryWarningWindow time.Duration `json:"expiry_warning_window" structs:"expiry_warning_window" mapstructure:"expiry_warning_window"`
+
+	// SchemaVersion is the version of the stored format of the config
+	SchemaVersion int `json:"schema_version" structs:"schema_version" mapstructure:"schema_version"`
 }
 
 func (backend *ArtifactoryBackend) getConfig(ctx context.Context, s logical.Storage) (*ConfigStorageEntry, error) {
@@ -45,9 +81,103 @@ func (backend *ArtifactoryBackend) getConfig(ctx context.Context, s logical.Stor
 		return nil, nil
 	}
 
-	if err := cfgRaw.DecodeJSON(&cfg); err != nil {
+	if err := decodeEntry(cfgRaw, upgradeConfig, &cfg); err != nil {
 		return nil, err
 	}
 
 	return &cfg, err
 }
+
+// authType returns the kind of credential the client authenticates with, following the
+// precedence of NewClient. It is empty without credentials.
+func (cfg *ConfigStorageEntry) authType() string {
+	switch {
+	case cfg.OIDCProviderName != "":
+		return authTypeOIDC
+	case cfg.BearerToken != "":
+		return authTypeBearerToken
+	case cfg.Username != "" && cfg.Password != "":
+		return authTypeUsernamePassword
+	default:
+		return ""
+	}
+}
+
+// credential returns the stored admin credential the client authenticates with, empty for
+// plugin workload identity
+func (cfg *ConfigStorageEntry) credential() string {
+	switch cfg.authType() {
+	case authTypeBearerToken:
+		return cfg.BearerToken
+	case authTypeUsernamePassword:
+		return cfg.Username + ":" + cfg.Password
+	default:
+		return ""
+	}
+}
+
+// credentialFingerprint returns a non-reversible fingerprint of a credential, an HMAC keyed
+// with the salt of the mount, to tell credentials apart without revealing them
+func (backend *ArtifactoryBackend) credentialFingerprint(ctx context.Context, s logical.Storage, credential string) (string, error) {
+	mountSalt, err := backend.getSalt(ctx, s)
+	if err != nil {
+		return "", err
+	}
+	return mountSalt.GetIdentifiedHMAC(credential), nil
+}
+
+// operationPresets returns the presets usable in permission target operations. Presets of
+// the mount take precedence over the built-in ones of the same name.
+func (cfg *ConfigStorageEntry) operationPresets() map[string][]string {
+	presets := make(map[string][]string, len(builtinOperationPresets)+len(cfg.OperationPresets))
+	for name, ops := range builtinOperationPresets {
+		presets[name] = ops
+	}
+	for name, ops := range cfg.OperationPresets {
+		presets[name] = ops
+	}
+	return presets
+}
+
+// accessURL returns the URL of the Access service
+func (cfg *ConfigStorageEntry) accessURL() string {
+	if cfg.AccessURL != "" {
+		return ensureAccessURL(cfg.AccessURL)
+	}
+	return ensureAccessURL(cfg.BaseURL)
+}
+
+func saveConfig(ctx context.Context, s logical.Storage, cfg *ConfigStorageEntry) error {
+	cfg.SchemaVersion = configSchemaVersion
+	entry, err := logical.StorageEntryJSON(configPrefix, cfg)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// parallelRequests returns the number of concurrent Artifactory requests allowed when
+// applying permission targets, at least 1
+func (backend *ArtifactoryBackend) parallelRequests(ctx context.Context, s logical.Storage) (int, error) {
+	cfg, err := backend.getConfig(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	if cfg == nil || cfg.MaxParallelRequests < 1 {
+		return 1, nil
+	}
+	return cfg.MaxParallelRequests, nil
+}
+
+// objectPrefix returns the object prefix naming the Artifactory objects of the mount
+func (backend *ArtifactoryBackend) objectPrefix(ctx context.Context, s logical.Storage) (string, error) {
+	cfg, err := backend.getConfig(ctx, s)
+	if err != nil {
+		return "", err
+	}
+	if cfg == nil {
+		return "", nil
+	}
+	return cfg.ObjectPrefix, nil
+}
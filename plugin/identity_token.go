@@ -0,0 +1,111 @@
+// Copyright  2024 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/pluginutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	oidcTokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	oidcSubjectTokenType       = "urn:ietf:params:oauth:token-type:id_token"
+	oidcTokenEndpoint          = "api/v1/oidc/token"
+)
+
+// oidcTokenExchangeRequest is the body of a request to the OIDC token endpoint of Artifactory
+type oidcTokenExchangeRequest struct {
+	GrantType        string `json:"grant_type"`
+	SubjectTokenType string `json:"subject_token_type"`
+	SubjectToken     string `json:"subject_token"`
+	ProviderName     string `json:"provider_name"`
+}
+
+// oidcTokenExchangeResponse is the access token returned by the OIDC token endpoint of Artifactory
+type oidcTokenExchangeResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+	TokenType   string `json:"token_type"`
+}
+
+// exchangeIdentityToken requests a plugin identity token from Vault and exchanges it for an
+// Artifactory access token at the OIDC integration named in the config. It returns the
+// access token and its expiry, zero if the token doesn't expire.
+func exchangeIdentityToken(ctx context.Context, config *ConfigStorageEntry, sysView logical.SystemView, httpClient *http.Client) (string, time.Time, error) {
+	if sysView == nil {
+		return "", time.Time{}, fmt.Errorf("plugin identity tokens are not available")
+	}
+
+	identityToken, err := sysView.GenerateIdentityToken(ctx, &pluginutil.IdentityTokenRequest{
+		Audience: config.IdentityTokenAudience,
+		TTL:      config.IdentityTokenTTL,
+	})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate plugin identity token - %w", err)
+	}
+
+	body, err := json.Marshal(oidcTokenExchangeRequest{
+		GrantType:        oidcTokenExchangeGrantType,
+		SubjectTokenType: oidcSubjectTokenType,
+		SubjectToken:     identityToken.Token.Token(),
+		ProviderName:     config.OIDCProviderName,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, config.accessURL()+oidcTokenEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	now := time.Now()
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to exchange plugin identity token - %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to read the OIDC token exchange response - %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("failed to exchange plugin identity token - artifactory responded with %s: %s", resp.Status, respBody)
+	}
+
+	var exchanged oidcTokenExchangeResponse
+	if err := json.Unmarshal(respBody, &exchanged); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to parse the OIDC token exchange response - %w", err)
+	}
+	if exchanged.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("OIDC token exchange response has no access token")
+	}
+
+	var expiresAt time.Time
+	if exchanged.ExpiresIn > 0 {
+		expiresAt = now.Add(time.Duration(exchanged.ExpiresIn) * time.Second)
+	}
+	return exchanged.AccessToken, expiresAt, nil
+}
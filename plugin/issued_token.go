@@ -0,0 +1,209 @@
+// Copyright  2024 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	issuedTokensPrefix = "tokens"
+)
+
+// IssuedTokenEntry records a token issued under a role until it expires
+type IssuedTokenEntry struct {
+	TokenID  string `json:"token_id"`
+	RoleName string `json:"role_name"`
+
+	// Subject is the "sub" claim of the token, empty if the token couldn't be parsed
+	Subject  string `json:"subject,omitempty"`
+	Username string `json:"username"`
+
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// EntityID is the identity entity of the requester, empty for root tokens
+	EntityID string `json:"entity_id,omitempty"`
+
+	// Leased is true for tokens returned as a Vault lease (dockerconfigjson format).
+	// Vault assigns the lease ID only after the plugin responds, so the lease links to
+	// the record through the token ID in its internal data rather than the other way.
+	Leased bool `json:"leased,omitempty"`
+}
+
+func issuedTokenKey(roleName, tokenID string) string {
+	return fmt.Sprintf("%s/%s/%s", issuedTokensPrefix, roleName, tokenID)
+}
+
+func (token IssuedTokenEntry) save(ctx context.Context, storage logical.Storage) error {
+	entry, err := logical.StorageEntryJSON(issuedTokenKey(token.RoleName, token.TokenID), token)
+	if err != nil {
+		return err
+	}
+
+	return storage.Put(ctx, entry)
+}
+
+// getIssuedTokenEntry fetches an issued token record from the storage
+func getIssuedTokenEntry(ctx context.Context, storage logical.Storage, roleName, tokenID string) (*IssuedTokenEntry, error) {
+	var result IssuedTokenEntry
+	if entry, err := storage.Get(ctx, issuedTokenKey(roleName, tokenID)); err != nil {
+		return nil, err
+	} else if entry == nil {
+		return nil, nil
+	} else if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// deleteIssuedTokenEntry removes an issued token record
+func deleteIssuedTokenEntry(ctx context.Context, storage logical.Storage, roleName, tokenID string) error {
+	return storage.Delete(ctx, issuedTokenKey(roleName, tokenID))
+}
+
+// listIssuedTokenEntries returns the unexpired tokens issued under a role. Expired records
+// are skipped, they are only deleted by pruneIssuedTokens so that listing doesn't write to
+// storage.
+func listIssuedTokenEntries(ctx context.Context, storage logical.Storage, roleName string, now time.Time) ([]*IssuedTokenEntry, error) {
+	tokenIDs, err := storage.List(ctx, fmt.Sprintf("%s/%s/", issuedTokensPrefix, roleName))
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]*IssuedTokenEntry, 0, len(tokenIDs))
+	for _, tokenID := range tokenIDs {
+		token, err := getIssuedTokenEntry(ctx, storage, roleName, tokenID)
+		if err != nil {
+			return nil, err
+		}
+		if token == nil || !token.ExpiresAt.After(now) {
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// pruneIssuedTokens removes the records of expired tokens of every role
+func (backend *ArtifactoryBackend) pruneIssuedTokens(ctx context.Context, storage logical.Storage) error {
+	roleNames, err := storage.List(ctx, issuedTokensPrefix+"/")
+	if err != nil {
+		return err
+	}
+
+	var merr *multierror.Error
+	now := time.Now()
+	for _, roleName := range roleNames {
+		// role names are listed as "<name>/"
+		roleName = roleName[:len(roleName)-1]
+		if err := pruneRoleIssuedTokens(ctx, storage, roleName, now); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("failed to prune issued tokens of role %s - %s", roleName, err.Error()))
+		}
+	}
+	return merr.ErrorOrNil()
+}
+
+// pruneRoleIssuedTokens removes the records of the tokens of a role expired as of now
+func pruneRoleIssuedTokens(ctx context.Context, storage logical.Storage, roleName string, now time.Time) error {
+	tokenIDs, err := storage.List(ctx, fmt.Sprintf("%s/%s/", issuedTokensPrefix, roleName))
+	if err != nil {
+		return err
+	}
+
+	for _, tokenID := range tokenIDs {
+		token, err := getIssuedTokenEntry(ctx, storage, roleName, tokenID)
+		if err != nil {
+			return err
+		}
+		if token == nil || token.ExpiresAt.After(now) {
+			continue
+		}
+		if err := deleteIssuedTokenEntry(ctx, storage, roleName, tokenID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remoteTokensWarning is reported along with the revocation of the tokens of a role on
+// a performance primary, as each cluster records the tokens it issued in local storage
+const remoteTokensWarning = "only the tokens issued by this cluster were revoked, tokens issued by performance secondaries expire on their own"
+
+// tokensIssuedElsewhere returns true if other clusters may have issued tokens of the
+// roles of the mount, which revocations can't reach
+func (backend *ArtifactoryBackend) tokensIssuedElsewhere() bool {
+	return !backend.System().LocalMount() && backend.System().ReplicationState().HasState(consts.ReplicationPerformancePrimary)
+}
+
+// revokeRoleTokens revokes every unexpired token issued under a role. role is nil once
+// the role is deleted.
+func (backend *ArtifactoryBackend) revokeRoleTokens(ctx context.Context, storage logical.Storage, roleName string, role *RoleStorageEntry) (int, error) {
+	tokens, err := listIssuedTokenEntries(ctx, storage, roleName, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	var merr *multierror.Error
+	revoked := 0
+	for _, token := range tokens {
+		if err := backend.revokeIssuedToken(ctx, storage, role, token); err != nil {
+			merr = multierror.Append(merr, err)
+			continue
+		}
+		revoked++
+	}
+
+	backend.Logger().Info("revoked tokens of role", "role_name", roleName, "revoked", revoked, "total", len(tokens))
+	return revoked, merr.ErrorOrNil()
+}
+
+// revokeIssuedToken revokes an issued token in Artifactory, removes its record and
+// releases its slot in the active tokens quota of the role
+func (backend *ArtifactoryBackend) revokeIssuedToken(ctx context.Context, storage logical.Storage, role *RoleStorageEntry, token *IssuedTokenEntry) error {
+	ac, err := backend.getClient(ctx, storage)
+	if err != nil {
+		return fmt.Errorf("failed to obtain artifactory client - %s", err.Error())
+	}
+	if err := ac.RevokeToken(token.TokenID); err != nil {
+		return fmt.Errorf("failed to revoke token %s - %s", token.TokenID, err.Error())
+	}
+
+	if err := deleteIssuedTokenEntry(ctx, storage, token.RoleName, token.TokenID); err != nil {
+		return err
+	}
+
+	if role == nil {
+		return nil
+	}
+
+	lock := backend.tokenUsageLock(role.Name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	usage, err := getTokenUsageEntry(ctx, storage, role, time.Now())
+	if err != nil {
+		return err
+	}
+	usage.releaseToken(token.ExpiresAt)
+	return usage.save(ctx, storage, role.Name)
+}
@@ -16,10 +16,20 @@ package artifactorysecrets
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/pluginidentityutil"
+	"github.com/hashicorp/vault/sdk/helper/pluginutil"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/jfrog-client-go/auth"
 )
 
 // schema for the configuring artifactory secrets plugin, this will map the fields coming in from the
@@ -29,6 +39,18 @@ var configSchema = map[string]*framework.FieldSchema{
 		Type:        framework.TypeString,
 		Description: `Artifactory base url. e.g. https://myjfrog.example.com/artifactory/`,
 	},
+	"access_url": {
+		Type:        framework.TypeString,
+		Description: `Artifactory Access service url, if it isn't served next to base_url. e.g. https://access.example.com/access/`,
+	},
+	"proxy_url": {
+		Type:        framework.TypeString,
+		Description: `Proxy of the requests to Artifactory, e.g. http://proxy.example.com:3128. If empty, the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables apply.`,
+	},
+	"no_proxy": {
+		Type:        framework.TypeString,
+		Description: `Comma-separated hosts, domains and CIDR ranges reached without proxy_url, in the format of NO_PROXY.`,
+	},
 	"bearer_token": {
 		Type:        framework.TypeString,
 		Description: `Artifactory token that has permissions to generate other tokens`,
@@ -51,8 +73,47 @@ var configSchema = map[string]*framework.FieldSchema{
 		Description: "Artifactory HTTP client timeout at Transport layer. If <=0, will use system default(30).",
 		Default:     30,
 	},
+	"max_parallel_requests": {
+		Type:        framework.TypeInt,
+		Description: "Maximum number of concurrent Artifactory requests when applying the permission targets of a role. Defaults to 1 (sequential).",
+		Default:     1,
+	},
+	"object_prefix": {
+		Type:        framework.TypeString,
+		Description: "Prefix naming the groups and permission targets created by this mount, so that mounts sharing an Artifactory don't collide. Defaults to a hash of the mount UUID and namespace-qualified path for new mounts. Changing it renames the objects of existing roles.",
+	},
+	"operation_presets": {
+		Type:        framework.TypeString,
+		Description: `JSON object naming lists of operations, usable in the operations of permission targets next to the built-in presets "reader", "deployer", "maintainer" and "admin". e.g. {"promoter": ["read", "annotate"]}`,
+	},
+	"expiry_warning_window": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Config reads warn when bearer_token expires within this window. If <= 0, will use system default(604800, a week).",
+		Default:     604800,
+	},
+	"force": {
+		Type:        framework.TypeBool,
+		Description: "Delete the config even though roles use it. Only used when deleting the config.",
+	},
+	"oidc_provider_name": {
+		Type:        framework.TypeString,
+		Description: "Name of the Artifactory OIDC integration exchanging plugin identity tokens for access tokens. Replaces bearer_token and username/password.",
+	},
+	"identity_token_audience": {
+		Type:        framework.TypeString,
+		Description: "Audience of the plugin identity tokens, as expected by the Artifactory OIDC integration. Required with oidc_provider_name.",
+	},
+	"identity_token_ttl": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Time-to-live of the plugin identity tokens. If <= 0, will use system default(3600).",
+		Default:     3600,
+	},
 }
 
+// objectPrefixRegex restricts object prefixes to characters valid in Artifactory group and
+// permission target names, short enough to leave room for the role name
+var objectPrefixRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{0,16}$`)
+
 func (backend *ArtifactoryBackend) pathConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	cfg, err := backend.getConfig(ctx, req.Storage)
 	if err != nil {
@@ -62,28 +123,128 @@ func (backend *ArtifactoryBackend) pathConfigRead(ctx context.Context, req *logi
 		return nil, nil
 	}
 
-	return &logical.Response{
+	resp := &logical.Response{
 		Data: map[string]interface{}{
-			"base_url":       cfg.BaseURL,
-			"max_ttl":        int64(cfg.MaxTTL / time.Second),
-			"client_timeout": int64(cfg.ClientTimeout / time.Second),
+			"base_url":              cfg.BaseURL,
+			"access_url":            cfg.AccessURL,
+			"proxy_url":             cfg.ProxyURL,
+			"no_proxy":              cfg.NoProxy,
+			"max_ttl":               int64(cfg.MaxTTL / time.Second),
+			"client_timeout":        int64(cfg.ClientTimeout / time.Second),
+			"max_parallel_requests": max(cfg.MaxParallelRequests, 1),
+			"object_prefix":         cfg.ObjectPrefix,
+			"operation_presets":     cfg.OperationPresets,
+			"oidc_provider_name":    cfg.OIDCProviderName,
+			"expiry_warning_window": int64(cfg.ExpiryWarningWindow / time.Second),
+			"auth_type":             cfg.authType(),
 		},
-	}, nil
+	}
+	cfg.PopulatePluginIdentityTokenData(resp.Data)
+	if defaultPrefix := defaultObjectPrefix(backend.backendUUID, req.MountPoint); cfg.ObjectPrefix == "" && defaultPrefix != "" {
+		resp.AddWarning(fmt.Sprintf("Artifactory objects of this mount are named without an object prefix and may collide with other mounts. Write object_prefix=%s to rename them.", defaultPrefix))
+	}
+
+	if credential := cfg.credential(); credential != "" {
+		fingerprint, err := backend.credentialFingerprint(ctx, req.Storage, credential)
+		if err != nil {
+			return nil, err
+		}
+		resp.Data["credential_fingerprint"] = fingerprint
+	}
+
+	if cfg.authType() == authTypeBearerToken {
+		addBearerTokenMetadata(resp, cfg, time.Now())
+	}
+	return resp, nil
+}
+
+// addBearerTokenMetadata adds the subject and expiry of bearer_token to a config read, with
+// a warning if it expires within the warning window. Reference tokens and other tokens
+// that aren't JWTs carry no metadata.
+func addBearerTokenMetadata(resp *logical.Response, cfg *ConfigStorageEntry, now time.Time) {
+	subject, err := auth.ExtractSubjectFromAccessToken(cfg.BearerToken)
+	if err != nil {
+		return
+	}
+	resp.Data["token_subject"] = subject
+
+	expiresAt, err := accessTokenExpiresAt(cfg.BearerToken)
+	if err != nil || expiresAt.IsZero() {
+		return
+	}
+	resp.Data["token_expires_at"] = expiresAt.Format(time.RFC3339)
+
+	if !expiresAt.After(now) {
+		resp.AddWarning(fmt.Sprintf("bearer_token expired at %s", expiresAt.Format(time.RFC3339)))
+	} else if expiresAt.Sub(now) <= cfg.ExpiryWarningWindow {
+		resp.AddWarning(fmt.Sprintf("bearer_token expires at %s, in less than %s", expiresAt.Format(time.RFC3339), cfg.ExpiryWarningWindow))
+	}
+}
+
+// accessTokenExpiresAt returns the exp claim of an access token, zero if it doesn't expire.
+// auth.ExtractExpiryFromAccessToken only reports the lifetime of the token.
+func accessTokenExpiresAt(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("access token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var claims struct {
+		ExpirationTime int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, err
+	}
+	if claims.ExpirationTime <= 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(claims.ExpirationTime, 0).UTC(), nil
 }
 
 func (backend *ArtifactoryBackend) pathConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	backend.configLock.Lock()
+	defer backend.configLock.Unlock()
+
 	cfg, err := backend.getConfig(ctx, req.Storage)
 	if err != nil {
 		return nil, err
 	}
 	if cfg == nil {
-		cfg = &ConfigStorageEntry{}
+		cfg = &ConfigStorageEntry{
+			ObjectPrefix: defaultObjectPrefix(backend.backendUUID, req.MountPoint),
+		}
 	}
 
 	if baseURL, ok := data.GetOk("base_url"); ok {
 		cfg.BaseURL = baseURL.(string)
 	}
 
+	if accessURL, ok := data.GetOk("access_url"); ok {
+		if accessURL.(string) != "" {
+			if u, err := url.Parse(accessURL.(string)); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return logical.ErrorResponse("access_url must be an http or https url"), nil
+			}
+		}
+		cfg.AccessURL = accessURL.(string)
+	}
+
+	if proxyURL, ok := data.GetOk("proxy_url"); ok {
+		if proxyURL.(string) != "" {
+			if _, err := parseProxyURL(proxyURL.(string)); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+		cfg.ProxyURL = proxyURL.(string)
+	}
+
+	if noProxy, ok := data.GetOk("no_proxy"); ok {
+		cfg.NoProxy = noProxy.(string)
+	}
+
 	if bearerToken, ok := data.GetOk("bearer_token"); ok {
 		cfg.BearerToken = bearerToken.(string)
 	}
@@ -110,27 +271,138 @@ func (backend *ArtifactoryBackend) pathConfigWrite(ctx context.Context, req *log
 		cfg.ClientTimeout = time.Duration(configSchema["client_timeout"].Default.(int)) * time.Second
 	}
 
-	entry, err := logical.StorageEntryJSON(configPrefix, cfg)
-	if err != nil {
+	if maxParallelRaw, ok := data.GetOk("max_parallel_requests"); ok {
+		if maxParallelRaw.(int) < 1 {
+			return logical.ErrorResponse("max_parallel_requests must be at least 1"), nil
+		}
+		cfg.MaxParallelRequests = maxParallelRaw.(int)
+	} else if cfg.MaxParallelRequests == 0 {
+		cfg.MaxParallelRequests = configSchema["max_parallel_requests"].Default.(int)
+	}
+
+	expiryWarningWindowRaw, ok := data.GetOk("expiry_warning_window")
+	if ok && expiryWarningWindowRaw.(int) > 0 {
+		cfg.ExpiryWarningWindow = time.Duration(expiryWarningWindowRaw.(int)) * time.Second
+	} else if cfg.ExpiryWarningWindow == time.Duration(0) {
+		cfg.ExpiryWarningWindow = time.Duration(configSchema["expiry_warning_window"].Default.(int)) * time.Second
+	}
+
+	if presetsRaw, ok := data.GetOk("operation_presets"); ok {
+		var presets map[string][]string
+		if presetsRaw.(string) != "" {
+			if err := json.Unmarshal([]byte(presetsRaw.(string)), &presets); err != nil {
+				return logical.ErrorResponse("Error unmarshal operation_presets. Expecting an object of operation lists - " + err.Error()), nil
+			}
+		}
+		if err := validateOperationPresets(presets); err != nil {
+			return logical.ErrorResponse("Failed to validate operation_presets - " + err.Error()), nil
+		}
+		cfg.OperationPresets = presets
+	}
+
+	if oidcProviderName, ok := data.GetOk("oidc_provider_name"); ok {
+		cfg.OIDCProviderName = oidcProviderName.(string)
+	}
+
+	if err := cfg.ParsePluginIdentityTokenFields(data); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if cfg.IdentityTokenTTL <= 0 {
+		cfg.IdentityTokenTTL = time.Duration(configSchema["identity_token_ttl"].Default.(int)) * time.Second
+	}
+
+	if cfg.OIDCProviderName != "" {
+		if cfg.IdentityTokenAudience == "" {
+			return logical.ErrorResponse("identity_token_audience is required with oidc_provider_name"), nil
+		}
+		if cfg.BearerToken != "" || cfg.Password != "" {
+			return logical.ErrorResponse("oidc_provider_name replaces the stored credentials, clear bearer_token and password to use it"), nil
+		}
+
+		// plugin identity tokens are only issued by Vault Enterprise
+		_, err := backend.System().GenerateIdentityToken(ctx, &pluginutil.IdentityTokenRequest{
+			Audience: cfg.IdentityTokenAudience,
+			TTL:      cfg.IdentityTokenTTL,
+		})
+		if errors.Is(err, pluginidentityutil.ErrPluginWorkloadIdentityUnsupported) {
+			return logical.ErrorResponse(err.Error()), nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to generate plugin identity token - %w", err)
+		}
+	}
+
+	if objectPrefixRaw, ok := data.GetOk("object_prefix"); ok {
+		if !objectPrefixRegex.MatchString(objectPrefixRaw.(string)) {
+			return logical.ErrorResponse("object_prefix must be at most 16 letters, digits, '-' or '_'"), nil
+		}
+		cfg.ObjectPrefix = objectPrefixRaw.(string)
+	}
+
+	if err := saveConfig(ctx, req.Storage, cfg); err != nil {
 		return nil, err
 	}
+	// the next client, used by the renames below already, picks up the new settings
+	backend.reset()
 
-	if err := req.Storage.Put(ctx, entry); err != nil {
+	// create the salt of credential fingerprints while the storage is writable
+	if _, err := backend.getSalt(ctx, req.Storage); err != nil {
 		return nil, err
 	}
 
+	// rename the objects of roles named with another prefix than the config's, which is
+	// the previous one or, after a forced config deletion, the one of the deleted config
+	failed, err := backend.migrateRoleObjectNames(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if len(failed) > 0 {
+		resp := &logical.Response{}
+		resp.AddWarning(fmt.Sprintf("unable to rename the Artifactory objects of roles %s, retried when the plugin is initialized", strings.Join(failed, ", ")))
+		return resp, nil
+	}
 	return nil, nil
 }
 
+// pathConfigDelete removes the config. Roles keep their Artifactory objects, which are only
+// updated or garbage collected again once a config is written.
+func (backend *ArtifactoryBackend) pathConfigDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	backend.configLock.Lock()
+	defer backend.configLock.Unlock()
+
+	roleNames, err := backend.listRoleEntries(ctx, req.Storage)
+	if err != nil {
+		return logical.ErrorResponse("Error listing roles"), err
+	}
+
+	force := data.Get("force").(bool)
+	if len(roleNames) > 0 && !force {
+		return logical.ErrorResponse(fmt.Sprintf("the config is used by roles %s, delete them first or set force=true", strings.Join(roleNames, ", "))), nil
+	}
+
+	if err := req.Storage.Delete(ctx, configPrefix); err != nil {
+		return nil, err
+	}
+	backend.reset()
+
+	backend.Logger().Info("deleted config", "roles", len(roleNames))
+	if len(roleNames) == 0 {
+		return nil, nil
+	}
+	resp := &logical.Response{}
+	resp.AddWarning(fmt.Sprintf("roles %s are kept, their Artifactory objects are only managed again once a config is written", strings.Join(roleNames, ", ")))
+	return resp, nil
+}
+
 func pathConfig(b *ArtifactoryBackend) []*framework.Path {
 	paths := []*framework.Path{
 		{
 			Pattern: configPrefix,
 			Fields:  configSchema,
 
-			Callbacks: map[logical.Operation]framework.OperationFunc{
-				logical.ReadOperation:   b.pathConfigRead,
-				logical.UpdateOperation: b.pathConfigWrite,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation:   &framework.PathOperation{Callback: b.pathConfigRead},
+				logical.UpdateOperation: forwardedOperation(b.pathConfigWrite),
+				logical.DeleteOperation: forwardedOperation(b.pathConfigDelete),
 			},
 
 			HelpSynopsis:    pathConfigHelpSyn,
@@ -151,5 +423,56 @@ and creating an access token for a group. This endpoint is used to configure
 those credentials as well as default values for the backend in general.
 
 If multiple credentials are provided, it takes precendence on following order. 
-Bearer Token -> API Key -> Username/Password
+OIDC Provider -> Bearer Token -> API Key -> Username/Password
+
+A username and password are exchanged for an admin access token valid for an
+hour, which authenticates every call. The password is exchanged again once the
+client expires. The user must be an admin, and Artifactory must allow token
+generation with a password ("Enable Token Generation via API", 7.63.2 or
+later). The password can be rotated with "config/rotate-root".
+
+"oidc_provider_name" authenticates without a stored admin credential. A plugin
+identity token with the "identity_token_audience" audience is requested from
+Vault (Enterprise only) and exchanged for a short-lived access token at the
+named Artifactory OIDC integration. The access token is exchanged again once a
+fifth of its lifetime is left. It can't be combined with "bearer_token" or
+"password".
+
+"max_parallel_requests" bounds the concurrent Artifactory requests creating,
+updating and deleting the permission targets of a role. Deletions still
+complete before creations and updates, which complete before the role is saved.
+
+"access_url" is the URL of the Artifactory Access service, when it isn't served
+at "/access" next to "base_url". "proxy_url" sends the requests to Artifactory
+and Access through a proxy, except for the hosts matching "no_proxy". Without
+"proxy_url", the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables of
+the plugin process apply. Requests to loopback addresses are never proxied.
+
+Reading the config reports the "auth_type" in use and a "credential_fingerprint",
+an HMAC of the stored credential keyed per mount, which changes whenever the
+credential does without revealing it. For a JWT "bearer_token", its subject and
+expiry are reported too, with a warning once it expires within
+"expiry_warning_window".
+
+Deleting the config is refused while roles exist, unless "force" is set. Roles
+are kept, but their Artifactory objects are only managed again once a config is
+written.
+
+"operation_presets" names lists of operations usable in the operations of
+permission targets, next to the built-in "reader" (read), "deployer" (read,
+annotate, write), "maintainer" (read, annotate, write, delete) and "admin"
+(read, annotate, write, delete, manage, managedXrayMeta). A preset of the mount
+replaces a built-in preset of the same name. Presets are expanded when roles
+and templates are written, so changing them applies to a role the next time it
+is written.
+
+"object_prefix" is part of the names of the groups and permission targets
+created by the mount, so that several mounts or clusters can share an
+Artifactory with the same role names. New mounts default to a hash of the mount
+UUID and its path, which includes the namespace. Mounts configured before
+object prefixes were introduced keep unprefixed names until "object_prefix" is
+written. Changing it renames the groups and permission targets of every role;
+tokens issued before the rename lose the permissions of the renamed group.
+Config writes also rename the objects of roles still named with another
+prefix, such as the roles kept from a config deleted with "force=true".
 `
@@ -0,0 +1,102 @@
+// Copyright  2024 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/base62"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	rotateRootPath = configPrefix + "/rotate-root"
+
+	// rotatedPasswordLength is the length of the alphanumeric passwords set by rotations
+	rotatedPasswordLength = 32
+)
+
+// pathConfigRotateRoot replaces the password of the configured user with a random one only
+// known to Vault
+func (backend *ArtifactoryBackend) pathConfigRotateRoot(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	backend.configLock.Lock()
+	defer backend.configLock.Unlock()
+
+	cfg, err := backend.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return logical.ErrorResponse("backend not configured"), nil
+	}
+	if cfg.Username == "" || cfg.Password == "" {
+		return logical.ErrorResponse("rotating the password requires a username and password in the config"), nil
+	}
+
+	ac, err := backend.getClient(ctx, req.Storage)
+	if err != nil {
+		return logical.ErrorResponse("failed to obtain artifactory client"), err
+	}
+
+	newPassword, err := base62.Random(rotatedPasswordLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate password - %w", err)
+	}
+	if err := ac.ChangePassword(cfg.Username, cfg.Password, newPassword); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to change the password of %s - %s", cfg.Username, err.Error())), nil
+	}
+
+	cfg.Password = newPassword
+	if err := saveConfig(ctx, req.Storage, cfg); err != nil {
+		// the stored password no longer works, the user has to be reset in Artifactory
+		return nil, fmt.Errorf("changed the password of %s in Artifactory but failed to save it - %w", cfg.Username, err)
+	}
+
+	// the next client exchanges the new password for its admin token
+	backend.reset()
+
+	backend.Logger().Info("rotated the password of the configured user", "username", cfg.Username)
+	return nil, nil
+}
+
+func pathConfigRotateRoot(backend *ArtifactoryBackend) []*framework.Path {
+	paths := []*framework.Path{
+		{
+			Pattern: rotateRootPath,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: forwardedOperation(backend.pathConfigRotateRoot),
+			},
+			HelpSynopsis:    pathConfigRotateRootHelpSyn,
+			HelpDescription: pathConfigRotateRootHelpDesc,
+		},
+	}
+
+	return paths
+}
+
+const pathConfigRotateRootHelpSyn = `
+Rotate the password of the configured Artifactory user.
+`
+
+const pathConfigRotateRootHelpDesc = `
+Replaces the password of the user in the config with a random alphanumeric
+password of 32 characters, through the Artifactory API, and saves it in the
+config. Afterwards, the password is only known to Vault.
+
+Requires "username" and "password" in the config. The password of the user must
+not be used outside of Vault once rotated.
+`
@@ -16,8 +16,13 @@ package artifactorysecrets
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/vault/sdk/helper/pluginidentityutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -43,9 +48,21 @@ func TestConfig(t *testing.T) {
 		testConfigUpdate(t, backend, reqStorage, conf)
 
 		expected := map[string]interface{}{
-			"base_url":       "https://example.jfrog.io/",
-			"client_timeout": int64(15),
-			"max_ttl":        int64(600),
+			"auth_type":               authTypeBearerToken,
+			"credential_fingerprint":  testCredentialFingerprint(t, backend, reqStorage, "mybearertoken"),
+			"expiry_warning_window":   int64(604800),
+			"base_url":                "https://example.jfrog.io/",
+			"access_url":              "",
+			"proxy_url":               "",
+			"no_proxy":                "",
+			"client_timeout":          int64(15),
+			"max_ttl":                 int64(600),
+			"max_parallel_requests":   1,
+			"object_prefix":           "",
+			"operation_presets":       map[string][]string(nil),
+			"oidc_provider_name":      "",
+			"identity_token_audience": "",
+			"identity_token_ttl":      int64(3600),
 		}
 
 		testConfigRead(t, backend, reqStorage, expected)
@@ -62,6 +79,13 @@ func TestConfig(t *testing.T) {
 
 		expected["client_timeout"] = int64(20)
 		testConfigRead(t, backend, reqStorage, expected)
+
+		testConfigUpdate(t, backend, reqStorage, map[string]interface{}{
+			"max_parallel_requests": 8,
+		})
+
+		expected["max_parallel_requests"] = 8
+		testConfigRead(t, backend, reqStorage, expected)
 	})
 
 	t.Run("user_pwd", func(t *testing.T) {
@@ -82,15 +106,166 @@ func TestConfig(t *testing.T) {
 		testConfigUpdate(t, backend, reqStorage, conf)
 
 		expected := map[string]interface{}{
-			"base_url":       "https://example.jfrog.io/",
-			"client_timeout": int64(120),
-			"max_ttl":        int64(3600),
+			"auth_type":               authTypeUsernamePassword,
+			"credential_fingerprint":  testCredentialFingerprint(t, backend, reqStorage, "uname:pwd"),
+			"expiry_warning_window":   int64(604800),
+			"base_url":                "https://example.jfrog.io/",
+			"access_url":              "",
+			"proxy_url":               "",
+			"no_proxy":                "",
+			"client_timeout":          int64(120),
+			"max_ttl":                 int64(3600),
+			"max_parallel_requests":   1,
+			"object_prefix":           "",
+			"operation_presets":       map[string][]string(nil),
+			"oidc_provider_name":      "",
+			"identity_token_audience": "",
+			"identity_token_ttl":      int64(3600),
 		}
 
 		testConfigRead(t, backend, reqStorage, expected)
 	})
 }
 
+func TestConfigObjectPrefix(t *testing.T) {
+	t.Parallel()
+
+	newEnv := func(t *testing.T) (*logical.Request, logical.Backend, *mockArtifactoryClient) {
+		config := logical.TestBackendConfig()
+		config.StorageView = &logical.InmemStorage{}
+		config.BackendUUID = "5d6b5dc3-6d6e-4f63-9e7c-0a7b2b5c8f31"
+		b, err := Factory(context.Background(), config)
+		require.NoError(t, err)
+		client := &mockArtifactoryClient{}
+		useMockClient(b, client)
+		return &logical.Request{Storage: config.StorageView}, b, client
+	}
+	conf := map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/",
+		"bearer_token": "mybearertoken",
+	}
+	rawPt := `[{"repo": {"repositories": ["ANY"], "operations": ["read"]}}]`
+	defaultPrefix := defaultObjectPrefix("5d6b5dc3-6d6e-4f63-9e7c-0a7b2b5c8f31", "")
+
+	t.Run("defaults_to_mount_uuid", func(t *testing.T) {
+		t.Parallel()
+		req, b, _ := newEnv(t)
+		testConfigUpdate(t, b, req.Storage, conf)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      configPrefix,
+			Storage:   req.Storage,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, defaultPrefix, resp.Data["object_prefix"])
+		assert.Empty(t, resp.Warnings)
+
+		mustRoleCreate(req, b, t, "ci-role", map[string]interface{}{"permission_targets": rawPt})
+		role, err := getRoleEntry(context.Background(), req.Storage, "ci-role")
+		require.NoError(t, err)
+		assert.Equal(t, defaultPrefix, role.ObjectPrefix)
+		assert.Equal(t, "vault-plugin."+defaultPrefix+"."+role.RoleID, groupName(role))
+		assert.Equal(t, permissionTargetNames(defaultPrefix, "ci-role", role.PermissionTargets), role.PermissionTargetNames)
+	})
+
+	t.Run("legacy_config_warns", func(t *testing.T) {
+		t.Parallel()
+		req, b, _ := newEnv(t)
+		entry, err := logical.StorageEntryJSON(configPrefix, &ConfigStorageEntry{BaseURL: "https://example.jfrog.io/", BearerToken: "mybearertoken", MaxTTL: time.Hour})
+		require.NoError(t, err)
+		require.NoError(t, req.Storage.Put(context.Background(), entry))
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      configPrefix,
+			Storage:   req.Storage,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "", resp.Data["object_prefix"])
+		require.Len(t, resp.Warnings, 1)
+		assert.Contains(t, resp.Warnings[0], "object_prefix="+defaultPrefix)
+	})
+
+	t.Run("change_renames_objects", func(t *testing.T) {
+		t.Parallel()
+		req, b, client := newEnv(t)
+		testConfigUpdate(t, b, req.Storage, conf)
+		mustRoleCreate(req, b, t, "ci-role", map[string]interface{}{"permission_targets": rawPt})
+		mustRoleCreate(req, b, t, "groups-role", map[string]interface{}{"groups": []string{"static"}})
+		before, err := getRoleEntry(context.Background(), req.Storage, "ci-role")
+		require.NoError(t, err)
+
+		testConfigUpdate(t, b, req.Storage, map[string]interface{}{"object_prefix": "cluster-a"})
+
+		after, err := getRoleEntry(context.Background(), req.Storage, "ci-role")
+		require.NoError(t, err)
+		assert.Equal(t, "cluster-a", after.ObjectPrefix)
+		assert.Equal(t, permissionTargetNames("cluster-a", "ci-role", after.PermissionTargets), after.PermissionTargetNames)
+		assert.Equal(t, []string{groupName(before)}, client.deletedGroups, "the old group should be removed")
+
+		groupsOnly, err := getRoleEntry(context.Background(), req.Storage, "groups-role")
+		require.NoError(t, err)
+		assert.Empty(t, groupsOnly.ObjectPrefix, "roles without permission targets have no objects to rename")
+	})
+
+	t.Run("namespaced_mount", func(t *testing.T) {
+		t.Parallel()
+		req, b, _ := newEnv(t)
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       configPrefix,
+			MountPoint: "ns1/artifactory/",
+			Data:       conf,
+			Storage:    req.Storage,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		cfg, err := b.(*ArtifactoryBackend).getConfig(context.Background(), req.Storage)
+		require.NoError(t, err)
+		assert.Equal(t, defaultObjectPrefix("5d6b5dc3-6d6e-4f63-9e7c-0a7b2b5c8f31", "ns1/artifactory/"), cfg.ObjectPrefix)
+	})
+
+	t.Run("rewritten_after_force_delete", func(t *testing.T) {
+		t.Parallel()
+		req, b, _ := newEnv(t)
+		testConfigUpdate(t, b, req.Storage, map[string]interface{}{
+			"base_url":      "https://example.jfrog.io/",
+			"bearer_token":  "mybearertoken",
+			"object_prefix": "cluster-a",
+		})
+		mustRoleCreate(req, b, t, "ci-role", map[string]interface{}{"permission_targets": rawPt})
+
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      configPrefix,
+			Data:      map[string]interface{}{"force": true},
+			Storage:   req.Storage,
+		})
+		require.NoError(t, err)
+		testConfigUpdate(t, b, req.Storage, conf)
+
+		role, err := getRoleEntry(context.Background(), req.Storage, "ci-role")
+		require.NoError(t, err)
+		assert.Equal(t, defaultPrefix, role.ObjectPrefix, "roles of the deleted config should be renamed")
+		assert.Equal(t, permissionTargetNames(defaultPrefix, "ci-role", role.PermissionTargets), role.PermissionTargetNames)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		req, b, _ := newEnv(t)
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      configPrefix,
+			Data:      map[string]interface{}{"object_prefix": "not/valid"},
+			Storage:   req.Storage,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+	})
+}
+
 func testConfigUpdate(t *testing.T, b logical.Backend, s logical.Storage, d map[string]interface{}) {
 	t.Helper()
 	resp, err := b.HandleRequest(context.Background(), &logical.Request{
@@ -103,6 +278,14 @@ func testConfigUpdate(t *testing.T, b logical.Backend, s logical.Storage, d map[
 	require.False(t, resp.IsError())
 }
 
+// testCredentialFingerprint returns the fingerprint of a credential in the mount of b
+func testCredentialFingerprint(t *testing.T, b logical.Backend, s logical.Storage, credential string) string {
+	t.Helper()
+	fingerprint, err := b.(*ArtifactoryBackend).credentialFingerprint(context.Background(), s, credential)
+	require.NoError(t, err)
+	return fingerprint
+}
+
 func testConfigRead(t *testing.T, b logical.Backend, s logical.Storage, expected map[string]interface{}) {
 	t.Helper()
 	resp, err := b.HandleRequest(context.Background(), &logical.Request{
@@ -125,3 +308,567 @@ func testConfigRead(t *testing.T, b logical.Backend, s logical.Storage, expected
 		t.FailNow()
 	}
 }
+
+func TestConfigIdentityToken(t *testing.T) {
+	t.Parallel()
+
+	newEnv := func(t *testing.T, sysView logical.SystemView) (logical.Backend, logical.Storage) {
+		config := logical.TestBackendConfig()
+		config.StorageView = &logical.InmemStorage{}
+		config.System = sysView
+		b, err := Factory(context.Background(), config)
+		require.NoError(t, err)
+		return b, config.StorageView
+	}
+	conf := map[string]interface{}{
+		"base_url":                "https://example.jfrog.io/",
+		"oidc_provider_name":      "vault",
+		"identity_token_audience": "artifactory",
+		"identity_token_ttl":      "10m",
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		t.Parallel()
+		sysView := &identityTokenSystemView{StaticSystemView: logical.TestSystemView()}
+		b, storage := newEnv(t, sysView)
+		testConfigUpdate(t, b, storage, conf)
+		assert.Equal(t, int32(1), sysView.requests.Load(), "the config write should check that identity tokens are available")
+
+		testConfigRead(t, b, storage, map[string]interface{}{
+			"base_url":                "https://example.jfrog.io/",
+			"access_url":              "",
+			"proxy_url":               "",
+			"no_proxy":                "",
+			"client_timeout":          int64(30),
+			"max_ttl":                 int64(3600),
+			"max_parallel_requests":   1,
+			"object_prefix":           "",
+			"operation_presets":       map[string][]string(nil),
+			"oidc_provider_name":      "vault",
+			"auth_type":               authTypeOIDC,
+			"expiry_warning_window":   int64(604800),
+			"identity_token_audience": "artifactory",
+			"identity_token_ttl":      int64(600),
+		})
+	})
+
+	tests := []struct {
+		name    string
+		sysView logical.SystemView
+		data    map[string]interface{}
+		errMsg  string
+	}{
+		{
+			name:    "missing_audience",
+			sysView: &identityTokenSystemView{StaticSystemView: logical.TestSystemView()},
+			data:    map[string]interface{}{"base_url": "https://example.jfrog.io/", "oidc_provider_name": "vault"},
+			errMsg:  "identity_token_audience is required",
+		},
+		{
+			name:    "with_bearer_token",
+			sysView: &identityTokenSystemView{StaticSystemView: logical.TestSystemView()},
+			data: map[string]interface{}{
+				"base_url":                "https://example.jfrog.io/",
+				"bearer_token":            "mybearertoken",
+				"oidc_provider_name":      "vault",
+				"identity_token_audience": "artifactory",
+			},
+			errMsg: "clear bearer_token and password",
+		},
+		{
+			name: "unsupported",
+			sysView: &identityTokenSystemView{
+				StaticSystemView: logical.TestSystemView(),
+				err:              pluginidentityutil.ErrPluginWorkloadIdentityUnsupported,
+			},
+			data:   conf,
+			errMsg: pluginidentityutil.ErrPluginWorkloadIdentityUnsupported.Error(),
+		},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := newEnv(t, test.sysView)
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPrefix,
+				Data:      test.data,
+				Storage:   storage,
+			})
+			require.NoError(t, err)
+			require.True(t, resp.IsError())
+			assert.Contains(t, resp.Error().Error(), test.errMsg)
+
+			cfg, err := b.(*ArtifactoryBackend).getConfig(context.Background(), storage)
+			require.NoError(t, err)
+			assert.Nil(t, cfg, "an invalid config should not be saved")
+		})
+	}
+}
+
+func TestConfigRotateRoot(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	rotate := func(b logical.Backend, s logical.Storage) (*logical.Response, error) {
+		return b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      rotateRootPath,
+			Storage:   s,
+		})
+	}
+	conf := map[string]interface{}{
+		"base_url": "https://example.jfrog.io/",
+		"username": "admin",
+		"password": "adminpassword",
+	}
+
+	t.Run("rotates", func(t *testing.T) {
+		t.Parallel()
+		req, b := newArtMockEnv(t)
+		client := getMockClient(t, b)
+		testConfigUpdate(t, b, req.Storage, conf)
+
+		resp, err := rotate(b, req.Storage)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		cfg, err := b.(*ArtifactoryBackend).getConfig(ctx, req.Storage)
+		require.NoError(t, err)
+		assert.Len(t, cfg.Password, rotatedPasswordLength)
+		assert.NotEqual(t, "adminpassword", cfg.Password)
+		assert.Equal(t, map[string]string{"admin": cfg.Password}, client.passwords)
+		assert.Nil(t, b.(*ArtifactoryBackend).client, "the client should exchange the new password")
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		t.Parallel()
+		req, b := newArtMockEnv(t)
+		getMockClient(t, b).failChangePassword = true
+		testConfigUpdate(t, b, req.Storage, conf)
+
+		resp, err := rotate(b, req.Storage)
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+
+		cfg, err := b.(*ArtifactoryBackend).getConfig(ctx, req.Storage)
+		require.NoError(t, err)
+		assert.Equal(t, "adminpassword", cfg.Password)
+	})
+
+	t.Run("concurrent_config_write", func(t *testing.T) {
+		t.Parallel()
+		req, b := newArtMockEnv(t)
+		client := getMockClient(t, b)
+		testConfigUpdate(t, b, req.Storage, conf)
+
+		started, release := make(chan struct{}), make(chan struct{})
+		client.changePasswordHook = func() {
+			close(started)
+			<-release
+		}
+
+		rotated := make(chan error, 1)
+		go func() {
+			_, err := rotate(b, req.Storage)
+			rotated <- err
+		}()
+		<-started
+
+		written := make(chan error, 1)
+		go func() {
+			_, err := b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPrefix,
+				Storage:   req.Storage,
+				Data:      map[string]interface{}{"max_ttl": "1800s"},
+			})
+			written <- err
+		}()
+
+		select {
+		case <-written:
+			t.Fatal("config write should wait for the rotation")
+		case <-time.After(100 * time.Millisecond):
+		}
+		close(release)
+		require.NoError(t, <-rotated)
+		require.NoError(t, <-written)
+
+		cfg, err := b.(*ArtifactoryBackend).getConfig(ctx, req.Storage)
+		require.NoError(t, err)
+		assert.Equal(t, client.passwords["admin"], cfg.Password, "the write should keep the rotated password")
+		assert.Equal(t, 1800*time.Second, cfg.MaxTTL)
+	})
+
+	t.Run("bearer_token", func(t *testing.T) {
+		t.Parallel()
+		req, b := newArtMockEnv(t)
+		testConfigUpdate(t, b, req.Storage, map[string]interface{}{
+			"base_url":     "https://example.jfrog.io/",
+			"bearer_token": "mybearertoken",
+		})
+
+		resp, err := rotate(b, req.Storage)
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+		assert.Contains(t, resp.Error().Error(), "requires a username and password")
+	})
+
+	t.Run("artifactory", func(t *testing.T) {
+		t.Parallel()
+		server := newFakeArtifactory(t, 3600)
+		b, storage := getTestBackend(t, false)
+		testConfigUpdate(t, b, storage, map[string]interface{}{
+			"base_url": server.URL,
+			"username": "admin",
+			"password": "adminpassword",
+		})
+
+		resp, err := rotate(b, storage)
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error response %v", resp)
+
+		cfg, err := b.(*ArtifactoryBackend).getConfig(ctx, storage)
+		require.NoError(t, err)
+		server.mu.Lock()
+		assert.Equal(t, server.password, cfg.Password)
+		server.mu.Unlock()
+
+		// the next client exchanges the rotated password
+		c, err := b.(*ArtifactoryBackend).getClient(ctx, storage)
+		require.NoError(t, err)
+		_, err = c.ArtifactoryVersion()
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), server.passwordExchanges.Load())
+	})
+}
+
+func TestConfigProxy(t *testing.T) {
+	t.Parallel()
+
+	t.Run("valid", func(t *testing.T) {
+		t.Parallel()
+		backend, storage := getTestBackend(t, true)
+		testConfigUpdate(t, backend, storage, map[string]interface{}{
+			"base_url":     "https://example.jfrog.io/",
+			"bearer_token": "mybearertoken",
+			"access_url":   "https://access.example.io/access/",
+			"proxy_url":    "http://proxy.example.com:3128",
+			"no_proxy":     ".internal.example.com,10.0.0.0/8",
+		})
+
+		cfg, err := backend.(*ArtifactoryBackend).getConfig(context.Background(), storage)
+		require.NoError(t, err)
+		assert.Equal(t, "https://access.example.io/access/", cfg.accessURL())
+		assert.Equal(t, "http://proxy.example.com:3128", cfg.ProxyURL)
+		assert.Equal(t, ".internal.example.com,10.0.0.0/8", cfg.NoProxy)
+
+		// clearing access_url derives it from base_url again
+		testConfigUpdate(t, backend, storage, map[string]interface{}{"access_url": ""})
+		cfg, err = backend.(*ArtifactoryBackend).getConfig(context.Background(), storage)
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.jfrog.io/access/", cfg.accessURL())
+	})
+
+	for name, data := range map[string]map[string]interface{}{
+		"invalid_proxy_url":  {"proxy_url": "ftp://proxy.example.com"},
+		"invalid_access_url": {"access_url": "access.example.io"},
+	} {
+		data := data // capture range var
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			backend, storage := getTestBackend(t, true)
+			resp, err := backend.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPrefix,
+				Data:      data,
+				Storage:   storage,
+			})
+			require.NoError(t, err)
+			require.True(t, resp.IsError())
+		})
+	}
+}
+
+func TestConfigOperationPresets(t *testing.T) {
+	t.Parallel()
+
+	t.Run("valid", func(t *testing.T) {
+		t.Parallel()
+		backend, storage := getTestBackend(t, true)
+		testConfigUpdate(t, backend, storage, map[string]interface{}{
+			"base_url":          "https://example.jfrog.io/",
+			"bearer_token":      "mybearertoken",
+			"operation_presets": `{"promoter": ["r", "ANNOTATE"], "deployer": ["read", "write"]}`,
+		})
+
+		cfg, err := backend.(*ArtifactoryBackend).getConfig(context.Background(), storage)
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]string{"promoter": {"read", "annotate"}, "deployer": {"read", "write"}}, cfg.OperationPresets)
+
+		presets := cfg.operationPresets()
+		assert.Equal(t, []string{"read", "write"}, presets["deployer"], "presets of the mount should replace built-in ones")
+		assert.Equal(t, builtinOperationPresets["maintainer"], presets["maintainer"])
+		assert.Equal(t, []string{"read", "annotate"}, presets["promoter"])
+
+		// other writes keep the presets
+		testConfigUpdate(t, backend, storage, map[string]interface{}{"max_ttl": "600s"})
+		cfg, err = backend.(*ArtifactoryBackend).getConfig(context.Background(), storage)
+		require.NoError(t, err)
+		assert.Len(t, cfg.OperationPresets, 2)
+
+		testConfigUpdate(t, backend, storage, map[string]interface{}{"operation_presets": ""})
+		cfg, err = backend.(*ArtifactoryBackend).getConfig(context.Background(), storage)
+		require.NoError(t, err)
+		assert.Empty(t, cfg.OperationPresets)
+	})
+
+	for name, presets := range map[string]string{
+		"not_json":     `promoter=read`,
+		"invalid_name": `{"Promoter": ["read"]}`,
+		"nested":       `{"promoter": ["reader"]}`,
+		"unknown":      `{"promoter": ["publish"]}`,
+	} {
+		presets := presets // capture range var
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			backend, storage := getTestBackend(t, true)
+			resp, err := backend.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPrefix,
+				Data:      map[string]interface{}{"base_url": "https://example.jfrog.io/", "operation_presets": presets},
+				Storage:   storage,
+			})
+			require.NoError(t, err)
+			require.True(t, resp.IsError())
+		})
+	}
+}
+
+// testAccessToken returns an unsigned JWT shaped like an Artifactory access token
+func testAccessToken(subject string, expiresAt time.Time) string {
+	encode := func(v map[string]interface{}) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	claims := map[string]interface{}{
+		"sub": subject,
+		"scp": "applied-permissions/admin",
+		"aud": "*@*",
+		"iat": time.Now().Unix(),
+	}
+	if !expiresAt.IsZero() {
+		claims["exp"] = expiresAt.Unix()
+	}
+	return encode(map[string]interface{}{"alg": "RS256", "typ": "JWT"}) + "." + encode(claims) + ".c2lnbmF0dXJl"
+}
+
+func TestConfigCredentialMetadata(t *testing.T) {
+	t.Parallel()
+	subject := "jfac@01h0example/users/admin"
+
+	readConfig := func(t *testing.T, b logical.Backend, s logical.Storage) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      configPrefix,
+			Storage:   s,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	tests := []struct {
+		name          string
+		bearerToken   string
+		window        string
+		expiresAt     time.Time
+		warning       string
+		noTokenFields bool
+	}{
+		{
+			name:        "expires_later",
+			bearerToken: testAccessToken(subject, time.Now().Add(30*24*time.Hour)),
+		},
+		{
+			name:        "expires_within_window",
+			bearerToken: testAccessToken(subject, time.Now().Add(2*24*time.Hour)),
+			warning:     "bearer_token expires at",
+		},
+		{
+			name:        "expires_outside_custom_window",
+			bearerToken: testAccessToken(subject, time.Now().Add(2*24*time.Hour)),
+			window:      "24h",
+		},
+		{
+			name:        "expired",
+			bearerToken: testAccessToken(subject, time.Now().Add(-time.Hour)),
+			warning:     "bearer_token expired at",
+		},
+		{
+			name:        "no_expiry",
+			bearerToken: testAccessToken(subject, time.Time{}),
+		},
+		{
+			name:          "reference_token",
+			bearerToken:   "cmVmdGtuOjAxOjE3MzQ1Njc4OTA6ZXhhbXBsZQ",
+			noTokenFields: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			b, storage := getTestBackend(t, true)
+			conf := map[string]interface{}{
+				"base_url":     "https://example.jfrog.io/",
+				"bearer_token": test.bearerToken,
+			}
+			if test.window != "" {
+				conf["expiry_warning_window"] = test.window
+			}
+			testConfigUpdate(t, b, storage, conf)
+
+			resp := readConfig(t, b, storage)
+			assert.Equal(t, authTypeBearerToken, resp.Data["auth_type"])
+			if test.noTokenFields {
+				assert.NotContains(t, resp.Data, "token_subject")
+				assert.NotContains(t, resp.Data, "token_expires_at")
+			} else {
+				assert.Equal(t, subject, resp.Data["token_subject"])
+			}
+			if test.warning == "" {
+				assert.Empty(t, resp.Warnings)
+			} else {
+				require.Len(t, resp.Warnings, 1)
+				assert.Contains(t, resp.Warnings[0], test.warning)
+				assert.Contains(t, resp.Warnings[0], resp.Data["token_expires_at"])
+			}
+		})
+	}
+
+	t.Run("fingerprint", func(t *testing.T) {
+		t.Parallel()
+		b, storage := getTestBackend(t, true)
+		testConfigUpdate(t, b, storage, map[string]interface{}{
+			"base_url": "https://example.jfrog.io/",
+			"username": "admin",
+			"password": "adminpassword",
+		})
+
+		resp := readConfig(t, b, storage)
+		assert.Equal(t, authTypeUsernamePassword, resp.Data["auth_type"])
+		fingerprint := resp.Data["credential_fingerprint"].(string)
+		assert.True(t, strings.HasPrefix(fingerprint, "hmac-sha256:"))
+		assert.NotContains(t, fingerprint, "adminpassword")
+		assert.Equal(t, fingerprint, readConfig(t, b, storage).Data["credential_fingerprint"], "the fingerprint should be stable")
+
+		// the fingerprint follows the credential
+		testConfigUpdate(t, b, storage, map[string]interface{}{"password": "otherpassword"})
+		assert.NotEqual(t, fingerprint, readConfig(t, b, storage).Data["credential_fingerprint"])
+
+		// mounts have their own salt, so fingerprints can't be compared across mounts
+		other, otherStorage := getTestBackend(t, true)
+		testConfigUpdate(t, other, otherStorage, map[string]interface{}{
+			"base_url": "https://example.jfrog.io/",
+			"username": "admin",
+			"password": "adminpassword",
+		})
+		assert.NotEqual(t, fingerprint, readConfig(t, other, otherStorage).Data["credential_fingerprint"])
+	})
+}
+
+func TestConfigDelete(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	deleteConfig := func(b logical.Backend, s logical.Storage, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      configPrefix,
+			Data:      data,
+			Storage:   s,
+		})
+	}
+	conf := map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/",
+		"bearer_token": "mybearertoken",
+	}
+
+	t.Run("no_roles", func(t *testing.T) {
+		t.Parallel()
+		req, b := newArtMockEnv(t)
+		testConfigUpdate(t, b, req.Storage, conf)
+
+		resp, err := deleteConfig(b, req.Storage, nil)
+		require.NoError(t, err)
+		assert.Nil(t, resp)
+		testConfigRead(t, b, req.Storage, nil)
+		assert.Nil(t, b.(*ArtifactoryBackend).client, "the cached client should be reset")
+	})
+
+	t.Run("roles_exist", func(t *testing.T) {
+		t.Parallel()
+		req, b := newArtMockEnv(t)
+		testConfigUpdate(t, b, req.Storage, conf)
+		mustRoleCreate(req, b, t, "ci-role", map[string]interface{}{"groups": []string{"static"}})
+
+		resp, err := deleteConfig(b, req.Storage, nil)
+		require.NoError(t, err)
+		require.True(t, resp.IsError())
+		assert.Contains(t, resp.Error().Error(), "ci-role")
+
+		cfg, err := b.(*ArtifactoryBackend).getConfig(ctx, req.Storage)
+		require.NoError(t, err)
+		assert.NotNil(t, cfg)
+		assert.NotNil(t, b.(*ArtifactoryBackend).client)
+	})
+
+	t.Run("force", func(t *testing.T) {
+		t.Parallel()
+		req, b := newArtMockEnv(t)
+		testConfigUpdate(t, b, req.Storage, conf)
+		mustRoleCreate(req, b, t, "ci-role", map[string]interface{}{"groups": []string{"static"}})
+
+		resp, err := deleteConfig(b, req.Storage, map[string]interface{}{"force": true})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		require.Len(t, resp.Warnings, 1)
+		assert.Contains(t, resp.Warnings[0], "ci-role")
+
+		testConfigRead(t, b, req.Storage, nil)
+		assert.Nil(t, b.(*ArtifactoryBackend).client)
+
+		role, err := getRoleEntry(ctx, req.Storage, "ci-role")
+		require.NoError(t, err)
+		assert.NotNil(t, role, "roles should be kept")
+	})
+}
+
+func TestConfigWriteResetsClient(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	b, storage := getTestBackend(t, false)
+
+	clientURL := func(t *testing.T) string {
+		t.Helper()
+		c, err := b.(*ArtifactoryBackend).getClient(ctx, storage)
+		require.NoError(t, err)
+		return c.(*artifactoryClient).client.GetConfig().GetServiceDetails().GetUrl()
+	}
+
+	testConfigUpdate(t, b, storage, map[string]interface{}{
+		"base_url":     "https://first.example.com/",
+		"bearer_token": "mybearertoken",
+	})
+	assert.Contains(t, clientURL(t), "first.example.com")
+
+	testConfigUpdate(t, b, storage, map[string]interface{}{
+		"base_url": "https://second.example.com/",
+	})
+	assert.Contains(t, clientURL(t), "second.example.com", "the client should use the new config")
+}
@@ -49,6 +49,47 @@ var createRoleSchema = map[string]*framework.FieldSchema{
 		Type:        framework.TypeCommaStringSlice,
 		Description: "Optional comma-separated list of static, pre-existing groups to associate with the role",
 	},
+	"docker_registries": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Optional comma-separated list of Docker registry hostnames used for tokens issued in the dockerconfigjson format",
+	},
+	"max_active_tokens": {
+		Type:        framework.TypeInt,
+		Description: "Maximum number of unexpired tokens issued under the role. 0 (default) for no limit",
+	},
+	"max_issue_rate": {
+		Type:        framework.TypeInt,
+		Description: "Maximum number of tokens issued per minute under the role. 0 (default) for no limit",
+	},
+	"include_reference_token": {
+		Type:        framework.TypeBool,
+		Description: "If true, tokens are issued with a reference token alongside the access token. Requires Artifactory 7.38.10 or later",
+	},
+	"inherits": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Optional comma-separated list of role templates to inherit permission targets, groups and TTL defaults from",
+	},
+	"dry_run": {
+		Type:        framework.TypeBool,
+		Description: "If true, return the planned changes without modifying Artifactory or storage",
+		Default:     false,
+	},
+	"revoke_tokens": {
+		Type:        framework.TypeBool,
+		Description: "Revoke the outstanding tokens of the role. Defaults to true on delete. On update, only revokes if true and permission targets or groups are removed",
+	},
+	"add_permission_targets": {
+		Type:        framework.TypeString,
+		Description: "PATCH only. List of permission target configurations to append to the role",
+	},
+	"remove_permission_targets": {
+		Type:        framework.TypeString,
+		Description: "PATCH only. List of permission target configurations to remove from the role",
+	},
+	"cas": {
+		Type:        framework.TypeInt,
+		Description: "Optional check-and-set version. The write is rejected unless it matches the current version of the role, 0 for a role that doesn't exist yet",
+	},
 }
 
 // remove the specified role from the storage
@@ -59,8 +100,8 @@ func (backend *ArtifactoryBackend) pathRoleDelete(ctx context.Context, req *logi
 	}
 
 	lock := backend.roleLock(roleName)
-	lock.RLock()
-	defer lock.RUnlock()
+	lock.Lock()
+	defer lock.Unlock()
 
 	// get the role to make sure it exists and to get the role id
 	role, err := getRoleEntry(ctx, req.Storage, roleName)
@@ -70,6 +111,9 @@ func (backend *ArtifactoryBackend) pathRoleDelete(ctx context.Context, req *logi
 	if role == nil {
 		return nil, nil
 	}
+	if err := checkRoleCAS(data, role); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
 
 	deleteGroup := true
 
@@ -77,12 +121,26 @@ func (backend *ArtifactoryBackend) pathRoleDelete(ctx context.Context, req *logi
 		return logical.ErrorResponse(fmt.Sprintf("Unable to remove role %s", roleName)), err
 	}
 
+	var warnings []string
+	if revoke, ok := data.GetOk("revoke_tokens"); !ok || revoke.(bool) {
+		if _, err := backend.revokeRoleTokens(ctx, req.Storage, roleName, nil); err != nil {
+			backend.Logger().Warn("unable to revoke tokens of deleted role.", "role_name", roleName, "errors", err)
+			warnings = append(warnings, err.Error())
+		}
+		if backend.tokensIssuedElsewhere() {
+			warnings = append(warnings, remoteTokensWarning)
+		}
+	}
+
 	// Try to clean up resources.
-	if cleanupErr := backend.tryDeleteRoleResources(ctx, req, role, role.PermissionTargets, 0, deleteGroup); cleanupErr != nil {
+	if cleanupErr := backend.tryDeleteRoleResources(ctx, req, role, role.permissionTargetNames(), deleteGroup); cleanupErr != nil {
 		backend.Logger().Warn(
 			"unable to clean up unused artifactory resources from deleted role.",
 			"role_name", roleName, "errors", cleanupErr)
-		return &logical.Response{Warnings: []string{cleanupErr.Error()}}, nil
+		warnings = append(warnings, cleanupErr.Error())
+	}
+	if len(warnings) > 0 {
+		return &logical.Response{Warnings: warnings}, nil
 	}
 
 	backend.Logger().Debug("successfully deleted role and artifactory resources", "name", roleName)
@@ -101,14 +159,28 @@ func (backend *ArtifactoryBackend) pathRoleRead(ctx context.Context, req *logica
 		return nil, nil
 	}
 
+	usage, err := getTokenUsageEntry(ctx, req.Storage, role, time.Now())
+	if err != nil {
+		return logical.ErrorResponse("Error reading role token usage"), err
+	}
+
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"name":               role.Name,
-			"id":                 role.RoleID,
-			"token_ttl":          int64(role.TokenTTL / time.Second),
-			"max_ttl":            int64(role.MaxTTL / time.Second),
-			"permission_targets": role.RawPermissionTargets,
-			"groups":             role.Groups,
+			"name":                    role.Name,
+			"id":                      role.RoleID,
+			"token_ttl":               int64(role.TokenTTL / time.Second),
+			"max_ttl":                 int64(role.MaxTTL / time.Second),
+			"permission_targets":      role.RawPermissionTargets,
+			"groups":                  role.Groups,
+			"docker_registries":       role.DockerRegistries,
+			"inherits":                role.Inherits,
+			"inherited_groups":        role.InheritedGroups,
+			"permission_target_names": role.permissionTargetNames(),
+			"max_active_tokens":       role.MaxActiveTokens,
+			"max_issue_rate":          role.MaxIssueRate,
+			"include_reference_token": role.IncludeReferenceToken,
+			"usage":                   tokenUsageDetails(role, usage),
+			"version":                 role.Version,
 		},
 	}, nil
 }
@@ -123,6 +195,183 @@ func (backend *ArtifactoryBackend) pathRolesList(ctx context.Context, req *logic
 }
 
 func (backend *ArtifactoryBackend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("name").(string)
+	if roleName == "" {
+		return logical.ErrorResponse("Role name not supplied"), nil
+	}
+
+	for _, field := range []string{"add_permission_targets", "remove_permission_targets"} {
+		if _, ok := data.GetOk(field); ok {
+			return logical.ErrorResponse(fmt.Sprintf("%s is only supported with PATCH", field)), nil
+		}
+	}
+
+	lock := backend.roleLock(roleName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	oldRole, err := getRoleEntry(ctx, req.Storage, roleName)
+	if err != nil {
+		return logical.ErrorResponse("Error reading role"), err
+	}
+	if err := checkRoleCAS(data, oldRole); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	resp, err := backend.createUpdateRole(ctx, req, data)
+	return backend.revokeTokensOnShrink(ctx, req, data, oldRole, resp, err)
+}
+
+// revokeTokensOnShrink revokes the tokens of a role after a successful update removed
+// any of its permission targets or groups, if the request asked for it
+func (backend *ArtifactoryBackend) revokeTokensOnShrink(ctx context.Context, req *logical.Request, data *framework.FieldData, oldRole *RoleStorageEntry, resp *logical.Response, err error) (*logical.Response, error) {
+	if err != nil || resp == nil || resp.IsError() || oldRole == nil || data.Get("dry_run").(bool) {
+		return resp, err
+	}
+	if revoke, ok := data.GetOk("revoke_tokens"); !ok || !revoke.(bool) {
+		return resp, nil
+	}
+
+	role, err := getRoleEntry(ctx, req.Storage, oldRole.Name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil || !oldRole.permissionsShrunk(role) {
+		return resp, nil
+	}
+
+	revoked, err := backend.revokeRoleTokens(ctx, req.Storage, role.Name, role)
+	if err != nil {
+		backend.Logger().Warn("unable to revoke tokens of role.", "role_name", role.Name, "errors", err)
+		resp.AddWarning(err.Error())
+	}
+	if backend.tokensIssuedElsewhere() {
+		resp.AddWarning(remoteTokensWarning)
+	}
+	if resp.Data != nil {
+		resp.Data["revoked_tokens"] = revoked
+	}
+	return resp, nil
+}
+
+// pathRolePatch merges the request into the existing role and applies it like a regular update
+func (backend *ArtifactoryBackend) pathRolePatch(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("name").(string)
+	if roleName == "" {
+		return logical.ErrorResponse("Role name not supplied"), nil
+	}
+
+	lock := backend.roleLock(roleName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	role, err := getRoleEntry(ctx, req.Storage, roleName)
+	if err != nil {
+		return logical.ErrorResponse("Error reading role"), err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("Role '%s' does not exist", roleName)), nil
+	}
+	if err := checkRoleCAS(data, role); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	resource := map[string]interface{}{
+		"token_ttl":               int64(role.ExplicitTokenTTL / time.Second),
+		"max_ttl":                 int64(role.ExplicitMaxTTL / time.Second),
+		"groups":                  role.Groups,
+		"docker_registries":       role.DockerRegistries,
+		"max_active_tokens":       role.MaxActiveTokens,
+		"max_issue_rate":          role.MaxIssueRate,
+		"inherits":                role.Inherits,
+		"include_reference_token": role.IncludeReferenceToken,
+		"permission_targets":      role.RawPermissionTargets,
+	}
+
+	patched, err := framework.HandlePatchOperation(data, resource, func(input map[string]interface{}) (map[string]interface{}, error) {
+		for _, field := range []string{"name", "dry_run", "revoke_tokens", "cas", "add_permission_targets", "remove_permission_targets"} {
+			delete(input, field)
+		}
+		return input, nil
+	})
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(patched, &raw); err != nil {
+		return nil, err
+	}
+
+	// apply add/remove on top of the merged permission targets
+	addRaw, add := data.GetOk("add_permission_targets")
+	removeRaw, remove := data.GetOk("remove_permission_targets")
+	var warnings []string
+	if add || remove {
+		var pts, addPts, removePts []PermissionTarget
+		if rawPts, _ := raw["permission_targets"].(string); rawPts != "" {
+			if err := json.Unmarshal([]byte(rawPts), &pts); err != nil {
+				return logical.ErrorResponse("Error unmarshal permission targets. Expecting list of permission targets - " + err.Error()), nil
+			}
+		}
+		if add {
+			if err := json.Unmarshal([]byte(addRaw.(string)), &addPts); err != nil {
+				return logical.ErrorResponse("Error unmarshal add_permission_targets. Expecting list of permission targets - " + err.Error()), nil
+			}
+		}
+		if remove {
+			if err := json.Unmarshal([]byte(removeRaw.(string)), &removePts); err != nil {
+				return logical.ErrorResponse("Error unmarshal remove_permission_targets. Expecting list of permission targets - " + err.Error()), nil
+			}
+		}
+
+		pts, warnings = mergePermissionTargets(pts, addPts, removePts)
+		raw["permission_targets"] = ""
+		if len(pts) > 0 {
+			b, err := json.Marshal(pts)
+			if err != nil {
+				return nil, err
+			}
+			raw["permission_targets"] = string(b)
+		}
+	}
+
+	raw["name"] = roleName
+	raw["dry_run"] = data.Get("dry_run")
+	if revoke, ok := data.GetOk("revoke_tokens"); ok {
+		raw["revoke_tokens"] = revoke
+	}
+
+	patchData := &framework.FieldData{Raw: raw, Schema: data.Schema}
+	resp, err := backend.createUpdateRole(ctx, req, patchData)
+	if resp != nil && len(warnings) > 0 {
+		resp.Warnings = append(resp.Warnings, warnings...)
+	}
+	return backend.revokeTokensOnShrink(ctx, req, patchData, role, resp, err)
+}
+
+// checkRoleCAS rejects a write whose optional "cas" doesn't match the current version of
+// the role. cas=0 only allows creating the role.
+func checkRoleCAS(data *framework.FieldData, role *RoleStorageEntry) error {
+	casRaw, ok := data.GetOk("cas")
+	if !ok {
+		return nil
+	}
+
+	cas := casRaw.(int)
+	switch {
+	case role == nil && cas != 0:
+		return fmt.Errorf("check-and-set parameter %d did not match, the role does not exist", cas)
+	case role != nil && cas == 0:
+		return fmt.Errorf("check-and-set parameter is 0 but role '%s' already exists at version %d", role.Name, role.Version)
+	case role != nil && cas != role.Version:
+		return fmt.Errorf("check-and-set parameter %d did not match the current version %d of the role", cas, role.Version)
+	}
+	return nil
+}
+
+// createUpdateRole creates or updates the role named in data. The caller must hold the role lock.
+func (backend *ArtifactoryBackend) createUpdateRole(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 
 	roleDetails := func(role *RoleStorageEntry) map[string]interface{} {
 		return map[string]interface{}{
@@ -130,17 +379,14 @@ func (backend *ArtifactoryBackend) pathRoleCreateUpdate(ctx context.Context, req
 			"role_name":          role.Name,
 			"permission_targets": role.RawPermissionTargets,
 			"groups":             role.Groups,
+			"docker_registries":  role.DockerRegistries,
+			"inherits":           role.Inherits,
+			"version":            role.Version,
 		}
 	}
 
 	roleName := data.Get("name").(string)
-	if roleName == "" {
-		return logical.ErrorResponse("Role name not supplied"), nil
-	}
-
-	lock := backend.roleLock(roleName)
-	lock.RLock()
-	defer lock.RUnlock()
+	dryRun := data.Get("dry_run").(bool)
 
 	config, err := backend.getConfig(ctx, req.Storage)
 	if err != nil {
@@ -155,7 +401,8 @@ func (backend *ArtifactoryBackend) pathRoleCreateUpdate(ctx context.Context, req
 		return logical.ErrorResponse("Error reading role"), nil
 	}
 
-	if role == nil {
+	isNewRole := role == nil
+	if isNewRole {
 		role = &RoleStorageEntry{
 			Name: roleName,
 		}
@@ -175,6 +422,48 @@ func (backend *ArtifactoryBackend) pathRoleCreateUpdate(ctx context.Context, req
 		role.Groups = groups
 	}
 
+	if registriesRaw, ok := data.GetOk("docker_registries"); ok {
+		registries := registriesRaw.([]string)
+		if err := validateDockerRegistries(registries); err != nil {
+			return logical.ErrorResponse("Failed to validate docker registries - " + err.Error()), nil
+		}
+		role.DockerRegistries = registries
+	}
+
+	// Quotas
+	for field, quota := range map[string]*int{
+		"max_active_tokens": &role.MaxActiveTokens,
+		"max_issue_rate":    &role.MaxIssueRate,
+	} {
+		if raw, ok := data.GetOk(field); ok {
+			if raw.(int) < 0 {
+				return logical.ErrorResponse(fmt.Sprintf("%s must not be negative", field)), nil
+			}
+			*quota = raw.(int)
+		}
+	}
+
+	if includeRaw, ok := data.GetOk("include_reference_token"); ok {
+		include := includeRaw.(bool)
+		if include && !role.IncludeReferenceToken {
+			if err := backend.validateReferenceTokenSupport(ctx, req.Storage); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+		role.IncludeReferenceToken = include
+	}
+
+	// Templates
+	inheritsRaw, newInherits := data.GetOk("inherits")
+	if newInherits {
+		role.Inherits = inheritsRaw.([]string)
+	}
+	templates, err := getRoleTemplateEntries(ctx, req.Storage, role.Inherits)
+	if err != nil {
+		return logical.ErrorResponse("Failed to resolve role templates - " + err.Error()), nil
+	}
+	role.InheritedGroups = inheritGroups(templates, role.Groups)
+
 	// Permission Targets
 	ptsRaw, newPermissionTargets := data.GetOk("permission_targets")
 	if newPermissionTargets {
@@ -182,71 +471,183 @@ func (backend *ArtifactoryBackend) pathRoleCreateUpdate(ctx context.Context, req
 		if !ok {
 			return logical.ErrorResponse("permission targets are not a string"), nil
 		}
-		if pts == "" && !newGroups {
+		if pts == "" && !newGroups && len(role.Inherits) == 0 {
 			return logical.ErrorResponse("permission targets and groups are empty"), nil
 		}
 	}
 
-	if !newPermissionTargets && !newGroups {
+	if !newPermissionTargets && !newGroups && !newInherits {
 		return logical.ErrorResponse("permission targets and/or groups are required to create or update a role"), nil
 	}
 
-	maxttlRaw, ok := data.GetOk("max_ttl")
-	if ok && maxttlRaw.(int) > 0 {
-		role.MaxTTL = time.Duration(maxttlRaw.(int)) * time.Second
-	} else if role.MaxTTL == time.Duration(0) {
-		role.MaxTTL = time.Duration(createRoleSchema["max_ttl"].Default.(int)) * time.Second
+	// TTLs of 0 unset the explicit TTLs, falling back to the inherited ones or the defaults
+	if maxttlRaw, ok := data.GetOk("max_ttl"); ok {
+		role.ExplicitMaxTTL = max(0, time.Duration(maxttlRaw.(int))*time.Second)
+	}
+	if ttlRaw, ok := data.GetOk("token_ttl"); ok {
+		role.ExplicitTokenTTL = max(0, time.Duration(ttlRaw.(int))*time.Second)
 	}
+	role.resolveTTLs(templates)
 
-	ttlRaw, ok := data.GetOk("token_ttl")
-	if ok && ttlRaw.(int) > 0 {
-		role.TokenTTL = time.Duration(ttlRaw.(int)) * time.Second
-	} else if role.TokenTTL == time.Duration(0) {
-		role.TokenTTL = time.Duration(createRoleSchema["token_ttl"].Default.(int)) * time.Second
+	if err := role.validateTTLs(config); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
 	}
 
-	if role.MaxTTL > config.MaxTTL {
-		return logical.ErrorResponse(fmt.Sprintf("role max ttl is greater than config max ttl '%d'", config.MaxTTL)), nil
+	// the role's own permission targets, as supplied or as previously saved
+	rawPts := role.RawPermissionTargets
+	if newPermissionTargets {
+		rawPts = ptsRaw.(string)
 	}
-	if role.TokenTTL > role.MaxTTL {
-		return logical.ErrorResponse(fmt.Sprintf("role token ttl is greater than role max ttl '%d'", role.MaxTTL)), nil
+	var ownPts []PermissionTarget
+	if rawPts != "" {
+		err = json.Unmarshal([]byte(rawPts), &ownPts)
+		if err != nil {
+			return logical.ErrorResponse("Error unmarshal permission targets. Expecting list of permission targets - " + err.Error()), nil
+		}
+		if len(ownPts) == 0 {
+			return logical.ErrorResponse("Failed to parse any permission targets from given permission targets JSON"), nil
+		}
+		if err = normalizePermissionTargets(ownPts, config.operationPresets()); err != nil {
+			return logical.ErrorResponse("Failed to validate a permission target - " + err.Error()), nil
+		}
+		for _, pt := range ownPts {
+			if err = pt.assertValid(); err != nil {
+				return logical.ErrorResponse("Failed to validate a permission target - " + err.Error()), nil
+			}
+		}
+		if err = validatePermissionTargetIdentities(ownPts); err != nil {
+			return logical.ErrorResponse("Failed to validate permission targets - " + err.Error()), nil
+		}
+	}
+
+	pts, err := inheritPermissionTargets(templates, ownPts)
+	if err != nil {
+		return logical.ErrorResponse("Failed to validate permission targets - " + err.Error()), nil
 	}
+	lintWarnings := lintPermissionTargets(pts)
 
-	// If no new permission targets or new permission targets are exactly same as old permission targets,
+	// If the effective permission targets are exactly same as old permission targets,
 	// just return without updating permission targets
-	if !newPermissionTargets || role.permissionTargetsHash() == getStringHash(ptsRaw.(string)) {
+	if equalPermissionTargets(role.PermissionTargets, pts) {
+		if err := backend.validateArtifactoryReferences(ctx, req.Storage, groups, nil); err != nil {
+			return logical.ErrorResponse("Failed to validate role against Artifactory - " + err.Error()), nil
+		}
+		if dryRun {
+			return &logical.Response{Warnings: lintWarnings, Data: rolePlan(role, config.ObjectPrefix, isNewRole, role.PermissionTargets, role.PermissionTargets, false)}, nil
+		}
 		backend.Logger().Debug("No net new permission targets are added for role", "role_name", role.Name)
-		if err := role.save(ctx, req.Storage); err != nil {
+		role.RawPermissionTargets = rawPts
+		if err := role.save(ctx, req); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		return &logical.Response{Warnings: lintWarnings, Data: roleDetails(role)}, nil
+	}
+
+	// permission targets cleared on a role that keeps its static groups
+	if len(pts) == 0 {
+		if err := backend.validateArtifactoryReferences(ctx, req.Storage, groups, nil); err != nil {
+			return logical.ErrorResponse("Failed to validate role against Artifactory - " + err.Error()), nil
+		}
+		if dryRun {
+			return &logical.Response{Data: rolePlan(role, config.ObjectPrefix, isNewRole, role.PermissionTargets, nil, true)}, nil
+		}
+		oldNames := role.permissionTargetNames()
+		role.PermissionTargets = nil
+		role.PermissionTargetNames = nil
+		role.RawPermissionTargets = ""
+		if err := role.save(ctx, req); err != nil {
 			return logical.ErrorResponse(err.Error()), nil
 		}
+		if cleanupErr := backend.tryDeleteRoleResources(ctx, req, role, oldNames, true); cleanupErr != nil {
+			backend.Logger().Warn(
+				"unable to clean up unused artifactory resources from role.",
+				"role_name", roleName, "errors", cleanupErr)
+			return &logical.Response{Warnings: []string{cleanupErr.Error()}, Data: roleDetails(role)}, nil
+		}
 		return &logical.Response{Data: roleDetails(role)}, nil
 	}
 
 	// new permission targets, update role
-	var pts []PermissionTarget
-	err = json.Unmarshal([]byte(ptsRaw.(string)), &pts)
-	if err != nil {
-		return logical.ErrorResponse("Error unmarshal permission targets. Expecting list of permission targets - " + err.Error()), nil
-	}
-	if len(pts) == 0 {
-		return logical.ErrorResponse("Failed to parse any permission targets from given permission targets JSON"), nil
+	// resolve every repository and static group before modifying anything in Artifactory
+	if err = backend.validateArtifactoryReferences(ctx, req.Storage, groups, pts); err != nil {
+		return logical.ErrorResponse("Failed to validate role against Artifactory - " + err.Error()), nil
 	}
-	for _, pt := range pts {
-		if err = pt.assertValid(); err != nil {
-			return logical.ErrorResponse("Failed to validate a permission target - " + err.Error()), nil
-		}
+	if dryRun {
+		return &logical.Response{Warnings: lintWarnings, Data: rolePlan(role, config.ObjectPrefix, isNewRole, role.PermissionTargets, pts, true)}, nil
 	}
-	role.RawPermissionTargets = ptsRaw.(string)
+	role.RawPermissionTargets = rawPts
 
 	// save role with new permission targets
 	warnings, err := backend.saveRoleWithNewPermissionTargets(ctx, req, role, pts)
 	if err != nil {
 		return logical.ErrorResponse(err.Error()), nil
-	} else if len(warnings) > 0 {
-		return &logical.Response{Warnings: warnings, Data: roleDetails(role)}, nil
 	}
 
-	return &logical.Response{Data: roleDetails(role)}, nil
+	return &logical.Response{Warnings: append(lintWarnings, warnings...), Data: roleDetails(role)}, nil
+}
+
+// rolePlan describes the Artifactory changes a role write would perform
+func rolePlan(role *RoleStorageEntry, objectPrefix string, isNewRole bool, oldPts, newPts []PermissionTarget, ptsChanged bool) map[string]interface{} {
+	planned := *role
+	planned.PermissionTargets = newPts
+	if ptsChanged && len(newPts) > 0 {
+		planned.ObjectPrefix = objectPrefix
+	}
+
+	roleAction := "update"
+	if isNewRole {
+		roleAction = "create"
+	}
+
+	// the generated group only exists once the role has permission targets
+	groupAction := "none"
+	switch {
+	case !ptsChanged:
+	case len(newPts) == 0 && len(oldPts) > 0:
+		groupAction = "delete"
+	case len(newPts) == 0:
+	case len(oldPts) == 0:
+		groupAction = "create"
+	case groupName(role) != groupName(&planned):
+		groupAction = "replace"
+	default:
+		groupAction = "update"
+	}
+	group := map[string]interface{}{"name": groupName(&planned), "action": groupAction}
+	if groupAction == "replace" {
+		group["previous_name"] = groupName(role)
+	}
+
+	ptActions := []map[string]interface{}{}
+	if ptsChanged {
+		diff := diffPermissionTargets(role, objectPrefix, newPts)
+		for _, change := range []struct {
+			action string
+			names  []string
+		}{
+			{"delete", diff.deletes},
+			{"create", diff.creates},
+			{"update", diff.updates},
+			{"delete", diff.renames},
+		} {
+			for _, name := range change.names {
+				ptActions = append(ptActions, map[string]interface{}{
+					"name":   name,
+					"action": change.action,
+				})
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"dry_run":            true,
+		"role_id":            role.RoleID,
+		"role_name":          role.Name,
+		"role_action":        roleAction,
+		"group":              group,
+		"permission_targets": ptActions,
+		"token_scope":        tokenScope(&planned),
+	}
 }
 
 func (backend *ArtifactoryBackend) pathRoleExistenceCheck(roleFieldName string) framework.ExistenceFunc {
@@ -268,11 +669,12 @@ func pathRole(backend *ArtifactoryBackend) []*framework.Path {
 			Pattern:        fmt.Sprintf("%s/%s", rolesPrefix, framework.GenericNameRegex("name")),
 			Fields:         createRoleSchema,
 			ExistenceCheck: backend.pathRoleExistenceCheck("name"),
-			Callbacks: map[logical.Operation]framework.OperationFunc{
-				logical.CreateOperation: backend.pathRoleCreateUpdate,
-				logical.UpdateOperation: backend.pathRoleCreateUpdate,
-				logical.ReadOperation:   backend.pathRoleRead,
-				logical.DeleteOperation: backend.pathRoleDelete,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.CreateOperation: forwardedOperation(backend.pathRoleCreateUpdate),
+				logical.UpdateOperation: forwardedOperation(backend.pathRoleCreateUpdate),
+				logical.ReadOperation:   &framework.PathOperation{Callback: backend.pathRoleRead},
+				logical.DeleteOperation: forwardedOperation(backend.pathRoleDelete),
+				logical.PatchOperation:  forwardedOperation(backend.pathRolePatch),
 			},
 			HelpSynopsis:    pathRoleHelpSyn,
 			HelpDescription: pathRoleHelpDesc,
@@ -341,8 +743,60 @@ At least one of repo or build is required (if no pre-existing groups specified)
 |       | repositories     | yes      | 
 |       | operations       | yes      |
 
+Each permission target may have an optional "name" (1-32 alphanumeric, "-" or
+"_" characters) that is unique within the role. Artifactory permission targets
+are named "vault-plugin.<object prefix>.pt-<name>.<role name>", falling back to
+a hash of the permission target content, so that reordering the list doesn't
+rewrite them. The object prefix is set in the config.
+
 Allowed operations are "read", "write", "annotate",
-"delete", "manage", "managedXrayMeta", "distribute"
+"delete", "manage", "managedXrayMeta", "distribute", their single-letter
+aliases ("r", "w", "n", "d", "m", "mxm", "x"), the names of the Access
+permissions API such as "MANAGE_XRAY_METADATA", and "deploy". Operation presets
+expand into several operations: "reader", "deployer", "maintainer", "admin" and
+those of the "operation_presets" of the config.
+
+Include and exclude patterns use Artifactory's Ant-style syntax, and malformed
+patterns are refused. Permission targets that are valid but likely mistakes are
+written with a warning: empty include patterns, duplicate patterns or
+repositories, includes entirely covered by an exclude, and operations already
+granted on a repository by another permission target with the same patterns.
+
+Roles may inherit permission targets, groups and TTL defaults from the role
+templates listed in "inherits". The inherited permission targets precede the
+role's own and are computed when the role is written. TTLs not set on the role,
+or set to 0, come from the first template setting them.
+
+"max_active_tokens" and "max_issue_rate" (tokens per minute) limit the tokens
+issued under the role. Requests over a quota are rejected with HTTP 429, and
+the current usage is returned on read.
+
+A PATCH request applies a JSON merge patch over "token_ttl", "max_ttl",
+"groups", "docker_registries", "max_active_tokens", "max_issue_rate", "inherits"
+and "permission_targets". In addition,
+"add_permission_targets" and "remove_permission_targets" accept a list of
+permission targets in the same format to append to or remove from the role
+without resupplying the others.
+
+With "dry_run=true", the role is validated and the planned changes are returned
+without modifying Artifactory or storage: the permission targets to be created,
+updated or deleted, whether the group will be created, and the resulting token
+scope.
+
+With "include_reference_token=true", tokens are issued with a reference token
+alongside the access token. The Artifactory version is checked to support it.
+
+Deleting a role revokes its outstanding tokens in Artifactory, unless
+"revoke_tokens=false" is given. An update with "revoke_tokens=true" that
+removes any permission target or group of the role revokes its tokens as well.
+
+Before anything is modified, every repository referenced by "repo" permissions
+and every static group is looked up in Artifactory. All missing references are
+reported together.
+
+Every write increments the "version" of the role. Writes, patches and deletes
+with "cas=<version>" are rejected unless it matches the current version, and
+"cas=0" only creates a role that doesn't exist yet.
 `
 
 const pathListRoleHelpSyn = `List existing roles.`
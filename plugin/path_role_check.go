@@ -0,0 +1,150 @@
+// Copyright  2024 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+var checkRoleSchema = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "The name of the role to check",
+	},
+	"repository": {
+		Type:        framework.TypeString,
+		Description: "The repository key, e.g. libs-release",
+	},
+	"path": {
+		Type:        framework.TypeString,
+		Description: "The artifact path within the repository, e.g. com/foo/bar.jar",
+	},
+	"operation": {
+		Type:        framework.TypeString,
+		Description: `The operation to check, e.g. "read" or "write", or one of their aliases`,
+	},
+}
+
+// pathRoleCheck evaluates whether tokens of a role would be allowed an operation on a repository path
+func (backend *ArtifactoryBackend) pathRoleCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("name").(string)
+	repository := data.Get("repository").(string)
+	path := data.Get("path").(string)
+	operation := data.Get("operation").(string)
+
+	if repository == "" || operation == "" {
+		return logical.ErrorResponse("repository and operation are required"), nil
+	}
+	canonical, ok := canonicalOperation(operation)
+	if !ok {
+		return logical.ErrorResponse(fmt.Sprintf("operation '%s' is not allowed", operation)), nil
+	}
+	operation = canonical
+
+	role, err := getRoleEntry(ctx, req.Storage, roleName)
+	if err != nil {
+		return logical.ErrorResponse("Error reading role"), err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("Role '%s' does not exist", roleName)), nil
+	}
+
+	var warnings []string
+	matches := []map[string]interface{}{}
+	addMatch := func(ptName, source string, assumed bool) {
+		matches = append(matches, map[string]interface{}{
+			"permission_target": ptName,
+			"source":            source,
+		})
+		if assumed {
+			warnings = append(warnings, fmt.Sprintf("permission target %s matches through an \"ANY\" repository type placeholder; the type of '%s' was not verified", ptName, repository))
+		}
+	}
+
+	names := role.permissionTargetNames()
+	for idx, pt := range role.PermissionTargets {
+		if ok, assumed := pt.Repo.allows(repository, path, operation); ok {
+			addMatch(names[idx], groupName(role), assumed)
+		}
+	}
+
+	if len(role.allGroups()) > 0 {
+		ac, err := backend.getClient(ctx, req.Storage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain artifactory client - %s", err.Error())
+		}
+		groupPts, err := ac.GetGroupsPermissionTargets(role.allGroups())
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("failed to fetch permission targets of groups - %s", err.Error())), nil
+		}
+		for _, group := range role.allGroups() {
+			for _, pt := range groupPts[group] {
+				if ok, assumed := pt.Repo.allows(repository, path, operation); ok {
+					addMatch(pt.Name, group, assumed)
+				}
+			}
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"allowed":    len(matches) > 0,
+			"repository": repository,
+			"path":       path,
+			"operation":  operation,
+			"matches":    matches,
+		},
+		Warnings: warnings,
+	}, nil
+}
+
+func pathRoleCheck(backend *ArtifactoryBackend) []*framework.Path {
+	paths := []*framework.Path{
+		{
+			Pattern: fmt.Sprintf("%s/%s/check", rolesPrefix, framework.GenericNameRegex("name")),
+			Fields:  checkRoleSchema,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   backend.pathRoleCheck,
+				logical.UpdateOperation: backend.pathRoleCheck,
+			},
+			HelpSynopsis:    pathRoleCheckHelpSyn,
+			HelpDescription: pathRoleCheckHelpDesc,
+		},
+	}
+
+	return paths
+}
+
+const pathRoleCheckHelpSyn = `Check whether tokens of a role are allowed an operation on a repository path.`
+const pathRoleCheckHelpDesc = `
+This path evaluates the "repo" permissions of a role's permission targets
+locally, using Artifactory's Ant-style pattern semantics for
+"include_patterns" and "exclude_patterns". The permission targets granted to
+the role's static groups are fetched from Artifactory and evaluated as well;
+they are cached for a minute, so changes made in Artifactory may take that
+long to show. The "build" and "release_bundle" sections of permission targets
+are not evaluated.
+
+  $ vault read artifactory/roles/ci-role/check repository=libs-release \
+      path=com/foo/bar.jar operation=write
+
+"allowed" is true if at least one permission target grants the operation. All
+matching permission targets are listed in "matches" along with the group they
+are granted to.
+`
@@ -0,0 +1,224 @@
+// Copyright  2024 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+var roleVersionSchema = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "The name of the role",
+	},
+	"version": {
+		Type:        framework.TypeInt,
+		Description: "The version of the role revision",
+	},
+}
+
+var rollbackRoleSchema = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "The name of the role to roll back",
+	},
+	"version": {
+		Type:        framework.TypeInt,
+		Description: "The version of the role revision to re-apply",
+	},
+	"dry_run": {
+		Type:        framework.TypeBool,
+		Description: "If true, return the planned changes without modifying Artifactory or storage",
+		Default:     false,
+	},
+}
+
+// pathRoleVersionsList lists the recorded revisions of a role
+func (backend *ArtifactoryBackend) pathRoleVersionsList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("name").(string)
+	versions, err := listRoleRevisionVersions(ctx, req.Storage, roleName)
+	if err != nil {
+		return logical.ErrorResponse("Error listing role versions"), err
+	}
+
+	keys := make([]string, 0, len(versions))
+	keyInfo := make(map[string]interface{}, len(versions))
+	for _, version := range versions {
+		revision, err := getRoleRevision(ctx, req.Storage, roleName, version)
+		if err != nil {
+			return logical.ErrorResponse("Error reading role version"), err
+		}
+		if revision == nil {
+			continue
+		}
+		key := strconv.Itoa(version)
+		keys = append(keys, key)
+		keyInfo[key] = map[string]interface{}{
+			"created_at": revision.CreatedAt.Format(time.RFC3339),
+			"entity_id":  revision.EntityID,
+		}
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+// pathRoleVersionRead reads a single revision of a role
+func (backend *ArtifactoryBackend) pathRoleVersionRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("name").(string)
+	revision, err := getRoleRevision(ctx, req.Storage, roleName, data.Get("version").(int))
+	if err != nil {
+		return logical.ErrorResponse("Error reading role version"), err
+	}
+	if revision == nil {
+		return nil, nil
+	}
+
+	role := revision.Role
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"version":                 revision.Version,
+			"created_at":              revision.CreatedAt.Format(time.RFC3339),
+			"entity_id":               revision.EntityID,
+			"name":                    role.Name,
+			"id":                      role.RoleID,
+			"token_ttl":               int64(role.TokenTTL / time.Second),
+			"max_ttl":                 int64(role.MaxTTL / time.Second),
+			"permission_targets":      role.RawPermissionTargets,
+			"groups":                  role.Groups,
+			"docker_registries":       role.DockerRegistries,
+			"max_active_tokens":       role.MaxActiveTokens,
+			"max_issue_rate":          role.MaxIssueRate,
+			"include_reference_token": role.IncludeReferenceToken,
+			"inherits":                role.Inherits,
+			"permission_target_names": role.permissionTargetNames(),
+		},
+	}, nil
+}
+
+// pathRoleRollback re-applies a past revision of a role to Artifactory and storage
+func (backend *ArtifactoryBackend) pathRoleRollback(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("name").(string)
+	versionRaw, ok := data.GetOk("version")
+	if !ok {
+		return logical.ErrorResponse("version is required"), nil
+	}
+	version := versionRaw.(int)
+
+	lock := backend.roleLock(roleName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	revision, err := getRoleRevision(ctx, req.Storage, roleName, version)
+	if err != nil {
+		return logical.ErrorResponse("Error reading role version"), err
+	}
+	if revision == nil {
+		return logical.ErrorResponse(fmt.Sprintf("Version %d of role '%s' does not exist", version, roleName)), nil
+	}
+
+	groups := revision.Role.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	registries := revision.Role.DockerRegistries
+	if registries == nil {
+		registries = []string{}
+	}
+	inherits := revision.Role.Inherits
+	if inherits == nil {
+		inherits = []string{}
+	}
+
+	raw := map[string]interface{}{
+		"name":                    roleName,
+		"token_ttl":               int64(revision.Role.ExplicitTokenTTL / time.Second),
+		"max_ttl":                 int64(revision.Role.ExplicitMaxTTL / time.Second),
+		"groups":                  groups,
+		"docker_registries":       registries,
+		"max_active_tokens":       revision.Role.MaxActiveTokens,
+		"max_issue_rate":          revision.Role.MaxIssueRate,
+		"include_reference_token": revision.Role.IncludeReferenceToken,
+		"inherits":                inherits,
+		"permission_targets":      revision.Role.RawPermissionTargets,
+		"dry_run":                 data.Get("dry_run"),
+	}
+
+	backend.Logger().Info("rolling back role", "role_name", roleName, "version", version)
+	return backend.createUpdateRole(ctx, req, &framework.FieldData{Raw: raw, Schema: createRoleSchema})
+}
+
+func pathRoleHistory(backend *ArtifactoryBackend) []*framework.Path {
+	paths := []*framework.Path{
+		{
+			Pattern: fmt.Sprintf("%s/%s/versions/?", rolesPrefix, framework.GenericNameRegex("name")),
+			Fields:  roleVersionSchema,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: backend.pathRoleVersionsList,
+			},
+			HelpSynopsis:    pathRoleVersionsHelpSyn,
+			HelpDescription: pathRoleVersionsHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/%s/versions/%s", rolesPrefix, framework.GenericNameRegex("name"), `(?P<version>\d+)`),
+			Fields:  roleVersionSchema,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: backend.pathRoleVersionRead,
+			},
+			HelpSynopsis:    pathRoleVersionsHelpSyn,
+			HelpDescription: pathRoleVersionsHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/%s/rollback", rolesPrefix, framework.GenericNameRegex("name")),
+			Fields:  rollbackRoleSchema,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: forwardedOperation(backend.pathRoleRollback),
+			},
+			HelpSynopsis:    pathRoleRollbackHelpSyn,
+			HelpDescription: pathRoleRollbackHelpDesc,
+		},
+	}
+
+	return paths
+}
+
+const pathRoleVersionsHelpSyn = `List and read the past revisions of a role.`
+
+var pathRoleVersionsHelpDesc = fmt.Sprintf(`
+Every successful write of a role is recorded as a new revision, along with its
+timestamp and the entity ID of the requester. The last %d revisions of each
+role are kept, including after the role is deleted.
+
+  $ vault list artifactory/roles/ci-role/versions
+  $ vault read artifactory/roles/ci-role/versions/3
+`, roleHistorySize)
+
+const pathRoleRollbackHelpSyn = `Re-apply a past revision of a role.`
+const pathRoleRollbackHelpDesc = `
+This path re-applies the TTLs, groups, docker registries, inherited templates
+and permission targets of a past revision to Artifactory and storage, as if they were written to the
+role again. The rollback itself is recorded as a new revision. A deleted role
+is recreated from its revision.
+
+  $ vault write artifactory/roles/ci-role/rollback version=3
+
+With "dry_run=true" the planned changes are returned without modifying
+Artifactory or storage.
+`
@@ -0,0 +1,135 @@
+// Copyright  2024 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathRoleHistory(t *testing.T) {
+	t.Parallel()
+	req, backend := newArtMockEnv(t)
+	testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+		"max_ttl":      "3600s",
+	})
+
+	ctx := context.Background()
+	roleName := "test_history_role"
+	ptRead := `{"repo": {"repositories": ["ANY"], "operations": ["read"]}}`
+	ptWrite := `{"repo": {"repositories": ["ANY"], "operations": ["write"]}}`
+
+	req.EntityID = "entity-1"
+	mustRoleCreate(req, backend, t, roleName, map[string]interface{}{
+		"permission_targets": fmt.Sprintf("[%s, %s]", ptRead, ptWrite),
+	})
+	req.EntityID = "entity-2"
+	mustRoleUpdate(req, backend, t, roleName, map[string]interface{}{
+		"permission_targets": fmt.Sprintf("[%s]", ptRead),
+		"groups":             []string{"testgroup1"},
+	})
+	req.EntityID = ""
+
+	t.Run("list_versions", func(t *testing.T) {
+		resp, err := testRoleHistoryRequest(req, backend, logical.ListOperation, fmt.Sprintf("roles/%s/versions", roleName), nil)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, []string{"1", "2"}, resp.Data["keys"])
+		keyInfo := resp.Data["key_info"].(map[string]interface{})
+		assert.Equal(t, "entity-1", keyInfo["1"].(map[string]interface{})["entity_id"])
+		assert.Equal(t, "entity-2", keyInfo["2"].(map[string]interface{})["entity_id"])
+	})
+
+	t.Run("read_version", func(t *testing.T) {
+		resp, err := testRoleHistoryRequest(req, backend, logical.ReadOperation, fmt.Sprintf("roles/%s/versions/1", roleName), nil)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, 1, resp.Data["version"])
+		assert.Equal(t, fmt.Sprintf("[%s, %s]", ptRead, ptWrite), resp.Data["permission_targets"])
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		resp, err := testRoleHistoryRequest(req, backend, logical.UpdateOperation, fmt.Sprintf("roles/%s/rollback", roleName), map[string]interface{}{
+			"version": 1,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+
+		role, err := getRoleEntry(ctx, req.Storage, roleName)
+		require.NoError(t, err)
+		assert.Len(t, role.PermissionTargets, 2)
+		assert.Empty(t, role.Groups)
+
+		versions, err := listRoleRevisionVersions(ctx, req.Storage, roleName)
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 3}, versions, "rollback is recorded as a new revision")
+	})
+
+	t.Run("rollback_deleted_role", func(t *testing.T) {
+		mustRoleDelete(req, backend, t, roleName)
+
+		resp, err := testRoleHistoryRequest(req, backend, logical.UpdateOperation, fmt.Sprintf("roles/%s/rollback", roleName), map[string]interface{}{
+			"version": 2,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+
+		role, err := getRoleEntry(ctx, req.Storage, roleName)
+		require.NoError(t, err)
+		require.NotNil(t, role)
+		assert.Len(t, role.PermissionTargets, 1)
+		assert.Equal(t, []string{"testgroup1"}, role.Groups)
+	})
+
+	t.Run("rollback_unknown_version", func(t *testing.T) {
+		resp, err := testRoleHistoryRequest(req, backend, logical.UpdateOperation, fmt.Sprintf("roles/%s/rollback", roleName), map[string]interface{}{
+			"version": 42,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"].(string), "does not exist")
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		name := "test_prune_role"
+		for i := 0; i < roleHistorySize+2; i++ {
+			mustRoleUpdate(req, backend, t, name, map[string]interface{}{
+				"groups":    []string{"testgroup1"},
+				"token_ttl": fmt.Sprintf("%ds", 60+i),
+			})
+		}
+
+		versions, err := listRoleRevisionVersions(ctx, req.Storage, name)
+		require.NoError(t, err)
+		require.Len(t, versions, roleHistorySize)
+		assert.Equal(t, 3, versions[0])
+		assert.Equal(t, roleHistorySize+2, versions[len(versions)-1])
+	})
+}
+
+func testRoleHistoryRequest(req *logical.Request, b logical.Backend, op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+	req.Operation = op
+	req.Path = path
+	req.Data = data
+
+	return b.HandleRequest(context.Background(), req)
+}
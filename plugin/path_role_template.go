@@ -0,0 +1,278 @@
+// Copyright  2024 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+var roleTemplateSchema = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "The name of the role template",
+	},
+	"token_ttl": {
+		Type:        framework.TypeDurationSecond,
+		Description: "The default TTL of the token for inheriting roles without their own token_ttl",
+	},
+	"max_ttl": {
+		Type:        framework.TypeDurationSecond,
+		Description: "The default max TTL of the token for inheriting roles without their own max_ttl",
+	},
+	"permission_targets": {
+		Type:        framework.TypeString,
+		Description: "List of permission target configurations inherited by roles",
+	},
+	"groups": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Optional comma-separated list of static, pre-existing groups inherited by roles",
+	},
+	"propagate": {
+		Type:        framework.TypeBool,
+		Description: "If true, re-apply every role inheriting from the template after the update",
+		Default:     false,
+	},
+}
+
+func (backend *ArtifactoryBackend) pathRoleTemplateCreateUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	templateName := data.Get("name").(string)
+	if templateName == "" {
+		return logical.ErrorResponse("Template name not supplied"), nil
+	}
+
+	config, err := backend.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain artifactory config - %s", err.Error())
+	}
+	if config == nil {
+		return nil, fmt.Errorf("artifactory backend configuration has not been set up")
+	}
+
+	template, err := getRoleTemplateEntry(ctx, req.Storage, templateName)
+	if err != nil {
+		return logical.ErrorResponse("Error reading role template"), err
+	}
+	if template == nil {
+		template = &RoleTemplateEntry{Name: templateName}
+	}
+
+	if groupsRaw, ok := data.GetOk("groups"); ok {
+		template.Groups = groupsRaw.([]string)
+	}
+	if ttlRaw, ok := data.GetOk("token_ttl"); ok {
+		template.TokenTTL = time.Duration(ttlRaw.(int)) * time.Second
+	}
+	if maxttlRaw, ok := data.GetOk("max_ttl"); ok {
+		template.MaxTTL = time.Duration(maxttlRaw.(int)) * time.Second
+	}
+	if template.MaxTTL > config.MaxTTL {
+		return logical.ErrorResponse(fmt.Sprintf("template max ttl is greater than config max ttl '%d'", config.MaxTTL)), nil
+	}
+
+	if ptsRaw, ok := data.GetOk("permission_targets"); ok {
+		var pts []PermissionTarget
+		if ptsRaw.(string) != "" {
+			if err := json.Unmarshal([]byte(ptsRaw.(string)), &pts); err != nil {
+				return logical.ErrorResponse("Error unmarshal permission targets. Expecting list of permission targets - " + err.Error()), nil
+			}
+		}
+		if err := normalizePermissionTargets(pts, config.operationPresets()); err != nil {
+			return logical.ErrorResponse("Failed to validate a permission target - " + err.Error()), nil
+		}
+		for _, pt := range pts {
+			if err := pt.assertValid(); err != nil {
+				return logical.ErrorResponse("Failed to validate a permission target - " + err.Error()), nil
+			}
+		}
+		if err := validatePermissionTargetIdentities(pts); err != nil {
+			return logical.ErrorResponse("Failed to validate permission targets - " + err.Error()), nil
+		}
+		template.RawPermissionTargets = ptsRaw.(string)
+		template.PermissionTargets = pts
+	}
+
+	if err := template.validate(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := backend.validateArtifactoryReferences(ctx, req.Storage, template.Groups, template.PermissionTargets); err != nil {
+		return logical.ErrorResponse("Failed to validate role template against Artifactory - " + err.Error()), nil
+	}
+	if err := template.save(ctx, req.Storage); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	dependents, err := backend.listDependentRoles(ctx, req.Storage, templateName)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"name":            templateName,
+			"dependent_roles": dependents,
+		},
+	}
+	if !data.Get("propagate").(bool) {
+		if len(dependents) > 0 {
+			resp.AddWarning(fmt.Sprintf("%d dependent roles keep their current permission targets until rewritten or the template is written with propagate=true", len(dependents)))
+		}
+		return resp, nil
+	}
+
+	results := make([]map[string]interface{}, 0, len(dependents))
+	for _, roleName := range dependents {
+		result := map[string]interface{}{"name": roleName, "status": syncStatusApplied}
+		roleResp, err := backend.reapplyRole(ctx, req, roleName)
+		switch {
+		case err != nil:
+			result["status"] = syncStatusFailed
+			result["error"] = err.Error()
+		case roleResp != nil && roleResp.IsError():
+			result["status"] = syncStatusFailed
+			result["error"] = roleResp.Error().Error()
+		case roleResp != nil && len(roleResp.Warnings) > 0:
+			result["warnings"] = roleResp.Warnings
+		}
+		if result["status"] == syncStatusFailed {
+			resp.AddWarning(fmt.Sprintf("failed to propagate template to role '%s'", roleName))
+		}
+		results = append(results, result)
+	}
+	resp.Data["results"] = results
+
+	return resp, nil
+}
+
+// reapplyRole recomputes the effective permission targets and groups of a role from its
+// current templates and applies them to Artifactory and storage
+func (backend *ArtifactoryBackend) reapplyRole(ctx context.Context, req *logical.Request, roleName string) (*logical.Response, error) {
+	lock := backend.roleLock(roleName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	role, err := getRoleEntry(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+
+	raw := map[string]interface{}{
+		"name":     roleName,
+		"inherits": role.Inherits,
+	}
+	return backend.createUpdateRole(ctx, req, &framework.FieldData{Raw: raw, Schema: createRoleSchema})
+}
+
+func (backend *ArtifactoryBackend) pathRoleTemplateRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	template, err := getRoleTemplateEntry(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return logical.ErrorResponse("Error reading role template"), err
+	}
+	if template == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"name":               template.Name,
+			"token_ttl":          int64(template.TokenTTL / time.Second),
+			"max_ttl":            int64(template.MaxTTL / time.Second),
+			"permission_targets": template.RawPermissionTargets,
+			"groups":             template.Groups,
+		},
+	}, nil
+}
+
+func (backend *ArtifactoryBackend) pathRoleTemplateDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	templateName := data.Get("name").(string)
+
+	dependents, err := backend.listDependentRoles(ctx, req.Storage, templateName)
+	if err != nil {
+		return nil, err
+	}
+	if len(dependents) > 0 {
+		return logical.ErrorResponse(fmt.Sprintf("role template '%s' is inherited by roles: %s", templateName, strings.Join(dependents, ", "))), nil
+	}
+
+	if err := deleteRoleTemplateEntry(ctx, req.Storage, templateName); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("Unable to remove role template %s", templateName)), err
+	}
+	return nil, nil
+}
+
+func (backend *ArtifactoryBackend) pathRoleTemplatesList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	templates, err := listRoleTemplateEntries(ctx, req.Storage)
+	if err != nil {
+		return logical.ErrorResponse("Error listing role templates"), err
+	}
+	return logical.ListResponse(templates), nil
+}
+
+func pathRoleTemplate(backend *ArtifactoryBackend) []*framework.Path {
+	paths := []*framework.Path{
+		{
+			Pattern: fmt.Sprintf("%s/%s", roleTemplatesPrefix, framework.GenericNameRegex("name")),
+			Fields:  roleTemplateSchema,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: forwardedOperation(backend.pathRoleTemplateCreateUpdate),
+				logical.ReadOperation:   &framework.PathOperation{Callback: backend.pathRoleTemplateRead},
+				logical.DeleteOperation: forwardedOperation(backend.pathRoleTemplateDelete),
+			},
+			HelpSynopsis:    pathRoleTemplateHelpSyn,
+			HelpDescription: pathRoleTemplateHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/?", roleTemplatesPrefix),
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: backend.pathRoleTemplatesList,
+			},
+			HelpSynopsis: pathListRoleTemplateHelpSyn,
+		},
+	}
+
+	return paths
+}
+
+const pathRoleTemplateHelpSyn = `Read/write role templates holding permission targets, groups and TTL defaults shared by roles.`
+const pathRoleTemplateHelpDesc = `
+Role templates hold permission targets, static groups and TTL defaults that
+roles inherit through their "inherits" field. The permission targets are in the
+same format as the ones of roles.
+
+  $ vault write artifactory/role-templates/remote-caches \
+      permission_targets=@remote-caches.json
+  $ vault write artifactory/roles/ci-role inherits=remote-caches \
+      permission_targets=@ci-write.json
+
+The effective permission targets of a role, its templates' in order followed by
+its own, are computed when the role is written and pushed to Artifactory.
+Identical permission targets are only kept once. TTL defaults apply when the
+role doesn't set its own TTLs.
+
+A template update reports its dependent roles. With "propagate=true" every
+dependent role is re-applied with the updated template. A template can't be
+deleted while roles inherit from it.
+`
+
+const pathListRoleTemplateHelpSyn = `List existing role templates.`
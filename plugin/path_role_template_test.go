@@ -0,0 +1,176 @@
+// Copyright  2024 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathRoleTemplate(t *testing.T) {
+	t.Parallel()
+	req, backend := newArtMockEnv(t)
+	testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+		"max_ttl":      "3600s",
+	})
+
+	ctx := context.Background()
+	ptCaches := `{"name": "caches", "repo": {"repositories": ["ANY REMOTE"], "operations": ["read"]}}`
+	ptWrite := `{"repo": {"repositories": ["ANY LOCAL"], "operations": ["write"]}}`
+
+	resp, err := testRoleTemplateRequest(req, backend, logical.UpdateOperation, "role-templates/caches", map[string]interface{}{
+		"permission_targets": fmt.Sprintf("[%s]", ptCaches),
+		"groups":             []string{"readers"},
+		"token_ttl":          "120s",
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+
+	t.Run("inherit", func(t *testing.T) {
+		mustRoleCreate(req, backend, t, "inherit_role", map[string]interface{}{
+			"inherits":           []string{"caches"},
+			"permission_targets": fmt.Sprintf("[%s]", ptWrite),
+		})
+
+		role, err := getRoleEntry(ctx, req.Storage, "inherit_role")
+		require.NoError(t, err)
+		require.Len(t, role.PermissionTargets, 2)
+		assert.Equal(t, "caches", role.PermissionTargets[0].Name)
+		assert.Equal(t, []string{"write"}, role.PermissionTargets[1].Repo.Operations)
+		assert.Equal(t, fmt.Sprintf("[%s]", ptWrite), role.RawPermissionTargets)
+		assert.Equal(t, []string{"readers"}, role.InheritedGroups)
+		assert.Equal(t, 120*time.Second, role.TokenTTL)
+		assert.Contains(t, tokenScope(role), "readers")
+	})
+
+	t.Run("inherit_only", func(t *testing.T) {
+		mustRoleCreate(req, backend, t, "template_only_role", map[string]interface{}{
+			"inherits": []string{"caches"},
+		})
+
+		role, err := getRoleEntry(ctx, req.Storage, "template_only_role")
+		require.NoError(t, err)
+		assert.Len(t, role.PermissionTargets, 1)
+		assert.Empty(t, role.RawPermissionTargets)
+	})
+
+	t.Run("missing_template", func(t *testing.T) {
+		resp, err := testRoleCreate(req, backend, t, "missing_template_role", map[string]interface{}{
+			"inherits": []string{"nope"},
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"].(string), "role template 'nope' does not exist")
+	})
+
+	t.Run("conflicting_identity", func(t *testing.T) {
+		resp, err := testRoleCreate(req, backend, t, "conflict_role", map[string]interface{}{
+			"inherits":           []string{"caches"},
+			"permission_targets": `[{"name": "caches", "repo": {"repositories": ["ANY"], "operations": ["read"]}}]`,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"].(string), "conflicting inherited permission targets")
+	})
+
+	t.Run("propagate", func(t *testing.T) {
+		mustRoleCreate(req, backend, t, "explicit_ttl_role", map[string]interface{}{
+			"inherits":  []string{"caches"},
+			"token_ttl": "60s",
+		})
+		// patching an inheriting role keeps its TTLs inherited
+		resp, err := testRolePatch(req, backend, t, "template_only_role", map[string]interface{}{
+			"docker_registries": []string{"docker.example.com"},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+
+		ptCachesAnnotate := `{"name": "caches", "repo": {"repositories": ["ANY REMOTE"], "operations": ["read", "annotate"]}}`
+		resp, err = testRoleTemplateRequest(req, backend, logical.UpdateOperation, "role-templates/caches", map[string]interface{}{
+			"permission_targets": fmt.Sprintf("[%s]", ptCachesAnnotate),
+			"token_ttl":          "300s",
+			"propagate":          true,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		assert.ElementsMatch(t, []string{"inherit_role", "template_only_role", "explicit_ttl_role"}, resp.Data["dependent_roles"])
+		for _, result := range resp.Data["results"].([]map[string]interface{}) {
+			assert.Equal(t, syncStatusApplied, result["status"], "role %s", result["name"])
+		}
+
+		role, err := getRoleEntry(ctx, req.Storage, "inherit_role")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"read", "annotate"}, role.PermissionTargets[0].Repo.Operations)
+		assert.Equal(t, 300*time.Second, role.TokenTTL, "inherited TTLs follow the template")
+
+		role, err = getRoleEntry(ctx, req.Storage, "template_only_role")
+		require.NoError(t, err)
+		assert.Equal(t, 300*time.Second, role.TokenTTL, "inherited TTLs follow the template")
+
+		role, err = getRoleEntry(ctx, req.Storage, "explicit_ttl_role")
+		require.NoError(t, err)
+		assert.Equal(t, 60*time.Second, role.TokenTTL, "explicit TTLs win over the template")
+	})
+
+	t.Run("delete_in_use", func(t *testing.T) {
+		resp, err := testRoleTemplateRequest(req, backend, logical.DeleteOperation, "role-templates/caches", nil)
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"].(string), "is inherited by roles")
+	})
+
+	t.Run("list", func(t *testing.T) {
+		resp, err := testRoleTemplateRequest(req, backend, logical.ListOperation, "role-templates/", nil)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"caches"}, resp.Data["keys"])
+	})
+}
+
+func TestInheritPermissionTargets(t *testing.T) {
+	t.Parallel()
+
+	read := PermissionTarget{Repo: &Permission{Repositories: []string{"ANY"}, Operations: []string{"read"}}}
+	write := PermissionTarget{Repo: &Permission{Repositories: []string{"ANY"}, Operations: []string{"write"}}}
+	templates := []*RoleTemplateEntry{
+		{Name: "t1", PermissionTargets: []PermissionTarget{read}, Groups: []string{"g1", "g2"}, TokenTTL: time.Minute},
+		{Name: "t2", PermissionTargets: []PermissionTarget{read, write}, Groups: []string{"g2"}, MaxTTL: time.Hour},
+	}
+
+	pts, err := inheritPermissionTargets(templates, []PermissionTarget{write})
+	require.NoError(t, err)
+	assert.Equal(t, []PermissionTarget{read, write}, pts, "identical permission targets are kept once")
+
+	assert.Equal(t, []string{"g2"}, inheritGroups(templates, []string{"g1"}))
+
+	tokenTTL, maxTTL := inheritTTLs(templates)
+	assert.Equal(t, time.Minute, tokenTTL)
+	assert.Equal(t, time.Hour, maxTTL)
+}
+
+func testRoleTemplateRequest(req *logical.Request, b logical.Backend, op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+	req.Operation = op
+	req.Path = path
+	req.Data = data
+
+	return b.HandleRequest(context.Background(), req)
+}
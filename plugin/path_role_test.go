@@ -18,7 +18,10 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/jfrog/jfrog-client-go/artifactory"
@@ -170,7 +173,7 @@ func TestArtAccPathRole(t *testing.T) {
 		resp, err := testRoleCreate(req, backend, t, roleName, data)
 		require.NoError(t, err)
 		actualErr := resp.Data["error"].(string)
-		expected := fmt.Sprintf("Permission target contains a reference to a non-existing repository '%s'", nonexistingRepoName)
+		expected := fmt.Sprintf("repository '%s' does not exist", nonexistingRepoName)
 		assert.Contains(t, actualErr, expected)
 	})
 }
@@ -315,6 +318,7 @@ func TestArtAccPermissionTargets(t *testing.T) {
 
 		assertPermissionTarget(t, ac, role, 0)
 		assertPermissionTarget(t, ac, role, 1)
+		removedPtName := role.PermissionTargetNames[1]
 
 		removedPt := fmt.Sprintf(`
 		[
@@ -337,7 +341,7 @@ func TestArtAccPermissionTargets(t *testing.T) {
 
 		// assert permission target in Artifactory matches role data
 		assertPermissionTarget(t, ac, role, 0)
-		assertPermissionTargetDeleted(t, ac, role, 1)
+		assertPermissionTargetDeleted(t, ac, removedPtName)
 	})
 
 	t.Run("delete_role_removes_resources", func(t *testing.T) {
@@ -355,11 +359,12 @@ func TestArtAccPermissionTargets(t *testing.T) {
 		require.NoError(t, err)
 
 		assertPermissionTarget(t, ac, role, 0)
+		ptName := role.PermissionTargetNames[0]
 
 		mustRoleDelete(req, backend, t, roleName)
 
 		assertGroupDeleted(t, ac, role)
-		assertPermissionTargetDeleted(t, ac, role, 0)
+		assertPermissionTargetDeleted(t, ac, ptName)
 
 		role, err = getRoleEntry(ctx, req.Storage, roleName)
 		require.Nil(t, role)
@@ -543,12 +548,538 @@ func TestPathRole(t *testing.T) {
 
 		assert.Len(t, groups, 2)
 	})
+
+	t.Run("dry_run", func(t *testing.T) {
+		roleName := "test_role_dry_run"
+		rawPt := `
+		[
+			{"name": "reader", "repo": {"repositories": ["ANY"], "operations": ["read"]}},
+			{"name": "writer", "repo": {"repositories": ["ANY"], "operations": ["write"]}}
+		]
+		`
+		data := map[string]interface{}{
+			"permission_targets": rawPt,
+			"groups":             []string{"testgroup1"},
+			"dry_run":            true,
+		}
+		resp, err := testRoleCreate(req, backend, t, roleName, data)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		assert.Equal(t, "create", resp.Data["role_action"])
+		assert.Equal(t, "create", resp.Data["group"].(map[string]interface{})["action"])
+		assert.Equal(t, []map[string]interface{}{
+			{"name": "vault-plugin.pt-reader.test_role_dry_run", "action": "create"},
+			{"name": "vault-plugin.pt-writer.test_role_dry_run", "action": "create"},
+		}, resp.Data["permission_targets"])
+		assert.Equal(t, fmt.Sprintf("applied-permissions/groups:vault-plugin.%s,testgroup1", roleID(roleName)), resp.Data["token_scope"])
+
+		role, err := getRoleEntry(context.Background(), req.Storage, roleName)
+		require.NoError(t, err)
+		assert.Nil(t, role, "dry run should not persist the role")
+
+		delete(data, "dry_run")
+		mustRoleCreate(req, backend, t, roleName, data)
+
+		resp, err = testRoleUpdate(req, backend, t, roleName, map[string]interface{}{
+			"permission_targets": `[{"name": "writer", "repo": {"repositories": ["ANY"], "operations": ["read", "write"]}}]`,
+			"dry_run":            true,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		assert.Equal(t, "update", resp.Data["role_action"])
+		assert.Equal(t, "update", resp.Data["group"].(map[string]interface{})["action"])
+		assert.Equal(t, []map[string]interface{}{
+			{"name": "vault-plugin.pt-reader.test_role_dry_run", "action": "delete"},
+			{"name": "vault-plugin.pt-writer.test_role_dry_run", "action": "update"},
+		}, resp.Data["permission_targets"])
+
+		role, err = getRoleEntry(context.Background(), req.Storage, roleName)
+		require.NoError(t, err)
+		assert.Len(t, role.PermissionTargets, 2, "dry run should not modify the role")
+	})
+}
+
+func TestPathRoleValidateArtifactoryReferences(t *testing.T) {
+	t.Parallel()
+	req, b := newArtMockEnv(t)
+	useMockClient(b, &mockArtifactoryClient{
+		missingRepositories: []string{"missing-repo1", "missing-repo2"},
+		missingGroups:       []string{"missing-group"},
+	})
+	testConfigUpdate(t, b, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+		"max_ttl":      "3600s",
+	})
+
+	t.Run("all_missing_references_reported", func(t *testing.T) {
+		resp, err := testRoleCreate(req, b, t, "test_role_missing_refs", map[string]interface{}{
+			"permission_targets": `[
+				{"repo": {"repositories": ["missing-repo1", "ANY LOCAL", "existing-repo"], "operations": ["read"]}},
+				{"repo": {"repositories": ["missing-repo2", "missing-repo1"], "operations": ["write"]}}
+			]`,
+			"groups": []string{"existing-group", "missing-group"},
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+
+		actualErr := resp.Data["error"].(string)
+		assert.Contains(t, actualErr, "repository 'missing-repo1' does not exist")
+		assert.Contains(t, actualErr, "repository 'missing-repo2' does not exist")
+		assert.Contains(t, actualErr, "group 'missing-group' does not exist")
+		assert.Contains(t, actualErr, "3 errors occurred")
+
+		role, err := getRoleEntry(context.Background(), req.Storage, "test_role_missing_refs")
+		require.NoError(t, err)
+		assert.Nil(t, role)
+	})
+
+	t.Run("static_groups_only", func(t *testing.T) {
+		resp, err := testRoleCreate(req, b, t, "test_role_missing_group", map[string]interface{}{
+			"groups": []string{"missing-group"},
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"].(string), "group 'missing-group' does not exist")
+	})
+}
+
+func TestPathRoleCheck(t *testing.T) {
+	t.Parallel()
+	req, b := newArtMockEnv(t)
+	useMockClient(b, &mockArtifactoryClient{
+		groupPermissionTargets: map[string][]PermissionTarget{
+			"deployers": {
+				{Name: "deploy-libs", Repo: &Permission{Repositories: []string{"libs-release"}, Operations: []string{"read", "write"}}},
+			},
+		},
+	})
+	testConfigUpdate(t, b, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+		"max_ttl":      "3600s",
+	})
+
+	roleName := "test_check_role"
+	mustRoleCreate(req, b, t, roleName, map[string]interface{}{
+		"permission_targets": `[{"name": "reader", "repo": {"include_patterns": ["com/**"], "exclude_patterns": ["com/secret/**"], "repositories": ["libs-release"], "operations": ["read"]}}]`,
+		"groups":             []string{"deployers"},
+	})
+
+	check := func(t *testing.T, repository, path, operation string) *logical.Response {
+		t.Helper()
+		req.Operation = logical.ReadOperation
+		req.Path = fmt.Sprintf("roles/%s/check", roleName)
+		req.Data = map[string]interface{}{"repository": repository, "path": path, "operation": operation}
+		resp, err := b.HandleRequest(context.Background(), req)
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		return resp
+	}
+
+	t.Run("allowed_by_role", func(t *testing.T) {
+		resp := check(t, "libs-release", "com/foo/bar.jar", "read")
+		assert.True(t, resp.Data["allowed"].(bool))
+		assert.Equal(t, []map[string]interface{}{
+			{"permission_target": "vault-plugin.pt-reader.test_check_role", "source": groupName(&RoleStorageEntry{RoleID: roleID(roleName)})},
+			{"permission_target": "deploy-libs", "source": "deployers"},
+		}, resp.Data["matches"])
+	})
+
+	t.Run("allowed_by_static_group", func(t *testing.T) {
+		resp := check(t, "libs-release", "com/secret/bar.jar", "write")
+		assert.True(t, resp.Data["allowed"].(bool))
+		assert.Len(t, resp.Data["matches"], 1)
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		resp := check(t, "libs-snapshot", "com/foo/bar.jar", "read")
+		assert.False(t, resp.Data["allowed"].(bool))
+		assert.Empty(t, resp.Data["matches"])
+	})
+
+	t.Run("alias", func(t *testing.T) {
+		resp := check(t, "libs-release", "com/secret/bar.jar", "deploy")
+		assert.True(t, resp.Data["allowed"].(bool))
+		assert.Equal(t, "write", resp.Data["operation"])
+	})
+}
+
+func TestPathRoleOperationPresets(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, b := newArtMockEnv(t)
+	testConfigUpdate(t, b, req.Storage, map[string]interface{}{
+		"base_url":          "https://example.jfrog.io/example",
+		"bearer_token":      "mybearertoken",
+		"operation_presets": `{"promoter": ["read", "annotate"]}`,
+	})
+
+	rawPts := `[{"name": "libs", "repo": {"repositories": ["libs-release"], "operations": ["maintainer"]}}, {"name": "builds", "build": {"repositories": ["artifactory-build-info"], "operations": ["promoter", "M"]}}]`
+	mustRoleCreate(req, b, t, "preset_role", map[string]interface{}{"permission_targets": rawPts})
+
+	role, err := getRoleEntry(ctx, req.Storage, "preset_role")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"read", "annotate", "write", "delete"}, role.PermissionTargets[0].Repo.Operations)
+	assert.Equal(t, []string{"read", "annotate", "manage"}, role.PermissionTargets[1].Build.Operations)
+
+	// the role reads back as written
+	resp, err := testRoleRead(req, b, t, "preset_role")
+	require.NoError(t, err)
+	assert.Equal(t, rawPts, resp.Data["permission_targets"])
+
+	resp, err = testRoleCreate(req, b, t, "unknown_preset", map[string]interface{}{
+		"permission_targets": `[{"repo": {"repositories": ["libs-release"], "operations": ["publisher"]}}]`,
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+	assert.Contains(t, resp.Error().Error(), "operation 'publisher' is not allowed")
+}
+
+func TestPathRolePatternLint(t *testing.T) {
+	t.Parallel()
+	req, b := newArtMockEnv(t)
+	testConfigUpdate(t, b, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+	})
+
+	shadowed := `[{"repo": {"include_patterns": ["com/secret/**"], "exclude_patterns": ["com/**"], "repositories": ["libs-release"], "operations": ["read"]}}]`
+	for _, dryRun := range []bool{true, false} {
+		resp, err := testRoleCreate(req, b, t, "lint_role", map[string]interface{}{
+			"permission_targets": shadowed,
+			"dry_run":            dryRun,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		require.Len(t, resp.Warnings, 1, "dry_run=%t", dryRun)
+		assert.Contains(t, resp.Warnings[0], "is entirely excluded by 'com/**'")
+	}
+
+	// unchanged permission targets are linted again
+	resp, err := testRolePatch(req, b, t, "lint_role", map[string]interface{}{"token_ttl": "300s"})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	require.Len(t, resp.Warnings, 1)
+
+	resp, err = testRoleCreate(req, b, t, "invalid_pattern_role", map[string]interface{}{
+		"permission_targets": `[{"repo": {"include_patterns": ["myprefix/**/"], "repositories": ["libs-release"], "operations": ["read"]}}]`,
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+	assert.Contains(t, resp.Error().Error(), "must not end with '**/'")
+
+	// the check agrees with the lint about empty include patterns
+	resp, err = testRoleCreate(req, b, t, "empty_include_role", map[string]interface{}{
+		"permission_targets": `[{"repo": {"include_patterns": [""], "repositories": ["libs-release"], "operations": ["read"]}}]`,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	require.Len(t, resp.Warnings, 1)
+	assert.Contains(t, resp.Warnings[0], "grants nothing")
+
+	req.Operation = logical.ReadOperation
+	req.Path = "roles/empty_include_role/check"
+	req.Data = map[string]interface{}{"repository": "libs-release", "path": "com/foo/bar.jar", "operation": "read"}
+	resp, err = b.HandleRequest(context.Background(), req)
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	assert.False(t, resp.Data["allowed"].(bool))
+}
+
+func TestPathRolePatch(t *testing.T) {
+	t.Parallel()
+	req, backend := newArtMockEnv(t)
+	testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+		"max_ttl":      "3600s",
+	})
+
+	ctx := context.Background()
+	roleName := "test_patch_role"
+	ptRead := `{"repo": {"repositories": ["ANY"], "operations": ["read"]}}`
+	ptWrite := `{"repo": {"repositories": ["ANY"], "operations": ["write"]}}`
+	mustRoleCreate(req, backend, t, roleName, map[string]interface{}{
+		"permission_targets": fmt.Sprintf("[%s, %s]", ptRead, ptWrite),
+		"groups":             []string{"testgroup1"},
+		"token_ttl":          "600s",
+	})
+
+	t.Run("nonexistent_role", func(t *testing.T) {
+		resp, err := testRolePatch(req, backend, t, "noname", map[string]interface{}{"token_ttl": "60s"})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"].(string), "does not exist")
+	})
+
+	t.Run("merge_fields", func(t *testing.T) {
+		resp, err := testRolePatch(req, backend, t, roleName, map[string]interface{}{
+			"token_ttl": "300s",
+			"groups":    []string{"testgroup2"},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+
+		role, err := getRoleEntry(ctx, req.Storage, roleName)
+		require.NoError(t, err)
+		assert.Equal(t, 300*time.Second, role.TokenTTL)
+		assert.Equal(t, time.Hour, role.MaxTTL)
+		assert.Equal(t, []string{"testgroup2"}, role.Groups)
+		assert.Len(t, role.PermissionTargets, 2, "permission targets should be untouched")
+	})
+
+	t.Run("add_and_remove_permission_targets", func(t *testing.T) {
+		ptDelete := `{"repo": {"repositories": ["ANY"], "operations": ["delete"]}}`
+		resp, err := testRolePatch(req, backend, t, roleName, map[string]interface{}{
+			"add_permission_targets":    fmt.Sprintf("[%s]", ptDelete),
+			"remove_permission_targets": fmt.Sprintf("[%s]", ptRead),
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		assert.Empty(t, resp.Warnings)
+
+		role, err := getRoleEntry(ctx, req.Storage, roleName)
+		require.NoError(t, err)
+		require.Len(t, role.PermissionTargets, 2)
+		assert.Equal(t, []string{"write"}, role.PermissionTargets[0].Repo.Operations)
+		assert.Equal(t, []string{"delete"}, role.PermissionTargets[1].Repo.Operations)
+		assert.Equal(t, 300*time.Second, role.TokenTTL)
+	})
+
+	t.Run("remove_absent_permission_target_warns", func(t *testing.T) {
+		resp, err := testRolePatch(req, backend, t, roleName, map[string]interface{}{
+			"remove_permission_targets": fmt.Sprintf("[%s]", ptRead),
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		require.Len(t, resp.Warnings, 1)
+		assert.Contains(t, resp.Warnings[0], "is not part of the role")
+	})
+
+	t.Run("add_permission_targets_requires_patch", func(t *testing.T) {
+		resp, err := testRoleUpdate(req, backend, t, roleName, map[string]interface{}{
+			"add_permission_targets": fmt.Sprintf("[%s]", ptRead),
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"].(string), "only supported with PATCH")
+	})
+}
+
+func TestPathRoleParallelPermissionTargets(t *testing.T) {
+	t.Parallel()
+
+	roleName := "test_parallel_role"
+	var rawPts []string
+	var pts []PermissionTarget
+	for idx := 0; idx < 6; idx++ {
+		repo := fmt.Sprintf("repo-%d", idx)
+		rawPts = append(rawPts, fmt.Sprintf(`{"repo": {"repositories": ["%s"], "operations": ["read"]}}`, repo))
+		pts = append(pts, PermissionTarget{Repo: &Permission{Repositories: []string{repo}, Operations: []string{"read"}}})
+	}
+	rawPermissionTargets := fmt.Sprintf("[%s]", strings.Join(rawPts, ", "))
+
+	newEnv := func(t *testing.T, maxParallelRequests int) (*logical.Request, logical.Backend, *mockArtifactoryClient) {
+		req, backend := newArtMockEnv(t)
+		testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+			"base_url":              "https://example.jfrog.io/example",
+			"bearer_token":          "mybearertoken",
+			"max_parallel_requests": maxParallelRequests,
+		})
+		client := getMockClient(t, backend)
+		client.requestDelay = 20 * time.Millisecond
+		return req, backend, client
+	}
+
+	t.Run("bounded_concurrency", func(t *testing.T) {
+		t.Parallel()
+		req, backend, client := newEnv(t, 3)
+
+		mustRoleCreate(req, backend, t, roleName, map[string]interface{}{
+			"permission_targets": rawPermissionTargets,
+		})
+		assert.Equal(t, 3, client.maxInFlight)
+
+		// deletions are bounded as well
+		client.maxInFlight = 0
+		resp, err := testRoleUpdate(req, backend, t, roleName, map[string]interface{}{
+			"permission_targets": "",
+			"groups":             []string{"testgroup"},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		assert.Equal(t, 3, client.maxInFlight)
+	})
+
+	t.Run("sequential_by_default", func(t *testing.T) {
+		t.Parallel()
+		req, backend, client := newEnv(t, 1)
+
+		mustRoleCreate(req, backend, t, roleName, map[string]interface{}{
+			"permission_targets": rawPermissionTargets,
+		})
+		assert.Equal(t, 1, client.maxInFlight)
+	})
+
+	t.Run("aggregated_errors", func(t *testing.T) {
+		t.Parallel()
+		req, backend, client := newEnv(t, 4)
+		names := permissionTargetNames("", roleName, pts)
+		client.failPermissionTargets = []string{names[1], names[4]}
+
+		resp, err := testRoleUpdate(req, backend, t, roleName, map[string]interface{}{
+			"permission_targets": rawPermissionTargets,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Error().Error(), "2 errors occurred")
+		assert.Contains(t, resp.Error().Error(), names[1])
+		assert.Contains(t, resp.Error().Error(), names[4])
+
+		role, err := getRoleEntry(context.Background(), req.Storage, roleName)
+		require.NoError(t, err)
+		assert.Nil(t, role, "role should not be saved when permission targets fail")
+	})
+}
+
+func TestPathRoleCAS(t *testing.T) {
+	t.Parallel()
+	req, backend := newArtMockEnv(t)
+	testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+	})
+
+	ctx := context.Background()
+	roleName := "test_cas_role"
+	rawPt := `[{"repo": {"repositories": ["ANY"], "operations": ["read"]}}]`
+	version := func() int {
+		role, err := getRoleEntry(ctx, req.Storage, roleName)
+		require.NoError(t, err)
+		require.NotNil(t, role)
+		return role.Version
+	}
+	assertRejected := func(resp *logical.Response, err error, msg string) {
+		t.Helper()
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Error().Error(), msg)
+	}
+
+	resp, err := testRoleCreate(req, backend, t, roleName, map[string]interface{}{
+		"permission_targets": rawPt,
+		"cas":                0,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	assert.Equal(t, 1, resp.Data["version"])
+
+	resp, err = testRoleCreate(req, backend, t, roleName, map[string]interface{}{
+		"permission_targets": rawPt,
+		"cas":                0,
+	})
+	assertRejected(resp, err, "already exists at version 1")
+
+	mustRoleUpdate(req, backend, t, roleName, map[string]interface{}{
+		"permission_targets": rawPt,
+		"token_ttl":          "300s",
+		"cas":                1,
+	})
+	assert.Equal(t, 2, version())
+
+	resp, err = testRoleUpdate(req, backend, t, roleName, map[string]interface{}{
+		"permission_targets": rawPt,
+		"token_ttl":          "600s",
+		"cas":                1,
+	})
+	assertRejected(resp, err, "did not match the current version 2")
+	assert.Equal(t, 2, version(), "a stale write should not save the role")
+
+	resp, err = testRolePatch(req, backend, t, roleName, map[string]interface{}{
+		"token_ttl": "600s",
+		"cas":       1,
+	})
+	assertRejected(resp, err, "did not match the current version 2")
+
+	resp, err = testRolePatch(req, backend, t, roleName, map[string]interface{}{
+		"token_ttl": "600s",
+		"cas":       2,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	assert.Equal(t, 3, version())
+
+	req.Operation = logical.DeleteOperation
+	req.Path = fmt.Sprintf("roles/%s", roleName)
+	req.Data = map[string]interface{}{"cas": 2}
+	resp, err = backend.HandleRequest(ctx, req)
+	assertRejected(resp, err, "did not match the current version 3")
+
+	// versions keep increasing across deletions
+	mustRoleDelete(req, backend, t, roleName)
+	resp, err = testRoleCreate(req, backend, t, roleName, map[string]interface{}{
+		"permission_targets": rawPt,
+		"cas":                3,
+	})
+	assertRejected(resp, err, "the role does not exist")
+	mustRoleCreate(req, backend, t, roleName, map[string]interface{}{
+		"permission_targets": rawPt,
+		"cas":                0,
+	})
+	assert.Equal(t, 4, version())
+}
+
+func TestPathRoleConcurrentWrites(t *testing.T) {
+	t.Parallel()
+	req, backend := newArtMockEnv(t)
+	testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+		"base_url":              "https://example.jfrog.io/example",
+		"bearer_token":          "mybearertoken",
+		"max_parallel_requests": 4,
+	})
+	client := getMockClient(t, backend)
+	client.requestDelay = 5 * time.Millisecond
+
+	roleName := "test_concurrent_role"
+	writers := 5
+	var wg sync.WaitGroup
+	for idx := 0; idx < writers; idx++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			resp, err := backend.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      fmt.Sprintf("roles/%s", roleName),
+				Storage:   req.Storage,
+				Data: map[string]interface{}{
+					"permission_targets": fmt.Sprintf(`[{"repo": {"repositories": ["repo-%d"], "operations": ["read"]}}]`, idx),
+				},
+			})
+			assert.NoError(t, err)
+			assert.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		}(idx)
+	}
+	wg.Wait()
+
+	// every write saw the previous one: one version and one revision per write, and
+	// Artifactory only holds the permission target of the last write
+	role, err := getRoleEntry(context.Background(), req.Storage, roleName)
+	require.NoError(t, err)
+	assert.Equal(t, writers, role.Version)
+	require.Len(t, role.PermissionTargetNames, 1)
+	assert.Equal(t, map[string]bool{role.PermissionTargetNames[0]: true}, client.permissionTargets)
+	versions, err := listRoleRevisionVersions(context.Background(), req.Storage, roleName)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, versions)
 }
 
 // assertPermissionTarget inspects the actual PermissionTarget in Artifactory against the one in vault role.
 func assertPermissionTarget(t *testing.T, ac artifactory.ArtifactoryServicesManager, role *RoleStorageEntry, permissionTargetIndex int) {
 	t.Helper()
-	ptName := permissionTargetName(role.Name, permissionTargetIndex)
+	ptName := role.PermissionTargetNames[permissionTargetIndex]
 	expected := role.PermissionTargets[permissionTargetIndex]
 	actual, err := ac.GetPermissionTarget(ptName)
 	require.NoError(t, err, "Error retrieving permission target from Artifactory")
@@ -567,9 +1098,8 @@ func assertPermissionTarget(t *testing.T, ac artifactory.ArtifactoryServicesMana
 	assert.ElementsMatch(t, expected.Repo.Operations, actualGroupOperations)
 }
 
-func assertPermissionTargetDeleted(t *testing.T, ac artifactory.ArtifactoryServicesManager, role *RoleStorageEntry, permissionTargetIndex int) {
+func assertPermissionTargetDeleted(t *testing.T, ac artifactory.ArtifactoryServicesManager, ptName string) {
 	t.Helper()
-	ptName := permissionTargetName(role.Name, permissionTargetIndex)
 	actual, err := ac.GetPermissionTarget(ptName)
 	assert.Nil(t, actual)
 	assert.NoError(t, err)
@@ -623,6 +1153,16 @@ func mustRoleUpdate(req *logical.Request, b logical.Backend, t *testing.T, roleN
 	require.False(t, resp.IsError())
 }
 
+func testRolePatch(req *logical.Request, b logical.Backend, t *testing.T, roleName string, data map[string]interface{}) (*logical.Response, error) {
+	t.Helper()
+	req.Operation = logical.PatchOperation
+	req.Path = fmt.Sprintf("roles/%s", roleName)
+	req.Data = data
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	return resp, err
+}
+
 func testRoleRead(req *logical.Request, b logical.Backend, t *testing.T, roleName string) (*logical.Response, error) {
 	t.Helper()
 	data := map[string]interface{}{
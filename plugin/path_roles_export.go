@@ -0,0 +1,252 @@
+// Copyright  2024 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	exportPath = "export"
+	importPath = "import"
+
+	exportFormatJSON = "json"
+	exportFormatYAML = "yaml"
+)
+
+var exportRoleSchema = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "The name of the role to export",
+	},
+	"format": {
+		Type:        framework.TypeString,
+		Description: `Format of the exported document, "json" (default) or "yaml"`,
+		Default:     exportFormatJSON,
+	},
+}
+
+var importRolesSchema = map[string]*framework.FieldSchema{
+	"document": {
+		Type:        framework.TypeString,
+		Description: "JSON or YAML document previously exported from a mount",
+	},
+	"dry_run": {
+		Type:        framework.TypeBool,
+		Description: "If true, only compute and return the changes without applying them",
+		Default:     false,
+	},
+}
+
+// newRoleManifestEntry converts a role into its portable representation. Inherited
+// permission targets and groups are left to the role templates listed in inherits.
+func newRoleManifestEntry(role *RoleStorageEntry) (RoleManifestEntry, error) {
+	pts, err := role.ownPermissionTargets()
+	if err != nil {
+		return RoleManifestEntry{}, err
+	}
+
+	// inherited and default TTLs are left out, so they follow the templates on import
+	entry := RoleManifestEntry{
+		Groups:                role.Groups,
+		Inherits:              role.Inherits,
+		DockerRegistries:      role.DockerRegistries,
+		MaxActiveTokens:       role.MaxActiveTokens,
+		MaxIssueRate:          role.MaxIssueRate,
+		IncludeReferenceToken: role.IncludeReferenceToken,
+		PermissionTargets:     pts,
+	}
+	if role.ExplicitTokenTTL > 0 {
+		entry.TokenTTL = int64(role.ExplicitTokenTTL / time.Second)
+	}
+	if role.ExplicitMaxTTL > 0 {
+		entry.MaxTTL = int64(role.ExplicitMaxTTL / time.Second)
+	}
+	return entry, nil
+}
+
+// encodeRoleManifest serializes a manifest as JSON or YAML, using the JSON field names for both
+func encodeRoleManifest(manifest *RoleManifest, format string) (string, error) {
+	doc, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case exportFormatJSON:
+		return string(doc), nil
+	case exportFormatYAML:
+		var v interface{}
+		if err := json.Unmarshal(doc, &v); err != nil {
+			return "", err
+		}
+		out, err := yaml.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(out), nil
+	default:
+		return "", fmt.Errorf("export format '%s' is not supported", format)
+	}
+}
+
+func (backend *ArtifactoryBackend) exportResponse(manifest *RoleManifest, format string) (*logical.Response, error) {
+	doc, err := encodeRoleManifest(manifest, format)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"document": doc,
+		},
+	}, nil
+}
+
+// pathRoleExport exports a single role
+func (backend *ArtifactoryBackend) pathRoleExport(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("name").(string)
+	role, err := getRoleEntry(ctx, req.Storage, roleName)
+	if err != nil {
+		return logical.ErrorResponse("Error reading role"), err
+	}
+	if role == nil {
+		return nil, nil
+	}
+
+	entry, err := newRoleManifestEntry(role)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &RoleManifest{
+		Version: roleManifestVersion,
+		Roles:   map[string]RoleManifestEntry{role.Name: entry},
+	}
+	return backend.exportResponse(manifest, data.Get("format").(string))
+}
+
+// pathRolesExport exports every role of the mount
+func (backend *ArtifactoryBackend) pathRolesExport(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleNames, err := backend.listRoleEntries(ctx, req.Storage)
+	if err != nil {
+		return logical.ErrorResponse("Error listing roles"), err
+	}
+
+	manifest := &RoleManifest{
+		Version: roleManifestVersion,
+		Roles:   make(map[string]RoleManifestEntry, len(roleNames)),
+	}
+	for _, roleName := range roleNames {
+		role, err := getRoleEntry(ctx, req.Storage, roleName)
+		if err != nil {
+			return logical.ErrorResponse("Error reading role"), err
+		}
+		if role == nil {
+			continue
+		}
+		entry, err := newRoleManifestEntry(role)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Roles[role.Name] = entry
+	}
+
+	return backend.exportResponse(manifest, data.Get("format").(string))
+}
+
+// pathRolesImport creates or updates the roles of an exported document. Roles not
+// part of the document are left untouched.
+func (backend *ArtifactoryBackend) pathRolesImport(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	rawDocument := data.Get("document").(string)
+	if strings.TrimSpace(rawDocument) == "" {
+		return logical.ErrorResponse("document is required"), nil
+	}
+
+	manifest, err := parseRoleManifest(rawDocument)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	return backend.applyRoleManifest(ctx, req, manifest, data.Get("dry_run").(bool), false, true)
+}
+
+func pathRolesExport(backend *ArtifactoryBackend) []*framework.Path {
+	exportSchema := map[string]*framework.FieldSchema{
+		"format": exportRoleSchema["format"],
+	}
+
+	paths := []*framework.Path{
+		{
+			Pattern: fmt.Sprintf("%s/%s/export", rolesPrefix, framework.GenericNameRegex("name")),
+			Fields:  exportRoleSchema,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: backend.pathRoleExport,
+			},
+			HelpSynopsis:    pathRolesExportHelpSyn,
+			HelpDescription: pathRolesExportHelpDesc,
+		},
+		{
+			Pattern: exportPath,
+			Fields:  exportSchema,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: backend.pathRolesExport,
+			},
+			HelpSynopsis:    pathRolesExportHelpSyn,
+			HelpDescription: pathRolesExportHelpDesc,
+		},
+		{
+			Pattern: importPath,
+			Fields:  importRolesSchema,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: forwardedOperation(backend.pathRolesImport),
+			},
+			HelpSynopsis:    pathRolesImportHelpSyn,
+			HelpDescription: pathRolesImportHelpDesc,
+		},
+	}
+
+	return paths
+}
+
+const pathRolesExportHelpSyn = `Export one or all roles as a portable JSON or YAML document.`
+const pathRolesExportHelpDesc = `
+"roles/<name>/export" exports a single role and "export" exports every role of
+the mount. The versioned document contains each role's TTLs, static groups,
+docker registries and permission targets, and can be imported into another
+mount or cluster through the "import" endpoint.
+
+  $ vault read -field=document artifactory/export format=yaml > roles.yaml
+`
+
+const pathRolesImportHelpSyn = `Import roles from an exported document.`
+const pathRolesImportHelpDesc = `
+This path creates or updates the roles of a document produced by the "export"
+endpoints. Roles of the mount that are not part of the document are left
+untouched. Groups and permission targets are created in Artifactory for the
+target mount, with role IDs generated for new roles.
+
+  $ vault write artifactory-new/import document=@roles.yaml
+
+With "dry_run=true" the changes are computed and returned without touching
+Artifactory or storage.
+`
@@ -0,0 +1,172 @@
+// Copyright  2024 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathRolesExportImport(t *testing.T) {
+	t.Parallel()
+	conf := map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+		"max_ttl":      "3600s",
+	}
+
+	srcReq, src := newArtMockEnv(t)
+	testConfigUpdate(t, src, srcReq.Storage, conf)
+	mustRoleCreate(srcReq, src, t, "ci_role", map[string]interface{}{
+		"permission_targets": `[{"name": "reader", "repo": {"repositories": ["ANY"], "operations": ["read"]}}]`,
+		"groups":             []string{"g1"},
+		"token_ttl":          "300s",
+	})
+	mustRoleCreate(srcReq, src, t, "static_role", map[string]interface{}{
+		"groups": []string{"g2"},
+	})
+
+	for _, format := range []string{"json", "yaml"} {
+		format := format // capture range var
+		t.Run(format, func(t *testing.T) {
+			t.Parallel()
+
+			resp := testExport(t, srcReq, src, "export", format)
+			document := resp.Data["document"].(string)
+
+			manifest, err := parseRoleManifest(document)
+			require.NoError(t, err)
+			assert.Equal(t, roleManifestVersion, manifest.Version)
+			assert.Len(t, manifest.Roles, 2)
+
+			dstReq, dst := newArtMockEnv(t)
+			testConfigUpdate(t, dst, dstReq.Storage, conf)
+			mustRoleCreate(dstReq, dst, t, "other_role", map[string]interface{}{
+				"groups": []string{"g3"},
+			})
+
+			dstReq.Operation = logical.UpdateOperation
+			dstReq.Path = "import"
+			dstReq.Data = map[string]interface{}{"document": document}
+			resp, err = dst.HandleRequest(context.Background(), dstReq)
+			require.NoError(t, err)
+			require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+
+			ctx := context.Background()
+			roles, err := dst.(*ArtifactoryBackend).listRoleEntries(ctx, dstReq.Storage)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"ci_role", "static_role", "other_role"}, roles, "import should not delete other roles")
+
+			role, err := getRoleEntry(ctx, dstReq.Storage, "ci_role")
+			require.NoError(t, err)
+			assert.Equal(t, 300*time.Second, role.TokenTTL)
+			assert.Equal(t, []string{"g1"}, role.Groups)
+			assert.Equal(t, roleID("ci_role"), role.RoleID)
+			assert.Equal(t, []string{"vault-plugin.pt-reader.ci_role"}, role.PermissionTargetNames)
+		})
+	}
+
+	t.Run("single_role", func(t *testing.T) {
+		t.Parallel()
+		resp := testExport(t, srcReq, src, "roles/static_role/export", "json")
+		manifest, err := parseRoleManifest(resp.Data["document"].(string))
+		require.NoError(t, err)
+		require.Contains(t, manifest.Roles, "static_role")
+		assert.Len(t, manifest.Roles, 1)
+	})
+
+	t.Run("unsupported_version", func(t *testing.T) {
+		t.Parallel()
+		_, err := parseRoleManifest(`{"version": 99, "roles": {}}`)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "manifest version 99 is not supported")
+	})
+}
+
+func TestPathRolesExportSyncInherits(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, b := newArtMockEnv(t)
+	testConfigUpdate(t, b, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+		"max_ttl":      "3600s",
+	})
+
+	resp, err := testRoleTemplateRequest(req, b, logical.UpdateOperation, "role-templates/caches", map[string]interface{}{
+		"permission_targets": `[{"name": "caches", "repo": {"repositories": ["ANY REMOTE"], "operations": ["read"]}}]`,
+		"groups":             []string{"readers"},
+		"token_ttl":          "120s",
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+
+	mustRoleCreate(req, b, t, "inherit_role", map[string]interface{}{
+		"inherits":           []string{"caches"},
+		"groups":             []string{"g1"},
+		"permission_targets": `[{"name": "deploy", "repo": {"repositories": ["ANY LOCAL"], "operations": ["deployer"]}}]`,
+	})
+	before, err := getRoleEntry(ctx, req.Storage, "inherit_role")
+	require.NoError(t, err)
+
+	document := testExport(t, req, b, "export", "json").Data["document"].(string)
+	manifest, err := parseRoleManifest(document)
+	require.NoError(t, err)
+	entry := manifest.Roles["inherit_role"]
+	assert.Equal(t, []string{"caches"}, entry.Inherits)
+	assert.Equal(t, []string{"g1"}, entry.Groups, "inherited groups should be left to the template")
+	require.Len(t, entry.PermissionTargets, 1, "inherited permission targets should be left to the template")
+	assert.Equal(t, []string{"deployer"}, entry.PermissionTargets[0].Repo.Operations)
+
+	resp, err = testRolesSync(req, b, map[string]interface{}{"manifest": document})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	results := resp.Data["results"].([]map[string]interface{})
+	require.Len(t, results, 1)
+	assert.Equal(t, syncActionUnchanged, results[0]["action"])
+
+	after, err := getRoleEntry(ctx, req.Storage, "inherit_role")
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	// a manifest listing only the role's own permission targets keeps the inherited ones
+	resp, err = testRolesSync(req, b, map[string]interface{}{"manifest": `{"roles": {"inherit_role": {"inherits": ["caches"], "permission_targets": [{"repo": {"repositories": ["ANY LOCAL"], "operations": ["write"]}}]}}}`})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	role, err := getRoleEntry(ctx, req.Storage, "inherit_role")
+	require.NoError(t, err)
+	require.Len(t, role.PermissionTargets, 2)
+	assert.Equal(t, "caches", role.PermissionTargets[0].Name)
+	assert.Equal(t, []string{"readers"}, role.InheritedGroups)
+	assert.Equal(t, 120*time.Second, role.TokenTTL, "the token ttl should default to the inherited one")
+}
+
+func testExport(t *testing.T, req *logical.Request, b logical.Backend, path, format string) *logical.Response {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      path,
+		Data:      map[string]interface{}{"format": format},
+		Storage:   req.Storage,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+	return resp
+}
@@ -0,0 +1,563 @@
+// Copyright  2024 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	rolesSyncPath = "roles-sync"
+
+	// roleManifestVersion is the current version of the manifest and export document format
+	roleManifestVersion = 1
+
+	syncActionCreate    = "create"
+	syncActionUpdate    = "update"
+	syncActionDelete    = "delete"
+	syncActionUnchanged = "unchanged"
+
+	syncStatusPlanned = "planned"
+	syncStatusApplied = "applied"
+	syncStatusFailed  = "failed"
+	syncStatusSkipped = "skipped"
+)
+
+var rolesSyncSchema = map[string]*framework.FieldSchema{
+	"manifest": {
+		Type:        framework.TypeString,
+		Description: "JSON or YAML manifest describing the full set of roles of this mount",
+	},
+	"dry_run": {
+		Type:        framework.TypeBool,
+		Description: "If true, only compute and return the changes without applying them",
+		Default:     false,
+	},
+	"revoke_tokens": {
+		Type:        framework.TypeBool,
+		Description: "Revoke the outstanding tokens of the roles deleted by the sync",
+		Default:     true,
+	},
+}
+
+// roleNameRegex matches the role names the roles/ and token/ paths accept
+var roleNameRegex = regexp.MustCompile("^" + framework.GenericNameRegex("name") + "$")
+
+// RoleManifest is the declarative description of all roles of a mount. It is also the
+// document format of role exports.
+type RoleManifest struct {
+	Version int                          `json:"version,omitempty"`
+	Roles   map[string]RoleManifestEntry `json:"roles"`
+}
+
+// RoleManifestEntry is a single role of a manifest. TTLs accept the same
+// values as the roles/ endpoint, e.g. 600 or "10m".
+type RoleManifestEntry struct {
+	TokenTTL              interface{}        `json:"token_ttl,omitempty"`
+	MaxTTL                interface{}        `json:"max_ttl,omitempty"`
+	Groups                []string           `json:"groups,omitempty"`
+	Inherits              []string           `json:"inherits,omitempty"`
+	DockerRegistries      []string           `json:"docker_registries,omitempty"`
+	MaxActiveTokens       int                `json:"max_active_tokens,omitempty"`
+	MaxIssueRate          int                `json:"max_issue_rate,omitempty"`
+	IncludeReferenceToken bool               `json:"include_reference_token,omitempty"`
+	PermissionTargets     []PermissionTarget `json:"permission_targets,omitempty"`
+}
+
+// roleSyncChange is a computed change of a single role
+type roleSyncChange struct {
+	action  string
+	current *RoleStorageEntry
+	desired *RoleStorageEntry
+}
+
+// parseRoleManifest parses a JSON manifest, falling back to YAML
+func parseRoleManifest(raw string) (*RoleManifest, error) {
+	doc := []byte(raw)
+	if !json.Valid(doc) {
+		var v interface{}
+		if err := yaml.Unmarshal(doc, &v); err != nil {
+			return nil, fmt.Errorf("manifest is neither valid JSON nor YAML - %w", err)
+		}
+		var err error
+		if doc, err = json.Marshal(v); err != nil {
+			return nil, fmt.Errorf("manifest can't be converted to JSON - %w", err)
+		}
+	}
+
+	var manifest RoleManifest
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest - %w", err)
+	}
+	if manifest.Version > roleManifestVersion {
+		return nil, fmt.Errorf("manifest version %d is not supported, expecting at most %d", manifest.Version, roleManifestVersion)
+	}
+
+	return &manifest, nil
+}
+
+// toRole converts a manifest entry into a role, applying the same defaults as the roles/ endpoint,
+// expanding the operation presets and inheriting from the role templates in entry.Inherits
+func (entry RoleManifestEntry) toRole(name string, presets map[string][]string, templates []*RoleTemplateEntry) (*RoleStorageEntry, error) {
+	role := &RoleStorageEntry{
+		Name:                  name,
+		RoleID:                roleID(name),
+		Groups:                entry.Groups,
+		Inherits:              entry.Inherits,
+		DockerRegistries:      entry.DockerRegistries,
+		MaxActiveTokens:       entry.MaxActiveTokens,
+		MaxIssueRate:          entry.MaxIssueRate,
+		IncludeReferenceToken: entry.IncludeReferenceToken,
+		InheritedGroups:       inheritGroups(templates, entry.Groups),
+	}
+
+	var err *multierror.Error
+
+	role.ExplicitTokenTTL, err = parseManifestTTL(entry.TokenTTL, "token_ttl", err)
+	role.ExplicitMaxTTL, err = parseManifestTTL(entry.MaxTTL, "max_ttl", err)
+	role.resolveTTLs(templates)
+
+	if len(role.Groups) == 0 && len(role.Inherits) == 0 && len(entry.PermissionTargets) == 0 {
+		err = multierror.Append(err, errors.New("permission targets and/or groups are required"))
+	}
+
+	// the role's own permission targets as supplied, before their operations are normalized
+	ownPts := slices.Clone(entry.PermissionTargets)
+	if len(ownPts) > 0 {
+		raw, e := json.Marshal(ownPts)
+		if e != nil {
+			err = multierror.Append(err, e)
+		}
+		role.RawPermissionTargets = string(raw)
+	}
+
+	if e := normalizePermissionTargets(ownPts, presets); e != nil {
+		err = multierror.Append(err, e)
+	} else {
+		for _, pt := range ownPts {
+			if e := pt.assertValid(); e != nil {
+				err = multierror.Append(err, e)
+			}
+		}
+	}
+	if e := validatePermissionTargetIdentities(ownPts); e != nil {
+		err = multierror.Append(err, e)
+	}
+	if e := validateDockerRegistries(role.DockerRegistries); e != nil {
+		err = multierror.Append(err, e)
+	}
+
+	pts, e := inheritPermissionTargets(templates, ownPts)
+	if e != nil {
+		err = multierror.Append(err, e)
+	}
+	role.PermissionTargets = pts
+
+	return role, err.ErrorOrNil()
+}
+
+// parseManifestTTL parses an explicit manifest TTL, zero when unset
+func parseManifestTTL(in interface{}, field string, merr *multierror.Error) (time.Duration, *multierror.Error) {
+	if in == nil {
+		return 0, merr
+	}
+	ttl, err := parseutil.ParseDurationSecond(in)
+	if err != nil {
+		return 0, multierror.Append(merr, fmt.Errorf("invalid %s - %w", field, err))
+	}
+	return max(0, ttl), merr
+}
+
+// sameRole reports whether applying desired over current would be a no-op
+func sameRole(current, desired *RoleStorageEntry) bool {
+	currentPts, _ := json.Marshal(current.PermissionTargets)
+	desiredPts, _ := json.Marshal(desired.PermissionTargets)
+
+	return sameOwnPermissionTargets(current, desired) &&
+		current.TokenTTL == desired.TokenTTL &&
+		current.MaxTTL == desired.MaxTTL &&
+		current.ExplicitTokenTTL == desired.ExplicitTokenTTL &&
+		current.ExplicitMaxTTL == desired.ExplicitMaxTTL &&
+		equalStrings(current.Groups, desired.Groups) &&
+		equalStrings(current.Inherits, desired.Inherits) &&
+		equalStrings(current.InheritedGroups, desired.InheritedGroups) &&
+		equalStrings(current.DockerRegistries, desired.DockerRegistries) &&
+		current.MaxActiveTokens == desired.MaxActiveTokens &&
+		current.MaxIssueRate == desired.MaxIssueRate &&
+		current.IncludeReferenceToken == desired.IncludeReferenceToken &&
+		bytes.Equal(currentPts, desiredPts)
+}
+
+// sameOwnPermissionTargets compares the role's own permission targets as supplied, so that
+// a manifest only changing their presets or aliases still updates the stored raw form
+func sameOwnPermissionTargets(current, desired *RoleStorageEntry) bool {
+	currentPts, err := current.ownPermissionTargets()
+	if err != nil {
+		return false
+	}
+	desiredPts, err := desired.ownPermissionTargets()
+	if err != nil {
+		return false
+	}
+	if len(currentPts) == 0 && len(desiredPts) == 0 {
+		return true
+	}
+	currentRaw, _ := json.Marshal(currentPts)
+	desiredRaw, _ := json.Marshal(desiredPts)
+	return bytes.Equal(currentRaw, desiredRaw)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func (backend *ArtifactoryBackend) pathRolesSync(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	rawManifest := data.Get("manifest").(string)
+	if strings.TrimSpace(rawManifest) == "" {
+		return logical.ErrorResponse("manifest is required"), nil
+	}
+	dryRun := data.Get("dry_run").(bool)
+	revokeTokens := data.Get("revoke_tokens").(bool)
+
+	manifest, err := parseRoleManifest(rawManifest)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	return backend.applyRoleManifest(ctx, req, manifest, dryRun, true, revokeTokens)
+}
+
+// applyRoleManifest creates and updates the roles of the manifest. With prune, roles
+// missing from the manifest are deleted as well, revoking their tokens with revokeTokens.
+func (backend *ArtifactoryBackend) applyRoleManifest(ctx context.Context, req *logical.Request, manifest *RoleManifest, dryRun, prune, revokeTokens bool) (*logical.Response, error) {
+	config, err := backend.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain artifactory config - %s", err.Error())
+	}
+	if config == nil {
+		return nil, fmt.Errorf("artifactory backend configuration has not been set up")
+	}
+
+	// validate the whole manifest up front so that a broken manifest doesn't get partially applied
+	var merr *multierror.Error
+	desiredRoles := make(map[string]*RoleStorageEntry, len(manifest.Roles))
+	for name, entry := range manifest.Roles {
+		if !roleNameRegex.MatchString(name) {
+			merr = multierror.Append(merr, fmt.Errorf("role name '%s' is not a valid role name", name))
+			continue
+		}
+		templates, err := getRoleTemplateEntries(ctx, req.Storage, entry.Inherits)
+		if err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("role '%s': failed to resolve role templates - %w", name, err))
+			continue
+		}
+		role, err := entry.toRole(name, config.operationPresets(), templates)
+		if err == nil {
+			err = role.validateTTLs(config)
+		}
+		if err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("role '%s': %w", name, err))
+			continue
+		}
+		desiredRoles[name] = role
+	}
+	if err := merr.ErrorOrNil(); err != nil {
+		return logical.ErrorResponse("Failed to validate manifest - " + err.Error()), nil
+	}
+
+	// check the Artifactory references of every role before anything gets deleted,
+	// so that a manifest pointing at missing repositories or groups is rejected whole
+	names := make([]string, 0, len(desiredRoles))
+	for name := range desiredRoles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	referenceErrs := make(map[string]error)
+	for _, name := range names {
+		role := desiredRoles[name]
+		if err := backend.validateArtifactoryReferences(ctx, req.Storage, role.Groups, role.PermissionTargets); err != nil {
+			referenceErrs[name] = err
+			merr = multierror.Append(merr, fmt.Errorf("role '%s': %w", name, err))
+		}
+	}
+	if err := merr.ErrorOrNil(); err != nil && !dryRun {
+		return logical.ErrorResponse("Failed to validate manifest against Artifactory - " + err.Error()), nil
+	}
+
+	changes, err := backend.computeRoleSyncChanges(ctx, req.Storage, desiredRoles, prune)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	results := make([]map[string]interface{}, 0, len(changes))
+	failed := false
+	for _, change := range changes {
+		name := change.desired.Name
+		result := map[string]interface{}{
+			"name":   name,
+			"action": change.action,
+			"status": syncStatusPlanned,
+		}
+		results = append(results, result)
+
+		if err, ok := referenceErrs[name]; ok && change.action != syncActionDelete {
+			result["status"] = syncStatusFailed
+			result["error"] = err.Error()
+			warnings = append(warnings, fmt.Sprintf("role '%s': %s", name, err))
+			continue
+		}
+
+		switch {
+		case change.action == syncActionUnchanged:
+			result["status"] = syncStatusSkipped
+			continue
+		case dryRun:
+			continue
+		case failed:
+			// stop applying further changes once one failed, to keep the
+			// deletions-before-upserts ordering meaningful
+			result["status"] = syncStatusSkipped
+			continue
+		}
+
+		roleWarnings, err := backend.applyRoleSyncChange(ctx, req, change, revokeTokens)
+		if err != nil {
+			failed = true
+			result["status"] = syncStatusFailed
+			result["error"] = err.Error()
+			continue
+		}
+		result["status"] = syncStatusApplied
+		if len(roleWarnings) > 0 {
+			result["warnings"] = roleWarnings
+			for _, w := range roleWarnings {
+				warnings = append(warnings, fmt.Sprintf("role '%s': %s", name, w))
+			}
+		}
+	}
+
+	if failed {
+		warnings = append(warnings, "sync stopped after a failed role, remaining changes were skipped")
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"dry_run": dryRun,
+			"results": results,
+		},
+		Warnings: warnings,
+	}, nil
+}
+
+// computeRoleSyncChanges compares desired roles against storage. Changes are
+// ordered deletions first, then updates, then creations, each sorted by role name.
+// Roles missing from desiredRoles are only deleted with prune.
+func (backend *ArtifactoryBackend) computeRoleSyncChanges(ctx context.Context, storage logical.Storage, desiredRoles map[string]*RoleStorageEntry, prune bool) ([]roleSyncChange, error) {
+	var existingNames []string
+	if prune {
+		var err error
+		if existingNames, err = backend.listRoleEntries(ctx, storage); err != nil {
+			return nil, err
+		}
+	}
+
+	var deletes, updates, creates, unchanged []roleSyncChange
+	for _, name := range existingNames {
+		if _, ok := desiredRoles[name]; ok {
+			continue
+		}
+		current, err := getRoleEntry(ctx, storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			continue
+		}
+		deletes = append(deletes, roleSyncChange{action: syncActionDelete, current: current, desired: current})
+	}
+
+	for name, desired := range desiredRoles {
+		current, err := getRoleEntry(ctx, storage, name)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case current == nil:
+			creates = append(creates, roleSyncChange{action: syncActionCreate, desired: desired})
+		case sameRole(current, desired):
+			unchanged = append(unchanged, roleSyncChange{action: syncActionUnchanged, current: current, desired: desired})
+		default:
+			desired.RoleID = current.RoleID
+			updates = append(updates, roleSyncChange{action: syncActionUpdate, current: current, desired: desired})
+		}
+	}
+
+	var changes []roleSyncChange
+	for _, group := range [][]roleSyncChange{deletes, updates, creates, unchanged} {
+		sort.Slice(group, func(i, j int) bool { return group[i].desired.Name < group[j].desired.Name })
+		changes = append(changes, group...)
+	}
+
+	return changes, nil
+}
+
+// applyRoleSyncChange applies a single computed change to Artifactory and storage. The
+// tokens of a deleted role are revoked with revokeTokens.
+func (backend *ArtifactoryBackend) applyRoleSyncChange(ctx context.Context, req *logical.Request, change roleSyncChange, revokeTokens bool) ([]string, error) {
+	lock := backend.roleLock(change.desired.Name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// the change was computed without the lock, don't overwrite a role written since
+	current, err := getRoleEntry(ctx, req.Storage, change.desired.Name)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case change.current == nil && current != nil:
+		return nil, fmt.Errorf("role was created at version %d after the sync was planned, run the sync again", current.Version)
+	case change.current != nil && current == nil:
+		return nil, fmt.Errorf("role was deleted after the sync was planned, run the sync again")
+	case change.current != nil && current.Version != change.current.Version:
+		return nil, fmt.Errorf("role changed from version %d to %d after the sync was planned, run the sync again", change.current.Version, current.Version)
+	}
+
+	if change.action == syncActionDelete {
+		role := change.current
+		if err := backend.deleteRoleEntry(ctx, req.Storage, role.Name); err != nil {
+			return nil, err
+		}
+		var warnings []string
+		if revokeTokens {
+			if _, err := backend.revokeRoleTokens(ctx, req.Storage, role.Name, nil); err != nil {
+				warnings = append(warnings, err.Error())
+			}
+			if backend.tokensIssuedElsewhere() {
+				warnings = append(warnings, remoteTokensWarning)
+			}
+		}
+		if err := backend.tryDeleteRoleResources(ctx, req, role, role.permissionTargetNames(), true); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+		return warnings, nil
+	}
+
+	role := change.desired
+	if role.IncludeReferenceToken && (change.current == nil || !change.current.IncludeReferenceToken) {
+		if err := backend.validateReferenceTokenSupport(ctx, req.Storage); err != nil {
+			return nil, err
+		}
+	}
+
+	var oldPts []PermissionTarget
+	var oldNames []string
+	if change.current != nil {
+		oldPts = change.current.PermissionTargets
+		oldNames = change.current.permissionTargetNames()
+		role.ObjectPrefix = change.current.ObjectPrefix
+		role.Version = change.current.Version
+	}
+
+	if len(role.PermissionTargets) == 0 {
+		if err := role.save(ctx, req); err != nil {
+			return nil, err
+		}
+		if len(oldNames) > 0 {
+			if err := backend.tryDeleteRoleResources(ctx, req, role, oldNames, true); err != nil {
+				return []string{err.Error()}, nil
+			}
+		}
+		return nil, nil
+	}
+
+	pts := role.PermissionTargets
+	role.PermissionTargets = oldPts
+	if change.current != nil {
+		role.PermissionTargetNames = change.current.PermissionTargetNames
+	}
+	return backend.saveRoleWithNewPermissionTargets(ctx, req, role, pts)
+}
+
+func pathRolesSync(backend *ArtifactoryBackend) []*framework.Path {
+	paths := []*framework.Path{
+		{
+			Pattern: rolesSyncPath,
+			Fields:  rolesSyncSchema,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: forwardedOperation(backend.pathRolesSync),
+			},
+			HelpSynopsis:    pathRolesSyncHelpSyn,
+			HelpDescription: pathRolesSyncHelpDesc,
+		},
+	}
+
+	return paths
+}
+
+const pathRolesSyncHelpSyn = `Declaratively synchronize all roles of this mount from a manifest.`
+const pathRolesSyncHelpDesc = `
+This path accepts a manifest describing every role of the mount as JSON or YAML.
+Roles missing from storage are created, differing roles are updated and roles
+absent from the manifest are deleted along with their Artifactory group and
+permission targets. The tokens of deleted roles are revoked unless
+"revoke_tokens=false" is given, as when deleting a role.
+
+roles:
+  ci-role:
+    token_ttl: 10m
+    max_ttl: 1h
+    groups: ["group1"]
+    inherits: ["remote-caches"]
+    permission_targets:
+      - repo:
+          include_patterns: ["/mytest/**"]
+          repositories: ["docker-local"]
+          operations: ["read"]
+
+Roles inherit from the role templates listed in "inherits" as with the roles/
+endpoint. The templates must already exist.
+
+The repositories and groups of every role are checked against Artifactory
+before any change is applied; a manifest referencing missing ones is rejected
+as a whole. Changes are applied in the order deletions, updates, creations.
+Applying stops at the first failed role; the remaining roles are reported as
+skipped. A role written by another request after the changes were computed
+fails the sync rather than being overwritten.
+
+With "dry_run=true" the changes are computed and returned without touching
+Artifactory or storage. Roles with missing references are reported as failed.
+`
@@ -0,0 +1,386 @@
+// Copyright  2024 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRoleManifest(t *testing.T) {
+	t.Parallel()
+
+	t.Run("json", func(t *testing.T) {
+		t.Parallel()
+		m, err := parseRoleManifest(`{"roles": {"ci-role": {"token_ttl": 600, "groups": ["g1"]}}}`)
+		require.NoError(t, err)
+		require.Contains(t, m.Roles, "ci-role")
+		assert.Equal(t, []string{"g1"}, m.Roles["ci-role"].Groups)
+	})
+
+	t.Run("yaml", func(t *testing.T) {
+		t.Parallel()
+		m, err := parseRoleManifest(`
+roles:
+  ci-role:
+    token_ttl: 10m
+    permission_targets:
+      - repo:
+          repositories: ["docker-local"]
+          operations: ["read"]
+`)
+		require.NoError(t, err)
+		role, err := m.Roles["ci-role"].toRole("ci-role", builtinOperationPresets, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 10*time.Minute, role.TokenTTL)
+		assert.Equal(t, time.Hour, role.MaxTTL)
+		require.Len(t, role.PermissionTargets, 1)
+		assert.Equal(t, []string{"docker-local"}, role.PermissionTargets[0].Repo.Repositories)
+	})
+
+	t.Run("operation_presets", func(t *testing.T) {
+		t.Parallel()
+		m, err := parseRoleManifest(`{"roles": {"ci-role": {"permission_targets": [{"repo": {"repositories": ["libs"], "operations": ["deployer"]}}]}}}`)
+		require.NoError(t, err)
+		role, err := m.Roles["ci-role"].toRole("ci-role", builtinOperationPresets, nil)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"read", "annotate", "write"}, role.PermissionTargets[0].Repo.Operations)
+		assert.Contains(t, role.RawPermissionTargets, `"deployer"`)
+
+		_, err = m.Roles["ci-role"].toRole("ci-role", nil, nil)
+		require.Error(t, err)
+	})
+
+	t.Run("unknown_field", func(t *testing.T) {
+		t.Parallel()
+		_, err := parseRoleManifest(`{"roles": {"ci-role": {"group": ["g1"]}}}`)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown field")
+	})
+}
+
+func TestPathRolesSync(t *testing.T) {
+	t.Parallel()
+	req, backend := newArtMockEnv(t)
+	testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+		"max_ttl":      "3600s",
+	})
+
+	mustRoleCreate(req, backend, t, "keep_role", map[string]interface{}{
+		"groups": []string{"g1"},
+	})
+	mustRoleCreate(req, backend, t, "update_role", map[string]interface{}{
+		"groups": []string{"g1"},
+	})
+	mustRoleCreate(req, backend, t, "delete_role", map[string]interface{}{
+		"groups": []string{"g1"},
+	})
+
+	manifest := `
+roles:
+  keep_role:
+    groups: ["g1"]
+  update_role:
+    groups: ["g1", "g2"]
+  new_role:
+    permission_targets:
+      - repo:
+          repositories: ["ANY"]
+          operations: ["read"]
+`
+
+	t.Run("invalid_manifest", func(t *testing.T) {
+		resp, err := testRolesSync(req, backend, map[string]interface{}{
+			"manifest": `{"roles": {"bad_role1": {}, "bad_role2": {"token_ttl": "2h", "groups": ["g1"]}}}`,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		actualErr := resp.Data["error"].(string)
+		assert.Contains(t, actualErr, "permission targets and/or groups are required")
+		assert.Contains(t, actualErr, "role token ttl is greater than role max ttl")
+	})
+
+	t.Run("invalid_role_names", func(t *testing.T) {
+		resp, err := testRolesSync(req, backend, map[string]interface{}{
+			"manifest": `{"roles": {"team/ci": {"groups": ["g1"]}, "bad name!": {"groups": ["g1"]}, "keep_role": {"groups": ["g1"]}}}`,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		actualErr := resp.Data["error"].(string)
+		assert.Contains(t, actualErr, "role name 'team/ci' is not a valid role name")
+		assert.Contains(t, actualErr, "role name 'bad name!' is not a valid role name")
+
+		roles, err := backend.(*ArtifactoryBackend).listRoleEntries(context.Background(), req.Storage)
+		require.NoError(t, err)
+		assert.NotContains(t, roles, "team/")
+		assert.NotContains(t, roles, "bad name!")
+	})
+
+	t.Run("dry_run", func(t *testing.T) {
+		resp, err := testRolesSync(req, backend, map[string]interface{}{
+			"manifest": manifest,
+			"dry_run":  true,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		assert.Equal(t, []map[string]interface{}{
+			{"name": "delete_role", "action": "delete", "status": "planned"},
+			{"name": "update_role", "action": "update", "status": "planned"},
+			{"name": "new_role", "action": "create", "status": "planned"},
+			{"name": "keep_role", "action": "unchanged", "status": "skipped"},
+		}, resp.Data["results"])
+
+		roles, err := backend.(*ArtifactoryBackend).listRoleEntries(context.Background(), req.Storage)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"keep_role", "update_role", "delete_role"}, roles)
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		resp, err := testRolesSync(req, backend, map[string]interface{}{
+			"manifest": manifest,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		for _, result := range resp.Data["results"].([]map[string]interface{}) {
+			if result["action"] != "unchanged" {
+				assert.Equal(t, "applied", result["status"], "role %s", result["name"])
+			}
+		}
+
+		ctx := context.Background()
+		roles, err := backend.(*ArtifactoryBackend).listRoleEntries(ctx, req.Storage)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"keep_role", "update_role", "new_role"}, roles)
+
+		role, err := getRoleEntry(ctx, req.Storage, "update_role")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"g1", "g2"}, role.Groups)
+
+		role, err = getRoleEntry(ctx, req.Storage, "new_role")
+		require.NoError(t, err)
+		assert.Len(t, role.PermissionTargets, 1)
+		assert.NotEmpty(t, role.RawPermissionTargets)
+
+		// re-applying the same manifest is a no-op
+		resp, err = testRolesSync(req, backend, map[string]interface{}{
+			"manifest": manifest,
+		})
+		require.NoError(t, err)
+		for _, result := range resp.Data["results"].([]map[string]interface{}) {
+			assert.Equal(t, "unchanged", result["action"], "role %s", result["name"])
+		}
+	})
+}
+
+func TestPathRolesSyncRawPermissionTargets(t *testing.T) {
+	t.Parallel()
+	req, backend := newArtMockEnv(t)
+	testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+	})
+	mustRoleCreate(req, backend, t, "preset_role", map[string]interface{}{
+		"permission_targets": `[ {"repo": {"repositories": ["ANY"], "operations": ["reader"]}} ]`,
+	})
+
+	sync := func(t *testing.T, operation string) []map[string]interface{} {
+		resp, err := testRolesSync(req, backend, map[string]interface{}{
+			"manifest": fmt.Sprintf(`{"roles": {"preset_role": {"permission_targets": [{"repo": {"repositories": ["ANY"], "operations": ["%s"]}}]}}}`, operation),
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		return resp.Data["results"].([]map[string]interface{})
+	}
+
+	// only the formatting differs from the stored permission targets
+	results := sync(t, "reader")
+	assert.Equal(t, "unchanged", results[0]["action"])
+
+	// the operations expand the same, but the raw form changes
+	results = sync(t, "read")
+	assert.Equal(t, "update", results[0]["action"])
+	assert.Equal(t, "applied", results[0]["status"])
+
+	role, err := getRoleEntry(context.Background(), req.Storage, "preset_role")
+	require.NoError(t, err)
+	assert.Contains(t, role.RawPermissionTargets, `"operations":["read"]`)
+	assert.NotContains(t, role.RawPermissionTargets, "reader")
+}
+
+func TestPathRolesSyncRevokeTokens(t *testing.T) {
+	t.Parallel()
+	setup := func(t *testing.T) (*logical.Request, logical.Backend, *mockArtifactoryClient, string) {
+		req, backend := newArtMockEnv(t)
+		testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+			"base_url":     "https://example.jfrog.io/example",
+			"bearer_token": "mybearertoken",
+		})
+		mustRoleCreate(req, backend, t, "keep_role", map[string]interface{}{
+			"groups": []string{"g1"},
+		})
+		mustRoleCreate(req, backend, t, "delete_role", map[string]interface{}{
+			"groups": []string{"g1"},
+		})
+		resp, err := testIssueToken(req, backend, t, "delete_role", nil)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		return req, backend, getMockClient(t, backend), resp.Data["token_id"].(string)
+	}
+	manifest := `{"roles": {"keep_role": {"groups": ["g1"]}}}`
+
+	t.Run("default", func(t *testing.T) {
+		t.Parallel()
+		req, backend, mock, tokenID := setup(t)
+		resp, err := testRolesSync(req, backend, map[string]interface{}{
+			"manifest": manifest,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		assert.Equal(t, []string{tokenID}, mock.revokedTokens)
+	})
+
+	t.Run("without_revoke", func(t *testing.T) {
+		t.Parallel()
+		req, backend, mock, _ := setup(t)
+		resp, err := testRolesSync(req, backend, map[string]interface{}{
+			"manifest":      manifest,
+			"revoke_tokens": false,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		assert.Equal(t, "delete", resp.Data["results"].([]map[string]interface{})[0]["action"])
+		assert.Empty(t, mock.revokedTokens)
+
+		roles, err := backend.(*ArtifactoryBackend).listRoleEntries(context.Background(), req.Storage)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"keep_role"}, roles)
+	})
+}
+
+func testRolesSync(req *logical.Request, b logical.Backend, data map[string]interface{}) (*logical.Response, error) {
+	req.Operation = logical.UpdateOperation
+	req.Path = rolesSyncPath
+	req.Data = data
+
+	return b.HandleRequest(context.Background(), req)
+}
+
+func TestPathRolesSyncMissingReferences(t *testing.T) {
+	t.Parallel()
+	req, backend := newArtMockEnv(t)
+	useMockClient(backend, &mockArtifactoryClient{
+		missingRepositories: []string{"missing-repo"},
+		missingGroups:       []string{"missing-group"},
+	})
+	testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+		"max_ttl":      "3600s",
+	})
+	mustRoleCreate(req, backend, t, "delete_role", map[string]interface{}{
+		"groups": []string{"g1"},
+	})
+
+	manifest := `
+roles:
+  good_role:
+    groups: ["g1"]
+  bad_role:
+    groups: ["missing-group"]
+    permission_targets:
+      - repo:
+          repositories: ["missing-repo"]
+          operations: ["read"]
+`
+
+	t.Run("dry_run", func(t *testing.T) {
+		resp, err := testRolesSync(req, backend, map[string]interface{}{
+			"manifest": manifest,
+			"dry_run":  true,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		results := resp.Data["results"].([]map[string]interface{})
+		require.Len(t, results, 3)
+		assert.Equal(t, map[string]interface{}{"name": "delete_role", "action": "delete", "status": "planned"}, results[0])
+		assert.Equal(t, "bad_role", results[1]["name"])
+		assert.Equal(t, "failed", results[1]["status"])
+		assert.Contains(t, results[1]["error"], "repository 'missing-repo' does not exist")
+		assert.Contains(t, results[1]["error"], "group 'missing-group' does not exist")
+		assert.Equal(t, map[string]interface{}{"name": "good_role", "action": "create", "status": "planned"}, results[2])
+		assert.NotEmpty(t, resp.Warnings)
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		resp, err := testRolesSync(req, backend, map[string]interface{}{
+			"manifest": manifest,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		actualErr := resp.Data["error"].(string)
+		assert.Contains(t, actualErr, "role 'bad_role'")
+		assert.Contains(t, actualErr, "repository 'missing-repo' does not exist")
+
+		roles, err := backend.(*ArtifactoryBackend).listRoleEntries(context.Background(), req.Storage)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"delete_role"}, roles, "nothing should be applied")
+	})
+}
+
+func TestApplyRoleSyncChangeStale(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, b := newArtMockEnv(t)
+	backend := b.(*ArtifactoryBackend)
+	testConfigUpdate(t, b, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+		"max_ttl":      "3600s",
+	})
+	mustRoleCreate(req, b, t, "raced_role", map[string]interface{}{
+		"groups": []string{"g1"},
+	})
+
+	manifest, err := parseRoleManifest(`{"roles": {"raced_role": {"groups": ["g2"]}}}`)
+	require.NoError(t, err)
+	desired, err := manifest.Roles["raced_role"].toRole("raced_role", nil, nil)
+	require.NoError(t, err)
+	changes, err := backend.computeRoleSyncChanges(ctx, req.Storage, map[string]*RoleStorageEntry{"raced_role": desired}, false)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+
+	// a write landing between planning and applying the change
+	mustRoleCreate(req, b, t, "raced_role", map[string]interface{}{
+		"groups": []string{"g3"},
+	})
+
+	_, err = backend.applyRoleSyncChange(ctx, req, changes[0], true)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "after the sync was planned")
+
+	role, err := getRoleEntry(ctx, req.Storage, "raced_role")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"g3"}, role.Groups, "the concurrent write should be kept")
+}
@@ -21,6 +21,7 @@ import (
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jfrog/jfrog-client-go/auth"
 )
 
 // basic schema for the creation of the token,
@@ -35,6 +36,10 @@ var createTokenSchema = map[string]*framework.FieldSchema{
 		Description: "The duration in seconds after which the token will expire. Default 3600 seconds",
 		Default:     60 * 60,
 	},
+	"format": {
+		Type:        framework.TypeString,
+		Description: `Output format of the token. Empty (default) returns the raw access token, "dockerconfigjson" returns a leased .dockerconfigjson payload for the role's docker registries`,
+	},
 }
 
 // create the basic jwt token with an expiry within the claim
@@ -48,6 +53,17 @@ func (backend *ArtifactoryBackend) pathTokenCreateUpdate(ctx context.Context, re
 		return logical.ErrorResponse(fmt.Sprintf("Role name '%s' not recognised", roleName)), nil
 	}
 
+	format := data.Get("format").(string)
+	switch format {
+	case "":
+	case tokenFormatDockerConfigJSON:
+		if len(roleEntry.DockerRegistries) == 0 {
+			return logical.ErrorResponse(fmt.Sprintf("Role '%s' has no docker registries configured", roleName)), nil
+		}
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("Token format '%s' is not supported", format)), nil
+	}
+
 	var tokenEntry TokenCreateEntry
 
 	ttlRaw, ok := data.GetOk("ttl")
@@ -61,11 +77,67 @@ func (backend *ArtifactoryBackend) pathTokenCreateUpdate(ctx context.Context, re
 		return logical.ErrorResponse(fmt.Sprintf("Token ttl is greater than role max ttl '%d'", roleEntry.MaxTTL)), nil
 	}
 
+	now := time.Now()
+	expiresAt := now.Add(tokenEntry.TTL)
+	quotas := roleEntry.hasTokenQuotas()
+	if quotas {
+		if err := backend.reserveTokenSlot(ctx, req.Storage, roleEntry, now, expiresAt); err != nil {
+			return nil, err
+		}
+	}
+
 	token, err := backend.createTokenEntry(ctx, req.Storage, tokenEntry, roleEntry)
 	if err != nil {
+		if quotas {
+			if releaseErr := backend.releaseTokenSlot(ctx, req.Storage, roleEntry, expiresAt); releaseErr != nil {
+				backend.Logger().Warn("unable to release token usage of role", "role_name", roleName, "error", releaseErr)
+			}
+		}
 		return logical.ErrorResponse(fmt.Sprintf("Error creating token, %#v", err)), err
 	}
 
+	if tokenID := token["token_id"].(string); tokenID != "" {
+		issued := IssuedTokenEntry{
+			TokenID:   tokenID,
+			RoleName:  roleName,
+			Username:  token["username"].(string),
+			IssuedAt:  now,
+			ExpiresAt: expiresAt,
+			EntityID:  req.EntityID,
+			Leased:    format == tokenFormatDockerConfigJSON,
+		}
+		issued.Subject, _ = auth.ExtractSubjectFromAccessToken(token["access_token"].(string))
+		if err := issued.save(ctx, req.Storage); err != nil {
+			backend.Logger().Warn("unable to record issued token", "role_name", roleName, "token_id", tokenID, "error", err)
+		}
+	} else {
+		backend.Logger().Debug("artifactory returned no token id, token is not recorded", "role_name", roleName)
+	}
+
+	if format == tokenFormatDockerConfigJSON {
+		// the shorter reference token is preferred as docker password when available
+		password := token["access_token"].(string)
+		if referenceToken, ok := token["reference_token"].(string); ok {
+			password = referenceToken
+		}
+		dockerConfig, err := newDockerConfigJSON(roleEntry.DockerRegistries, token["username"].(string), password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build docker config json - %w", err)
+		}
+
+		resp := backend.Secret(secretDockerConfigJSONType).Response(map[string]interface{}{
+			dockerConfigJSONKey: dockerConfig,
+			"username":          token["username"],
+		}, map[string]interface{}{
+			"role_name": roleName,
+			"token_id":  token["token_id"],
+		})
+		resp.Secret.TTL = tokenEntry.TTL
+		resp.Secret.MaxTTL = tokenEntry.TTL
+		resp.Secret.Renewable = false
+		return resp, nil
+	}
+
 	return &logical.Response{Data: token}, nil
 }
 
@@ -84,9 +156,9 @@ func pathToken(backend *ArtifactoryBackend) []*framework.Path {
 			Pattern:        fmt.Sprintf("%s/%s", tokenPrefix, framework.GenericNameRegex("role_name")),
 			Fields:         createTokenSchema,
 			ExistenceCheck: backend.pathTokenExistenceCheck(),
-			Callbacks: map[logical.Operation]framework.OperationFunc{
-				logical.CreateOperation: backend.pathTokenCreateUpdate,
-				logical.UpdateOperation: backend.pathTokenCreateUpdate,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.CreateOperation: localOperation(backend.pathTokenCreateUpdate),
+				logical.UpdateOperation: localOperation(backend.pathTokenCreateUpdate),
 			},
 			HelpSynopsis:    pathTokenHelpSyn,
 			HelpDescription: pathTokenHelpDesc,
@@ -106,4 +178,15 @@ then "artifactory/token/deploy" would generate tokens for the "deploy" role.
 On the backend, each role is associated with a group.
 The token will be scoped to this group. Tokens have a
 short-term lease (default 10-mins) associated with them but cannot be renewed.
+
+With "format=dockerconfigjson", the token is returned as a ".dockerconfigjson"
+payload with an auth entry for each of the role's "docker_registries", suitable
+for a Kubernetes image pull secret. The lease TTL matches the token expiry.
+
+Roles with "include_reference_token" set also return a "reference_token",
+which is used as the docker password in the dockerconfigjson format.
+
+Roles with "max_active_tokens" or "max_issue_rate" set reject requests over
+their quota with HTTP 429. Tokens count as active until they expire. Usage is
+only tracked while a role has a quota.
 `
@@ -0,0 +1,154 @@
+// Copyright  2024 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+var issuedTokenSchema = map[string]*framework.FieldSchema{
+	"role_name": {
+		Type:        framework.TypeString,
+		Description: "The name of the role the token was issued under",
+	},
+	"token_id": {
+		Type:        framework.TypeString,
+		Description: "The Artifactory ID of the token",
+	},
+}
+
+func issuedTokenDetails(token *IssuedTokenEntry) map[string]interface{} {
+	return map[string]interface{}{
+		"token_id":   token.TokenID,
+		"role_name":  token.RoleName,
+		"subject":    token.Subject,
+		"username":   token.Username,
+		"issued_at":  token.IssuedAt.Format(time.RFC3339),
+		"expires_at": token.ExpiresAt.Format(time.RFC3339),
+		"entity_id":  token.EntityID,
+		"leased":     token.Leased,
+	}
+}
+
+// pathIssuedTokensList lists the unexpired tokens issued under a role
+func (backend *ArtifactoryBackend) pathIssuedTokensList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("role_name").(string)
+	tokens, err := listIssuedTokenEntries(ctx, req.Storage, roleName, time.Now())
+	if err != nil {
+		return logical.ErrorResponse("Error listing issued tokens"), err
+	}
+
+	keys := make([]string, 0, len(tokens))
+	keyInfo := make(map[string]interface{}, len(tokens))
+	for _, token := range tokens {
+		keys = append(keys, token.TokenID)
+		keyInfo[token.TokenID] = map[string]interface{}{
+			"subject":    token.Subject,
+			"expires_at": token.ExpiresAt.Format(time.RFC3339),
+			"entity_id":  token.EntityID,
+		}
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+// pathIssuedTokenRead reads the record of an issued token
+func (backend *ArtifactoryBackend) pathIssuedTokenRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	token, err := getIssuedTokenEntry(ctx, req.Storage, data.Get("role_name").(string), data.Get("token_id").(string))
+	if err != nil {
+		return logical.ErrorResponse("Error reading issued token"), err
+	}
+	if token == nil || !token.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+
+	return &logical.Response{Data: issuedTokenDetails(token)}, nil
+}
+
+// pathIssuedTokenRevoke revokes an issued token in Artifactory
+func (backend *ArtifactoryBackend) pathIssuedTokenRevoke(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("role_name").(string)
+	tokenID := data.Get("token_id").(string)
+
+	token, err := getIssuedTokenEntry(ctx, req.Storage, roleName, tokenID)
+	if err != nil {
+		return logical.ErrorResponse("Error reading issued token"), err
+	}
+	if token == nil {
+		return nil, nil
+	}
+
+	role, err := getRoleEntry(ctx, req.Storage, roleName)
+	if err != nil {
+		return logical.ErrorResponse("Error reading role"), err
+	}
+
+	if err := backend.revokeIssuedToken(ctx, req.Storage, role, token); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	backend.Logger().Info("revoked issued token", "role_name", roleName, "token_id", tokenID)
+	return nil, nil
+}
+
+func pathTokenIssued(backend *ArtifactoryBackend) []*framework.Path {
+	paths := []*framework.Path{
+		{
+			Pattern: fmt.Sprintf("%s/%s/issued/?", tokenPrefix, framework.GenericNameRegex("role_name")),
+			Fields:  issuedTokenSchema,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: backend.pathIssuedTokensList,
+			},
+			HelpSynopsis:    pathTokenIssuedHelpSyn,
+			HelpDescription: pathTokenIssuedHelpDesc,
+		},
+		{
+			Pattern: fmt.Sprintf("%s/%s/issued/%s", tokenPrefix, framework.GenericNameRegex("role_name"), framework.GenericNameRegex("token_id")),
+			Fields:  issuedTokenSchema,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation:   &framework.PathOperation{Callback: backend.pathIssuedTokenRead},
+				logical.DeleteOperation: localOperation(backend.pathIssuedTokenRevoke),
+			},
+			HelpSynopsis:    pathTokenIssuedHelpSyn,
+			HelpDescription: pathTokenIssuedHelpDesc,
+		},
+	}
+
+	return paths
+}
+
+const pathTokenIssuedHelpSyn = `List, inspect and revoke the tokens issued under a role.`
+const pathTokenIssuedHelpDesc = `
+Each token issued under a role is recorded with its Artifactory token ID,
+subject, expiry and the entity ID of the requester, until it expires.
+
+  $ vault list artifactory/token/ci-role/issued
+  $ vault read artifactory/token/ci-role/issued/<token_id>
+
+Deleting a record revokes the token in Artifactory and releases its slot in the
+role's "max_active_tokens" quota:
+
+  $ vault delete artifactory/token/ci-role/issued/<token_id>
+
+Tokens issued in the dockerconfigjson format are also Vault leases and are
+flagged as "leased". Revoking the lease revokes the token and deletes its
+record. Vault assigns lease IDs after the plugin responds, so records don't
+carry their lease ID, and deleting a record doesn't revoke the Vault lease.
+`
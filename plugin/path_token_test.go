@@ -16,9 +16,13 @@ package artifactorysecrets
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/stretchr/testify/assert"
@@ -78,6 +82,104 @@ func TestArtAccIssueToken(t *testing.T) {
 
 }
 
+func TestPathTokenDockerConfigJSON(t *testing.T) {
+	t.Parallel()
+	req, backend := newArtMockEnv(t)
+	conf := map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+		"max_ttl":      "3600s",
+	}
+	testConfigUpdate(t, backend, req.Storage, conf)
+
+	mustRoleCreate(req, backend, t, "docker_role", map[string]interface{}{
+		"groups":            []string{"testgroup1"},
+		"docker_registries": []string{"docker.example.com", "registry.example.com:5000"},
+	})
+	mustRoleCreate(req, backend, t, "plain_role", map[string]interface{}{
+		"groups": []string{"testgroup1"},
+	})
+
+	t.Run("success", func(t *testing.T) {
+		resp, err := testIssueToken(req, backend, t, "docker_role", map[string]interface{}{
+			"format": "dockerconfigjson",
+			"ttl":    "120s",
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		require.NotNil(t, resp.Secret)
+		assert.Equal(t, 120*time.Second, resp.Secret.TTL)
+		assert.False(t, resp.Secret.Renewable)
+
+		var cfg dockerConfigJSON
+		require.NoError(t, json.Unmarshal([]byte(resp.Data[".dockerconfigjson"].(string)), &cfg))
+		require.Len(t, cfg.Auths, 2)
+
+		username := tokenUsername("docker_role")
+		auth := cfg.Auths["registry.example.com:5000"]
+		assert.Equal(t, username, auth.Username)
+		assert.Equal(t, "mocktoken", auth.Password)
+		assert.Equal(t, base64.StdEncoding.EncodeToString([]byte(username+":mocktoken")), auth.Auth)
+	})
+
+	t.Run("lease_revoke", func(t *testing.T) {
+		resp, err := testIssueToken(req, backend, t, "docker_role", map[string]interface{}{
+			"format": "dockerconfigjson",
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		tokenID := resp.Secret.InternalData["token_id"].(string)
+		require.NotEmpty(t, tokenID)
+
+		ctx := context.Background()
+		token, err := getIssuedTokenEntry(ctx, req.Storage, "docker_role", tokenID)
+		require.NoError(t, err)
+		require.NotNil(t, token)
+		assert.True(t, token.Leased)
+
+		_, err = backend.HandleRequest(ctx, &logical.Request{
+			Operation: logical.RevokeOperation,
+			Storage:   req.Storage,
+			Secret:    resp.Secret,
+		})
+		require.NoError(t, err)
+
+		mock := getMockClient(t, backend)
+		assert.Contains(t, mock.revokedTokens, tokenID)
+		token, err = getIssuedTokenEntry(ctx, req.Storage, "docker_role", tokenID)
+		require.NoError(t, err)
+		assert.Nil(t, token, "revoking the lease deletes the issued token record")
+	})
+
+	t.Run("no_registries", func(t *testing.T) {
+		resp, err := testIssueToken(req, backend, t, "plain_role", map[string]interface{}{
+			"format": "dockerconfigjson",
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"].(string), "has no docker registries configured")
+	})
+
+	t.Run("unknown_format", func(t *testing.T) {
+		resp, err := testIssueToken(req, backend, t, "plain_role", map[string]interface{}{
+			"format": "yaml",
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"].(string), "Token format 'yaml' is not supported")
+	})
+
+	t.Run("invalid_registry", func(t *testing.T) {
+		resp, err := testRoleCreate(req, backend, t, "bad_docker_role", map[string]interface{}{
+			"groups":            []string{"testgroup1"},
+			"docker_registries": []string{"https://docker.example.com"},
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"].(string), "must be a hostname")
+	})
+}
+
 // create the token given the parameters
 func testIssueToken(req *logical.Request, b logical.Backend, t *testing.T, roleName string, data map[string]interface{}) (*logical.Response, error) {
 	req.Operation = logical.UpdateOperation
@@ -88,3 +190,324 @@ func testIssueToken(req *logical.Request, b logical.Backend, t *testing.T, roleN
 
 	return resp, err
 }
+
+func TestPathTokenQuotas(t *testing.T) {
+	t.Parallel()
+	req, backend := newArtMockEnv(t)
+	testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+		"max_ttl":      "3600s",
+	})
+
+	assertTooManyRequests := func(t *testing.T, err error, msg string) {
+		t.Helper()
+		require.Error(t, err)
+		var codedErr logical.HTTPCodedError
+		require.ErrorAs(t, err, &codedErr)
+		assert.Equal(t, http.StatusTooManyRequests, codedErr.Code())
+		assert.Contains(t, codedErr.Error(), msg)
+	}
+
+	t.Run("max_active_tokens", func(t *testing.T) {
+		mustRoleCreate(req, backend, t, "active_role", map[string]interface{}{
+			"groups":            []string{"testgroup1"},
+			"max_active_tokens": 2,
+		})
+
+		for i := 0; i < 2; i++ {
+			resp, err := testIssueToken(req, backend, t, "active_role", nil)
+			require.NoError(t, err)
+			require.False(t, resp.IsError())
+		}
+		_, err := testIssueToken(req, backend, t, "active_role", nil)
+		assertTooManyRequests(t, err, "limit of 2 active tokens")
+
+		resp, err := testRoleRead(req, backend, t, "active_role")
+		require.NoError(t, err)
+		assert.Equal(t, 2, resp.Data["usage"].(map[string]interface{})["active_tokens"])
+	})
+
+	t.Run("max_issue_rate", func(t *testing.T) {
+		mustRoleCreate(req, backend, t, "rate_role", map[string]interface{}{
+			"groups":         []string{"testgroup1"},
+			"max_issue_rate": 1,
+		})
+
+		resp, err := testIssueToken(req, backend, t, "rate_role", nil)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		_, err = testIssueToken(req, backend, t, "rate_role", nil)
+		assertTooManyRequests(t, err, "limit of 1 tokens issued per minute")
+	})
+
+	t.Run("failed_issuance_releases_slot", func(t *testing.T) {
+		mustRoleCreate(req, backend, t, "failing_role", map[string]interface{}{
+			"groups":            []string{"testgroup1"},
+			"max_active_tokens": 1,
+			"max_issue_rate":    1,
+		})
+
+		ab := backend.(*ArtifactoryBackend)
+		client := ab.client
+		ab.client = &mockArtifactoryClient{failCreateToken: true}
+		_, err := testIssueToken(req, backend, t, "failing_role", nil)
+		ab.client = client
+		require.Error(t, err)
+
+		resp, err := testIssueToken(req, backend, t, "failing_role", nil)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+	})
+
+	t.Run("no_quotas", func(t *testing.T) {
+		mustRoleCreate(req, backend, t, "unlimited_role", map[string]interface{}{
+			"groups": []string{"testgroup1"},
+		})
+
+		resp, err := testIssueToken(req, backend, t, "unlimited_role", nil)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		entry, err := req.Storage.Get(context.Background(), tokenUsageKey("unlimited_role"))
+		require.NoError(t, err)
+		assert.Nil(t, entry, "usage is not tracked without quotas")
+	})
+
+	t.Run("negative_quota", func(t *testing.T) {
+		resp, err := testRoleCreate(req, backend, t, "negative_role", map[string]interface{}{
+			"groups":         []string{"testgroup1"},
+			"max_issue_rate": -1,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"].(string), "max_issue_rate must not be negative")
+	})
+}
+
+func TestTokenUsagePrune(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	usage := &TokenUsageEntry{
+		ActiveTokenExpirations: []time.Time{now.Add(-time.Minute), now.Add(time.Minute)},
+		IssueBucket:            0,
+		BucketUpdatedAt:        now.Add(-30 * time.Second),
+	}
+
+	usage.prune(now, 10)
+	assert.Len(t, usage.ActiveTokenExpirations, 1)
+	assert.InDelta(t, 5, usage.IssueBucket, 0.01, "half a minute refills half the bucket")
+
+	usage.prune(now.Add(time.Hour), 10)
+	assert.Empty(t, usage.ActiveTokenExpirations)
+	assert.Equal(t, float64(10), usage.IssueBucket, "bucket is capped at the rate")
+}
+
+func TestPathTokenIssued(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, backend := newArtMockEnv(t)
+	testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+		"max_ttl":      "3600s",
+	})
+	mustRoleCreate(req, backend, t, "issued_role", map[string]interface{}{
+		"groups":            []string{"testgroup1"},
+		"max_active_tokens": 2,
+	})
+
+	req.EntityID = "entity-1"
+	var tokenIDs []string
+	for i := 0; i < 2; i++ {
+		resp, err := testIssueToken(req, backend, t, "issued_role", nil)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		tokenIDs = append(tokenIDs, resp.Data["token_id"].(string))
+	}
+	req.EntityID = ""
+
+	t.Run("list", func(t *testing.T) {
+		resp, err := testIssuedTokenRequest(req, backend, logical.ListOperation, "token/issued_role/issued", nil)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, tokenIDs, resp.Data["keys"])
+	})
+
+	t.Run("read", func(t *testing.T) {
+		resp, err := testIssuedTokenRequest(req, backend, logical.ReadOperation, "token/issued_role/issued/"+tokenIDs[0], nil)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, tokenIDs[0], resp.Data["token_id"])
+		assert.Equal(t, "entity-1", resp.Data["entity_id"])
+		assert.Equal(t, tokenUsername("issued_role"), resp.Data["username"])
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		_, err := testIssuedTokenRequest(req, backend, logical.DeleteOperation, "token/issued_role/issued/"+tokenIDs[0], nil)
+		require.NoError(t, err)
+
+		mock := getMockClient(t, backend)
+		assert.Equal(t, []string{tokenIDs[0]}, mock.revokedTokens)
+
+		token, err := getIssuedTokenEntry(ctx, req.Storage, "issued_role", tokenIDs[0])
+		require.NoError(t, err)
+		assert.Nil(t, token)
+
+		// the revoked token no longer counts against max_active_tokens
+		resp, err := testIssueToken(req, backend, t, "issued_role", nil)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+	})
+
+	t.Run("prune_expired", func(t *testing.T) {
+		expired := IssuedTokenEntry{
+			TokenID:   "expired-token",
+			RoleName:  "issued_role",
+			IssuedAt:  time.Now().Add(-2 * time.Hour),
+			ExpiresAt: time.Now().Add(-time.Hour),
+		}
+		require.NoError(t, expired.save(ctx, req.Storage))
+
+		// listing skips expired records without deleting them
+		resp, err := testIssuedTokenRequest(req, backend, logical.ListOperation, "token/issued_role/issued", nil)
+		require.NoError(t, err)
+		assert.NotContains(t, resp.Data["keys"], "expired-token")
+		token, err := getIssuedTokenEntry(ctx, req.Storage, "issued_role", "expired-token")
+		require.NoError(t, err)
+		assert.NotNil(t, token, "listing should not write to storage")
+
+		require.NoError(t, backend.(*ArtifactoryBackend).pruneIssuedTokens(ctx, req.Storage))
+
+		token, err = getIssuedTokenEntry(ctx, req.Storage, "issued_role", "expired-token")
+		require.NoError(t, err)
+		assert.Nil(t, token)
+	})
+}
+
+func testIssuedTokenRequest(req *logical.Request, b logical.Backend, op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+	req.Operation = op
+	req.Path = path
+	req.Data = data
+
+	return b.HandleRequest(context.Background(), req)
+}
+
+func TestRevokeRoleTokens(t *testing.T) {
+	t.Parallel()
+	conf := map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+		"max_ttl":      "3600s",
+	}
+	ptRead := `{"repo": {"repositories": ["ANY"], "operations": ["read"]}}`
+	ptWrite := `{"repo": {"repositories": ["ANY"], "operations": ["write"]}}`
+
+	setup := func(t *testing.T) (*logical.Request, logical.Backend, *mockArtifactoryClient, string) {
+		req, backend := newArtMockEnv(t)
+		testConfigUpdate(t, backend, req.Storage, conf)
+		mustRoleCreate(req, backend, t, "revoke_role", map[string]interface{}{
+			"permission_targets": fmt.Sprintf("[%s, %s]", ptRead, ptWrite),
+			"groups":             []string{"testgroup1"},
+		})
+		resp, err := testIssueToken(req, backend, t, "revoke_role", nil)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		return req, backend, getMockClient(t, backend), resp.Data["token_id"].(string)
+	}
+
+	t.Run("delete", func(t *testing.T) {
+		t.Parallel()
+		req, backend, mock, tokenID := setup(t)
+		mustRoleDelete(req, backend, t, "revoke_role")
+		assert.Equal(t, []string{tokenID}, mock.revokedTokens)
+	})
+
+	t.Run("delete_without_revoke", func(t *testing.T) {
+		t.Parallel()
+		req, backend, mock, _ := setup(t)
+		req.Operation = logical.DeleteOperation
+		req.Path = "roles/revoke_role"
+		req.Data = map[string]interface{}{"revoke_tokens": false}
+		_, err := backend.HandleRequest(context.Background(), req)
+		require.NoError(t, err)
+		assert.Empty(t, mock.revokedTokens)
+	})
+
+	t.Run("update_widening_keeps_tokens", func(t *testing.T) {
+		t.Parallel()
+		req, backend, mock, _ := setup(t)
+		mustRoleUpdate(req, backend, t, "revoke_role", map[string]interface{}{
+			"groups":        []string{"testgroup1", "testgroup2"},
+			"revoke_tokens": true,
+		})
+		assert.Empty(t, mock.revokedTokens)
+	})
+
+	t.Run("patch_shrinking_revokes", func(t *testing.T) {
+		t.Parallel()
+		req, backend, mock, tokenID := setup(t)
+		resp, err := testRolePatch(req, backend, t, "revoke_role", map[string]interface{}{
+			"remove_permission_targets": fmt.Sprintf("[%s]", ptWrite),
+			"revoke_tokens":             true,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError(), "unexpected error: %v", resp.Error())
+		assert.Equal(t, 1, resp.Data["revoked_tokens"])
+		assert.Equal(t, []string{tokenID}, mock.revokedTokens)
+	})
+
+	t.Run("update_shrinking_without_flag_keeps_tokens", func(t *testing.T) {
+		t.Parallel()
+		req, backend, mock, _ := setup(t)
+		mustRoleUpdate(req, backend, t, "revoke_role", map[string]interface{}{
+			"permission_targets": fmt.Sprintf("[%s]", ptRead),
+		})
+		assert.Empty(t, mock.revokedTokens)
+	})
+}
+
+func TestPathTokenReferenceToken(t *testing.T) {
+	t.Parallel()
+	req, backend := newArtMockEnv(t)
+	testConfigUpdate(t, backend, req.Storage, map[string]interface{}{
+		"base_url":     "https://example.jfrog.io/example",
+		"bearer_token": "mybearertoken",
+		"max_ttl":      "3600s",
+	})
+	mustRoleCreate(req, backend, t, "reference_role", map[string]interface{}{
+		"groups":                  []string{"testgroup1"},
+		"docker_registries":       []string{"docker.example.com"},
+		"include_reference_token": true,
+	})
+
+	t.Run("reference_token", func(t *testing.T) {
+		resp, err := testIssueToken(req, backend, t, "reference_role", nil)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, "mocktoken", resp.Data["access_token"])
+		assert.Equal(t, "mockreftoken", resp.Data["reference_token"])
+	})
+
+	t.Run("dockerconfigjson_uses_reference_token", func(t *testing.T) {
+		resp, err := testIssueToken(req, backend, t, "reference_role", map[string]interface{}{
+			"format": "dockerconfigjson",
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		var cfg dockerConfigJSON
+		require.NoError(t, json.Unmarshal([]byte(resp.Data[".dockerconfigjson"].(string)), &cfg))
+		assert.Equal(t, "mockreftoken", cfg.Auths["docker.example.com"].Password)
+	})
+
+	t.Run("unsupported_version", func(t *testing.T) {
+		getMockClient(t, backend).version = "7.21.1"
+		resp, err := testRoleCreate(req, backend, t, "old_reference_role", map[string]interface{}{
+			"groups":                  []string{"testgroup1"},
+			"include_reference_token": true,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.IsError(), "expecting error")
+		assert.Contains(t, resp.Data["error"].(string), "reference tokens require artifactory 7.38.10 or later, found 7.21.1")
+	})
+}
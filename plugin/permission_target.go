@@ -15,7 +15,12 @@
 package artifactorysecrets
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"regexp"
+	"slices"
 
 	"github.com/hashicorp/go-multierror"
 )
@@ -27,7 +32,12 @@ type Permission struct {
 	Operations      []string `json:"operations,omitempty"`
 }
 
+var permissionTargetNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
 type PermissionTarget struct {
+	// Name optionally identifies the permission target within the role. If not set,
+	// a hash of the content is used instead.
+	Name  string      `json:"name,omitempty"`
 	Repo  *Permission `json:"repo,omitempty"`
 	Build *Permission `json:"build,omitempty"`
 	// ReleaseBundle Permission `json:"release_bundle,omitempty"`
@@ -37,6 +47,10 @@ type PermissionTarget struct {
 func (pt PermissionTarget) assertValid() error {
 	var err *multierror.Error
 
+	if pt.Name != "" && !permissionTargetNameRegex.MatchString(pt.Name) {
+		err = multierror.Append(err, fmt.Errorf("'name' field '%s' must be 1-32 alphanumeric, '-' or '_' characters", pt.Name))
+	}
+
 	if pt.Repo != nil {
 		if len(pt.Repo.Repositories) == 0 {
 			err = multierror.Append(err, errors.New("'repo.repositories' field must be supplied"))
@@ -46,6 +60,9 @@ func (pt PermissionTarget) assertValid() error {
 		} else if e := validateOperations(pt.Repo.Operations); e != nil {
 			err = multierror.Append(err, e)
 		}
+		if e := pt.Repo.validatePatterns("repo"); e != nil {
+			err = multierror.Append(err, e)
+		}
 	}
 
 	if pt.Build != nil {
@@ -57,6 +74,333 @@ func (pt PermissionTarget) assertValid() error {
 		} else if e := validateOperations(pt.Build.Operations); e != nil {
 			err = multierror.Append(err, e)
 		}
+		if e := pt.Build.validatePatterns("build"); e != nil {
+			err = multierror.Append(err, e)
+		}
+	}
+	return err.ErrorOrNil()
+}
+
+// normalizeOperations replaces the presets and aliases in the operations of the permission
+// target with the V2 operations they stand for. Permissions are copied rather than changed,
+// as they may be shared with the permission targets as supplied.
+func (pt *PermissionTarget) normalizeOperations(presets map[string][]string) error {
+	var err *multierror.Error
+
+	normalize := func(section string, p **Permission) {
+		if *p == nil || len((*p).Operations) == 0 {
+			return
+		}
+		ops, e := normalizeOperations((*p).Operations, presets)
+		if e != nil {
+			err = multierror.Append(err, fmt.Errorf("'%s.operations' - %w", section, e))
+			return
+		}
+		normalized := **p
+		normalized.Operations = ops
+		*p = &normalized
+	}
+	normalize("repo", &pt.Repo)
+	normalize("build", &pt.Build)
+
+	return err.ErrorOrNil()
+}
+
+// normalizePermissionTargets normalizes the operations of permission targets in place
+func normalizePermissionTargets(pts []PermissionTarget, presets map[string][]string) error {
+	var err *multierror.Error
+
+	for idx := range pts {
+		if e := pts[idx].normalizeOperations(presets); e != nil {
+			err = multierror.Append(err, e)
+		}
+	}
+
+	return err.ErrorOrNil()
+}
+
+// validatePatterns checks the syntax of the include and exclude patterns. Empty patterns
+// are left to lintPermissionTargets.
+func (p *Permission) validatePatterns(section string) error {
+	var err *multierror.Error
+
+	for _, list := range p.lists() {
+		if list.field == "repositories" {
+			continue
+		}
+		for _, pattern := range list.values {
+			if pattern == "" {
+				continue
+			}
+			if e := validateAntPattern(pattern); e != nil {
+				err = multierror.Append(err, fmt.Errorf("'%s.%s' - %w", section, list.field, e))
+			}
+		}
 	}
+
 	return err.ErrorOrNil()
 }
+
+// permissionList is one of the lists of a permission and its field name
+type permissionList struct {
+	field  string
+	values []string
+}
+
+// lists returns the patterns and repositories of the permission
+func (p *Permission) lists() []permissionList {
+	return []permissionList{
+		{"include_patterns", p.IncludePatterns},
+		{"exclude_patterns", p.ExcludePatterns},
+		{"repositories", p.Repositories},
+	}
+}
+
+// label refers to the permission target at index idx of a role in messages
+func (pt PermissionTarget) label(idx int) string {
+	if pt.Name != "" {
+		return fmt.Sprintf("permission target '%s'", pt.Name)
+	}
+	return fmt.Sprintf("permission target %d", idx)
+}
+
+// lint returns warnings about patterns and repositories of the permission that are valid
+// but likely mistakes
+func (p *Permission) lint(label, section string) []string {
+	var warnings []string
+
+	for _, list := range p.lists() {
+		seen := make(map[string]bool, len(list.values))
+		for _, value := range list.values {
+			if seen[value] && value != "" {
+				warnings = append(warnings, fmt.Sprintf("%s: '%s' is listed more than once in '%s.%s'", label, value, section, list.field))
+			}
+			seen[value] = true
+		}
+	}
+
+	// Artifactory includes every path without include patterns
+	includes := []string{}
+	for _, pattern := range p.IncludePatterns {
+		if pattern == "" {
+			warnings = append(warnings, fmt.Sprintf("%s: the empty pattern in '%s.include_patterns' grants nothing", label, section))
+			continue
+		}
+		includes = append(includes, pattern)
+	}
+	if len(p.IncludePatterns) == 0 {
+		includes = append(includes, "**")
+	}
+
+	for _, include := range includes {
+		for _, exclude := range p.ExcludePatterns {
+			if exclude == "" || !antPatternCovers(exclude, include) {
+				continue
+			}
+			if len(p.IncludePatterns) == 0 {
+				warnings = append(warnings, fmt.Sprintf("%s: exclude pattern '%s' in '%s.exclude_patterns' excludes every path", label, exclude, section))
+			} else {
+				warnings = append(warnings, fmt.Sprintf("%s: include pattern '%s' in '%s.include_patterns' is entirely excluded by '%s'", label, include, section, exclude))
+			}
+			break
+		}
+	}
+
+	return warnings
+}
+
+// lintPermissionTargets returns warnings about permission targets that are valid but likely
+// mistakes: duplicate or empty patterns, includes shadowed by an exclude, and operations
+// granted again on a repository by a permission target with the same patterns. Invalid
+// patterns are rejected by assertValid.
+func lintPermissionTargets(pts []PermissionTarget) []string {
+	var warnings []string
+
+	// the permission target first granting an operation, by section, repository and patterns
+	grantedBy := map[string]string{}
+	checkGrants := func(label, section string, p *Permission) {
+		includes := slices.Clone(p.IncludePatterns)
+		excludes := slices.Clone(p.ExcludePatterns)
+		slices.Sort(includes)
+		slices.Sort(excludes)
+		scope := fmt.Sprintf("%q %q", includes, excludes)
+
+		repos := slices.Clone(p.Repositories)
+		slices.Sort(repos)
+		for _, repo := range slices.Compact(repos) {
+			for _, op := range p.Operations {
+				key := fmt.Sprintf("%s %q %s %s", section, repo, scope, op)
+				if first, ok := grantedBy[key]; ok {
+					warnings = append(warnings, fmt.Sprintf("%s: '%s' on '%s' is already granted by %s with the same patterns", label, op, repo, first))
+					continue
+				}
+				grantedBy[key] = label
+			}
+		}
+	}
+
+	for idx, pt := range pts {
+		label := pt.label(idx)
+		if pt.Repo != nil {
+			warnings = append(warnings, pt.Repo.lint(label, "repo")...)
+			checkGrants(label, "repo", pt.Repo)
+		}
+		if pt.Build != nil {
+			warnings = append(warnings, pt.Build.lint(label, "build")...)
+			checkGrants(label, "build", pt.Build)
+		}
+	}
+
+	return warnings
+}
+
+// identity identifies the permission target within its role
+func (pt PermissionTarget) identity() string {
+	if pt.Name != "" {
+		return pt.Name
+	}
+	return pt.contentHash()
+}
+
+// contentHash hashes the grants of the permission target, ignoring its name
+func (pt PermissionTarget) contentHash() string {
+	pt.Name = ""
+	b, _ := json.Marshal(pt)
+	return fmt.Sprintf("%x", sha256.Sum256(b))[:ptHashLen]
+}
+
+// validatePermissionTargetIdentities makes sure that no two permission targets of a role share a name
+func validatePermissionTargetIdentities(pts []PermissionTarget) error {
+	var err *multierror.Error
+
+	seen := make(map[string]bool, len(pts))
+	for _, pt := range pts {
+		id := pt.identity()
+		if seen[id] {
+			if pt.Name != "" {
+				err = multierror.Append(err, fmt.Errorf("permission target name '%s' is used more than once", id))
+			} else {
+				err = multierror.Append(err, errors.New("identical permission targets must be given distinct names"))
+			}
+		}
+		seen[id] = true
+	}
+
+	return err.ErrorOrNil()
+}
+
+// equal compares permission targets by their JSON representation
+func (pt PermissionTarget) equal(other PermissionTarget) bool {
+	a, errA := json.Marshal(pt)
+	b, errB := json.Marshal(other)
+	return errA == nil && errB == nil && string(a) == string(b)
+}
+
+// equalPermissionTargets compares two lists of permission targets, order included
+func equalPermissionTargets(a, b []PermissionTarget) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if !a[idx].equal(b[idx]) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergePermissionTargets removes and then appends permission targets, keeping the order of
+// the remaining ones. Removing an absent or adding an already present target is a no-op
+// reported as a warning.
+func mergePermissionTargets(pts, add, remove []PermissionTarget) ([]PermissionTarget, []string) {
+	var warnings []string
+	merged := make([]PermissionTarget, 0, len(pts)+len(add))
+
+	removed := make([]bool, len(remove))
+	for _, pt := range pts {
+		drop := false
+		for idx, r := range remove {
+			if pt.equal(r) {
+				drop = true
+				removed[idx] = true
+			}
+		}
+		if !drop {
+			merged = append(merged, pt)
+		}
+	}
+	for idx, ok := range removed {
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("permission target %d of remove_permission_targets is not part of the role", idx))
+		}
+	}
+
+	for idx, a := range add {
+		present := false
+		for _, pt := range merged {
+			if pt.equal(a) {
+				present = true
+				break
+			}
+		}
+		if present {
+			warnings = append(warnings, fmt.Sprintf("permission target %d of add_permission_targets is already part of the role", idx))
+			continue
+		}
+		merged = append(merged, a)
+	}
+
+	return merged, warnings
+}
+
+// matchesRepository reports whether the permission applies to the repository. The second
+// result is true if it only matches through an "ANY LOCAL", "ANY REMOTE" or "ANY DISTRIBUTION"
+// placeholder, whose repository type can't be checked locally.
+func (p *Permission) matchesRepository(repository string) (bool, bool) {
+	typed := false
+	for _, repo := range p.Repositories {
+		switch {
+		case repo == repository, repo == "ANY":
+			return true, false
+		case isPseudoRepository(repo):
+			typed = true
+		}
+	}
+	return typed, typed
+}
+
+// matchesPath reports whether the path is included and not excluded by the permission patterns
+func (p *Permission) matchesPath(path string) bool {
+	// Artifactory defaults to "**" without include patterns only. An empty pattern
+	// still counts as an include pattern, and matches no path.
+	included := len(p.IncludePatterns) == 0
+	for _, pattern := range p.IncludePatterns {
+		if pattern != "" && antPathMatch(pattern, path) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+
+	for _, pattern := range p.ExcludePatterns {
+		if pattern != "" && antPathMatch(pattern, path) {
+			return false
+		}
+	}
+	return true
+}
+
+// allows reports whether the permission grants the operation on the repository path,
+// and whether the repository type was assumed
+func (p *Permission) allows(repository, path, operation string) (bool, bool) {
+	if p == nil || !slices.Contains(p.Operations, operation) {
+		return false, false
+	}
+	repoMatch, assumed := p.matchesRepository(repository)
+	if !repoMatch || !p.matchesPath(path) {
+		return false, false
+	}
+	return true, assumed
+}
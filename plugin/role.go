@@ -16,8 +16,10 @@ package artifactorysecrets
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
@@ -39,6 +41,11 @@ type RoleStorageEntry struct {
 	// The Max TTL for your token
 	MaxTTL time.Duration `json:"max_ttl" structs:"max_ttl" mapstructure:"max_ttl"`
 
+	// ExplicitTokenTTL and ExplicitMaxTTL are the TTLs set on the role itself, zero when
+	// unset. TokenTTL and MaxTTL are the effective ones, resolved by resolveTTLs.
+	ExplicitTokenTTL time.Duration `json:"explicit_token_ttl,omitempty" structs:"explicit_token_ttl" mapstructure:"explicit_token_ttl,omitempty"`
+	ExplicitMaxTTL   time.Duration `json:"explicit_max_ttl,omitempty" structs:"explicit_max_ttl" mapstructure:"explicit_max_ttl,omitempty"`
+
 	// The provided name for the role
 	Name string `json:"name" structs:"name" mapstructure:"name"`
 
@@ -49,8 +56,66 @@ type RoleStorageEntry struct {
 	// accompany any configured permission targets.
 	Groups []string `json:"groups,omitempty" structs:"groups" mapstructure:"groups,omitempty"`
 
-	RawPermissionTargets string
-	PermissionTargets    []PermissionTarget
+	// DockerRegistries are the registry hostnames used when issuing tokens
+	// in the dockerconfigjson format.
+	DockerRegistries []string `json:"docker_registries,omitempty" structs:"docker_registries" mapstructure:"docker_registries,omitempty"`
+
+	// Inherits are the role templates the role inherits permission targets, groups and TTL
+	// defaults from.
+	Inherits []string `json:"inherits,omitempty" structs:"inherits" mapstructure:"inherits,omitempty"`
+
+	// InheritedGroups are the static groups of the inherited templates, computed at write time.
+	InheritedGroups []string `json:"inherited_groups,omitempty" structs:"inherited_groups" mapstructure:"inherited_groups,omitempty"`
+
+	// MaxActiveTokens limits the number of unexpired tokens issued under the role, 0 for no limit.
+	MaxActiveTokens int `json:"max_active_tokens,omitempty" structs:"max_active_tokens" mapstructure:"max_active_tokens,omitempty"`
+
+	// MaxIssueRate limits the number of tokens issued per minute under the role, 0 for no limit.
+	MaxIssueRate int `json:"max_issue_rate,omitempty" structs:"max_issue_rate" mapstructure:"max_issue_rate,omitempty"`
+
+	// IncludeReferenceToken requests a reference token alongside the access token.
+	IncludeReferenceToken bool `json:"include_reference_token,omitempty" structs:"include_reference_token" mapstructure:"include_reference_token,omitempty"`
+
+	// RawPermissionTargets are the role's own permission targets as supplied. PermissionTargets
+	// are the effective ones, including the inherited permission targets.
+	RawPermissionTargets string             `json:"raw_permission_targets"`
+	PermissionTargets    []PermissionTarget `json:"permission_targets"`
+
+	// PermissionTargetNames are the Artifactory names of PermissionTargets, in the same order.
+	PermissionTargetNames []string `json:"permission_target_names,omitempty" structs:"permission_target_names" mapstructure:"permission_target_names,omitempty"`
+
+	// ObjectPrefix is the object prefix of the mount when the generated group and permission
+	// targets were named, empty for roles named before object prefixes were introduced.
+	ObjectPrefix string `json:"object_prefix,omitempty" structs:"object_prefix" mapstructure:"object_prefix,omitempty"`
+
+	// Version is incremented on every save but migrations. It keeps increasing across
+	// deletions of the role.
+	Version int `json:"version" structs:"version" mapstructure:"version"`
+
+	// SchemaVersion is the version of the stored format of the role
+	SchemaVersion int `json:"schema_version" structs:"schema_version" mapstructure:"schema_version"`
+
+	// migration marks saves made by the plugin itself, which neither bump the version nor
+	// record a revision, so they don't push the changes of users out of the history
+	migration bool
+}
+
+// resolveTTLs sets the effective TTLs of the role: its explicit TTLs, else the first ones
+// set by its templates, else the defaults of the roles/ endpoint
+func (role *RoleStorageEntry) resolveTTLs(templates []*RoleTemplateEntry) {
+	inheritedTokenTTL, inheritedMaxTTL := inheritTTLs(templates)
+	role.TokenTTL = firstTTL(role.ExplicitTokenTTL, inheritedTokenTTL, time.Duration(createRoleSchema["token_ttl"].Default.(int))*time.Second)
+	role.MaxTTL = firstTTL(role.ExplicitMaxTTL, inheritedMaxTTL, time.Duration(createRoleSchema["max_ttl"].Default.(int))*time.Second)
+}
+
+// firstTTL returns the first positive TTL
+func firstTTL(ttls ...time.Duration) time.Duration {
+	for _, ttl := range ttls {
+		if ttl > 0 {
+			return ttl
+		}
+	}
+	return 0
 }
 
 // validate checks whether a Role has been populated properly before saving
@@ -62,8 +127,8 @@ func (role RoleStorageEntry) validate() error {
 	if role.RoleID == "" {
 		err = multierror.Append(err, errors.New("role id is empty"))
 	}
-	if len(role.Groups) == 0 {
-		if role.RawPermissionTargets == "" {
+	if len(role.allGroups()) == 0 {
+		if role.RawPermissionTargets == "" && len(role.Inherits) == 0 {
 			err = multierror.Append(err, errors.New("raw permission targets are empty"))
 		}
 		if role.PermissionTargets == nil {
@@ -73,50 +138,264 @@ func (role RoleStorageEntry) validate() error {
 	return err.ErrorOrNil()
 }
 
-// save saves a role to storage
-func (role RoleStorageEntry) save(ctx context.Context, storage logical.Storage) error {
+// validateTTLs checks the role TTLs against each other and against the backend config
+func (role RoleStorageEntry) validateTTLs(config *ConfigStorageEntry) error {
+	if role.MaxTTL > config.MaxTTL {
+		return fmt.Errorf("role max ttl is greater than config max ttl '%d'", config.MaxTTL)
+	}
+	if role.TokenTTL > role.MaxTTL {
+		return fmt.Errorf("role token ttl is greater than role max ttl '%d'", role.MaxTTL)
+	}
+	return nil
+}
+
+// save saves a role to storage as its next version and records it as a new revision of the role
+func (role *RoleStorageEntry) save(ctx context.Context, req *logical.Request) error {
 	if err := role.validate(); err != nil {
 		return err
 	}
 
+	if !role.migration {
+		// continue from the recorded revisions, which outlive deleted roles and predate versions
+		versions, err := listRoleRevisionVersions(ctx, req.Storage, role.Name)
+		if err != nil {
+			return err
+		}
+		role.Version++
+		if len(versions) > 0 && versions[len(versions)-1] >= role.Version {
+			role.Version = versions[len(versions)-1] + 1
+		}
+	}
+	role.SchemaVersion = roleSchemaVersion
+
 	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", rolesPrefix, role.Name), role)
 	if err != nil {
 		return err
 	}
 
-	return storage.Put(ctx, entry)
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return err
+	}
+
+	if role.migration {
+		return nil
+	}
+	if err := saveRoleRevision(ctx, req, *role); err != nil {
+		return fmt.Errorf("role saved but its revision could not be recorded - %s", err.Error())
+	}
+	return nil
 }
 
-func (role RoleStorageEntry) permissionTargetsHash() string {
-	return getStringHash(role.RawPermissionTargets)
+// permissionsShrunk reports whether updated no longer grants a group or permission target
+// the role granted. Changed permission targets count as removed.
+func (role RoleStorageEntry) permissionsShrunk(updated *RoleStorageEntry) bool {
+	for _, group := range role.allGroups() {
+		if !slices.Contains(updated.allGroups(), group) {
+			return true
+		}
+	}
+	for _, pt := range role.PermissionTargets {
+		if !slices.ContainsFunc(updated.PermissionTargets, pt.equal) {
+			return true
+		}
+	}
+	return false
 }
 
-// get or create the basic lock for the role name
+// ownPermissionTargets returns the role's own permission targets as supplied, without the
+// inherited ones
+func (role RoleStorageEntry) ownPermissionTargets() ([]PermissionTarget, error) {
+	if role.RawPermissionTargets == "" {
+		return nil, nil
+	}
+	var pts []PermissionTarget
+	if err := json.Unmarshal([]byte(role.RawPermissionTargets), &pts); err != nil {
+		return nil, fmt.Errorf("failed to parse the permission targets of role '%s' - %w", role.Name, err)
+	}
+	return pts, nil
+}
+
+// allGroups returns the role's own static groups followed by the inherited ones
+func (role RoleStorageEntry) allGroups() []string {
+	if len(role.InheritedGroups) == 0 {
+		return role.Groups
+	}
+	return append(append([]string{}, role.Groups...), role.InheritedGroups...)
+}
+
+// roleLock returns the lock of the role name. Writers of the role and its Artifactory
+// objects hold it exclusively.
 func (backend *ArtifactoryBackend) roleLock(roleName string) *locksutil.LockEntry {
 	return locksutil.LockForKey(backend.roleLocks, roleName)
 }
 
+// permissionTargetNames returns the Artifactory names of the role's permission targets.
+// Roles saved before stable naming was introduced have index based names.
+func (role RoleStorageEntry) permissionTargetNames() []string {
+	if len(role.PermissionTargetNames) == len(role.PermissionTargets) {
+		return role.PermissionTargetNames
+	}
+
+	names := make([]string, len(role.PermissionTargets))
+	for idx := range role.PermissionTargets {
+		names[idx] = legacyPermissionTargetName(role.Name, idx)
+	}
+	return names
+}
+
+// permissionTargetsDiff lists the Artifactory permission targets to change in order to
+// replace the permission targets of a role.
+type permissionTargetsDiff struct {
+	// deletes are targets no longer granted, removed before any upsert
+	deletes []string
+	creates []string
+	updates []string
+	// renames are old targets whose grants carry over under a new name, removed after the upserts
+	renames []string
+}
+
+// diffPermissionTargets compares the role's permission targets to pts named with objectPrefix
+// by name rather than by position
+func diffPermissionTargets(role *RoleStorageEntry, objectPrefix string, pts []PermissionTarget) permissionTargetsDiff {
+	var diff permissionTargetsDiff
+
+	oldNames := role.permissionTargetNames()
+	oldByName := make(map[string]PermissionTarget, len(oldNames))
+	for idx, name := range oldNames {
+		oldByName[name] = role.PermissionTargets[idx]
+	}
+
+	newNames := permissionTargetNames(objectPrefix, role.Name, pts)
+	newByName := make(map[string]bool, len(newNames))
+	newHashes := make(map[string]bool, len(pts))
+	for idx, name := range newNames {
+		newByName[name] = true
+		newHashes[pts[idx].contentHash()] = true
+
+		old, ok := oldByName[name]
+		if !ok {
+			diff.creates = append(diff.creates, name)
+		} else if !old.equal(pts[idx]) {
+			diff.updates = append(diff.updates, name)
+		}
+	}
+
+	for idx, name := range oldNames {
+		if newByName[name] {
+			continue
+		}
+		if newHashes[role.PermissionTargets[idx].contentHash()] {
+			diff.renames = append(diff.renames, name)
+		} else {
+			diff.deletes = append(diff.deletes, name)
+		}
+	}
+
+	return diff
+}
+
+// validateReferenceTokenSupport checks that Artifactory is recent enough to issue reference tokens
+func (backend *ArtifactoryBackend) validateReferenceTokenSupport(ctx context.Context, storage logical.Storage) error {
+	ac, err := backend.getClient(ctx, storage)
+	if err != nil {
+		return fmt.Errorf("failed to obtain artifactory client - %s", err.Error())
+	}
+
+	artifactoryVersion, err := ac.ArtifactoryVersion()
+	if err != nil {
+		return fmt.Errorf("failed to get artifactory version - %s", err.Error())
+	}
+	ok, err := supportsReferenceTokens(artifactoryVersion)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("reference tokens require artifactory %s or later, found %s", minReferenceTokenVersion, artifactoryVersion)
+	}
+	return nil
+}
+
+// validateArtifactoryReferences checks that the repositories and static groups referenced by a
+// role exist in Artifactory, so that invalid roles are rejected before anything is modified
+func (backend *ArtifactoryBackend) validateArtifactoryReferences(ctx context.Context, storage logical.Storage, groups []string, pts []PermissionTarget) error {
+	if len(groups) == 0 && len(pts) == 0 {
+		return nil
+	}
+
+	ac, err := backend.getClient(ctx, storage)
+	if err != nil {
+		return fmt.Errorf("failed to obtain artifactory client - %s", err.Error())
+	}
+
+	var merr *multierror.Error
+
+	checked := make(map[string]bool)
+	for _, pt := range pts {
+		// build permissions always refer to the built-in build info repository
+		if pt.Repo == nil {
+			continue
+		}
+		for _, repo := range pt.Repo.Repositories {
+			if checked[repo] || isPseudoRepository(repo) {
+				continue
+			}
+			checked[repo] = true
+
+			exists, err := ac.RepositoryExists(repo)
+			if err != nil {
+				merr = multierror.Append(merr, fmt.Errorf("failed to look up repository '%s' - %s", repo, err.Error()))
+			} else if !exists {
+				merr = multierror.Append(merr, fmt.Errorf("repository '%s' does not exist", repo))
+			}
+		}
+	}
+
+	for _, group := range groups {
+		exists, err := ac.GroupExists(group)
+		if err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("failed to look up group '%s' - %s", group, err.Error()))
+		} else if !exists {
+			merr = multierror.Append(merr, fmt.Errorf("group '%s' does not exist", group))
+		}
+	}
+
+	return merr.ErrorOrNil()
+}
+
 // saveRoleWithNewPermissionTargets will create group and permission targets
 // persist in the data store
 func (backend *ArtifactoryBackend) saveRoleWithNewPermissionTargets(ctx context.Context, req *logical.Request, role *RoleStorageEntry, pts []PermissionTarget) (warning []string, err error) {
 	backend.Logger().Debug("Creating/Updating role with new permission targets")
 
-	oldPts := role.PermissionTargets
+	objectPrefix, err := backend.objectPrefix(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	diff := diffPermissionTargets(role, objectPrefix, pts)
 
 	ac, err := backend.getClient(ctx, req.Storage)
 	if err != nil {
 		return nil, fmt.Errorf("failed to obtain artifactory client - %s", err.Error())
 	}
 
+	// the generated group is renamed along with the permission targets when the object
+	// prefix of the mount changed
+	var oldGroupRole *RoleStorageEntry
+	if len(role.PermissionTargets) > 0 && role.ObjectPrefix != objectPrefix {
+		oldRole := *role
+		oldGroupRole = &oldRole
+	}
+	role.ObjectPrefix = objectPrefix
+
 	// Create/update a group
 	backend.Logger().Debug("creating/updating a group", "name", role.Name, "role_id", role.RoleID)
 	if err := ac.CreateOrReplaceGroup(role); err != nil {
 		return nil, fmt.Errorf("failed to create an artifactory group - %s", err.Error())
 	}
 
-	if len(oldPts) > len(pts) {
+	if len(diff.deletes) > 0 {
 		backend.Logger().Debug("removing role excessive permission targets", "role_name", role.Name)
-		if cleanupErr := backend.tryDeleteRoleResources(ctx, req, role, oldPts[len(pts):], len(pts), false); cleanupErr != nil {
+		if cleanupErr := backend.tryDeleteRoleResources(ctx, req, role, diff.deletes, false); cleanupErr != nil {
 			backend.Logger().Warn(
 				"unable to clean up unused old permission targets for role.",
 				"role_name", role.Name, "errors", cleanupErr)
@@ -124,31 +403,128 @@ func (backend *ArtifactoryBackend) saveRoleWithNewPermissionTargets(ctx context.
 		}
 	}
 
-	// Create/Update permission targets
-	for idx, pt := range pts {
-		ptName := permissionTargetName(role.Name, idx)
+	// Create/Update changed permission targets only
+	upserts := make(map[string]bool, len(diff.creates)+len(diff.updates))
+	for _, name := range append(diff.creates, diff.updates...) {
+		upserts[name] = true
+	}
+	names := permissionTargetNames(objectPrefix, role.Name, pts)
+	ptsByName := make(map[string]*PermissionTarget, len(pts))
+	var upsertNames []string
+	for idx := range pts {
+		if upserts[names[idx]] {
+			ptsByName[names[idx]] = &pts[idx]
+			upsertNames = append(upsertNames, names[idx])
+		}
+	}
+
+	parallel, err := backend.parallelRequests(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := runParallel(parallel, upsertNames, func(ptName string) error {
 		backend.Logger().Debug("creating/updating a permission target", "name", ptName)
-		if err := ac.CreateOrUpdatePermissionTarget(role, &pt, ptName); err != nil {
-			return nil, fmt.Errorf("Failed to create/update a permission target - %s", err.Error())
+		if err := ac.CreateOrUpdatePermissionTarget(role, ptsByName[ptName], ptName); err != nil {
+			return fmt.Errorf("Failed to create/update a permission target %s - %s", ptName, err.Error())
 		}
+		return nil
+	}); err != nil {
+		return nil, err
 	}
 
 	// update permission target in role before save
 	role.PermissionTargets = pts
-	if err = role.save(ctx, req.Storage); err != nil {
+	role.PermissionTargetNames = names
+	if err = role.save(ctx, req); err != nil {
 		return nil, err
 	}
 
+	if len(diff.renames) > 0 {
+		backend.Logger().Debug("removing renamed permission targets", "role_name", role.Name)
+		if cleanupErr := backend.tryDeleteRoleResources(ctx, req, role, diff.renames, false); cleanupErr != nil {
+			backend.Logger().Warn(
+				"unable to clean up renamed permission targets for role.",
+				"role_name", role.Name, "errors", cleanupErr)
+			return []string{cleanupErr.Error()}, nil
+		}
+	}
+
+	if oldGroupRole != nil {
+		backend.Logger().Debug("removing renamed group", "name", groupName(oldGroupRole), "role_name", role.Name)
+		if cleanupErr := backend.tryDeleteRoleResources(ctx, req, oldGroupRole, nil, true); cleanupErr != nil {
+			backend.Logger().Warn(
+				"unable to clean up renamed group for role.",
+				"role_name", role.Name, "errors", cleanupErr)
+			return []string{cleanupErr.Error()}, nil
+		}
+	}
+
 	return nil, nil
 }
 
+// migrateRoleObjectNames renames the Artifactory objects of roles saved before stable
+// permission target names were introduced, or named with another object prefix than the
+// mount's. It returns the roles that failed to migrate, which are retried on the next run.
+func (backend *ArtifactoryBackend) migrateRoleObjectNames(ctx context.Context, storage logical.Storage) ([]string, error) {
+	objectPrefix, err := backend.objectPrefix(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	roleNames, err := backend.listRoleEntries(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	var failed []string
+	req := &logical.Request{Storage: storage}
+	for _, roleName := range roleNames {
+		if err := backend.migrateRoleObjectName(ctx, req, roleName, objectPrefix); err != nil {
+			backend.Logger().Warn("unable to migrate artifactory object names of role", "role_name", roleName, "error", err)
+			failed = append(failed, roleName)
+		}
+	}
+
+	return failed, nil
+}
+
+func (backend *ArtifactoryBackend) migrateRoleObjectName(ctx context.Context, req *logical.Request, roleName, objectPrefix string) error {
+	lock := backend.roleLock(roleName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	role, err := getRoleEntry(ctx, req.Storage, roleName)
+	if err != nil {
+		return err
+	}
+	if role == nil || len(role.PermissionTargets) == 0 {
+		return nil
+	}
+	if len(role.PermissionTargetNames) == len(role.PermissionTargets) && role.ObjectPrefix == objectPrefix {
+		return nil
+	}
+
+	backend.Logger().Info("migrating artifactory object names of role", "role_name", roleName, "object_prefix", objectPrefix)
+	role.migration = true
+	warnings, err := backend.saveRoleWithNewPermissionTargets(ctx, req, role, role.PermissionTargets)
+	if err != nil {
+		return err
+	}
+	if len(warnings) > 0 {
+		return fmt.Errorf("%v", warnings)
+	}
+	return nil
+}
+
 // deleteRoleEntry will remove the role with specified name from storage
 func (backend *ArtifactoryBackend) deleteRoleEntry(ctx context.Context, storage logical.Storage, roleName string) error {
 	if roleName == "" {
 		return fmt.Errorf("missing role name")
 	}
 
-	return storage.Delete(ctx, fmt.Sprintf("%s/%s", rolesPrefix, roleName))
+	if err := storage.Delete(ctx, fmt.Sprintf("%s/%s", rolesPrefix, roleName)); err != nil {
+		return err
+	}
+	return deleteTokenUsageEntry(ctx, storage, roleName)
 }
 
 // getRoleEntry fetches a role from the storage
@@ -158,7 +534,7 @@ func getRoleEntry(ctx context.Context, storage logical.Storage, roleName string)
 		return nil, err
 	} else if entry == nil {
 		return nil, nil
-	} else if err := entry.DecodeJSON(&result); err != nil {
+	} else if err := decodeEntry(entry, upgradeRole, &result); err != nil {
 		return nil, err
 	}
 
@@ -174,8 +550,8 @@ func (backend *ArtifactoryBackend) listRoleEntries(ctx context.Context, storage
 	return roles, nil
 }
 
-func (backend *ArtifactoryBackend) tryDeleteRoleResources(ctx context.Context, req *logical.Request, role *RoleStorageEntry, pts []PermissionTarget, offset int, deleteGroup bool) error {
-	if len(pts) == 0 {
+func (backend *ArtifactoryBackend) tryDeleteRoleResources(ctx context.Context, req *logical.Request, role *RoleStorageEntry, ptNames []string, deleteGroup bool) error {
+	if len(ptNames) == 0 {
 		backend.Logger().Debug("skip deletion for empty permission targets")
 	}
 
@@ -193,12 +569,18 @@ func (backend *ArtifactoryBackend) tryDeleteRoleResources(ctx context.Context, r
 		}
 	}
 
-	for idx := range pts {
-		ptName := permissionTargetName(role.Name, idx+offset)
+	parallel, err := backend.parallelRequests(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+	if err := runParallel(parallel, ptNames, func(ptName string) error {
 		backend.Logger().Info("Deleting permission target from artifactory", "name", ptName, "role_name", role.Name)
 		if err := ac.DeletePermissionTarget(ptName); err != nil {
-			merr = multierror.Append(merr, fmt.Errorf("failed to delete a permission target %s for role %s - %s", ptName, role.Name, err.Error()))
+			return fmt.Errorf("failed to delete a permission target %s for role %s - %s", ptName, role.Name, err.Error())
 		}
+		return nil
+	}); err != nil {
+		merr = multierror.Append(merr, err)
 	}
 
 	return merr.ErrorOrNil()
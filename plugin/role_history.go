@@ -0,0 +1,118 @@
+// Copyright  2024 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	roleHistoryPrefix = "roles-history"
+
+	// roleHistorySize is the number of revisions kept per role
+	roleHistorySize = 10
+)
+
+// RoleRevision is a past definition of a role
+type RoleRevision struct {
+	Version int `json:"version"`
+
+	// CreatedAt is when the revision was saved
+	CreatedAt time.Time `json:"created_at"`
+
+	// EntityID is the identity entity of the requester, empty for root tokens and internal writes
+	EntityID string `json:"entity_id,omitempty"`
+
+	Role RoleStorageEntry `json:"role"`
+}
+
+func roleRevisionKey(roleName string, version int) string {
+	return fmt.Sprintf("%s/%s/%d", roleHistoryPrefix, roleName, version)
+}
+
+// listRoleRevisionVersions returns the versions of the recorded revisions of a role, oldest first
+func listRoleRevisionVersions(ctx context.Context, storage logical.Storage, roleName string) ([]int, error) {
+	keys, err := storage.List(ctx, fmt.Sprintf("%s/%s/", roleHistoryPrefix, roleName))
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int, 0, len(keys))
+	for _, key := range keys {
+		version, err := strconv.Atoi(key)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+// saveRoleRevision records the role as the revision of its version and prunes the revisions
+// beyond roleHistorySize
+func saveRoleRevision(ctx context.Context, req *logical.Request, role RoleStorageEntry) error {
+	versions, err := listRoleRevisionVersions(ctx, req.Storage, role.Name)
+	if err != nil {
+		return err
+	}
+
+	version := role.Version
+
+	revision := RoleRevision{
+		Version:   version,
+		CreatedAt: time.Now().UTC(),
+		EntityID:  req.EntityID,
+		Role:      role,
+	}
+	entry, err := logical.StorageEntryJSON(roleRevisionKey(role.Name, version), revision)
+	if err != nil {
+		return err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return err
+	}
+
+	var merr *multierror.Error
+	versions = append(versions, version)
+	for len(versions) > roleHistorySize {
+		if err := req.Storage.Delete(ctx, roleRevisionKey(role.Name, versions[0])); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+		versions = versions[1:]
+	}
+	return merr.ErrorOrNil()
+}
+
+// getRoleRevision fetches a revision of a role from the storage
+func getRoleRevision(ctx context.Context, storage logical.Storage, roleName string, version int) (*RoleRevision, error) {
+	var result RoleRevision
+	if entry, err := storage.Get(ctx, roleRevisionKey(roleName, version)); err != nil {
+		return nil, err
+	} else if entry == nil {
+		return nil, nil
+	} else if err := decodeEntry(entry, upgradeRoleRevision, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,193 @@
+// Copyright  2024 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	roleTemplatesPrefix = "role-templates"
+)
+
+// RoleTemplateEntry holds permission targets, groups and TTL defaults shared by the roles
+// inheriting from it
+type RoleTemplateEntry struct {
+	Name string `json:"name"`
+
+	// TokenTTL and MaxTTL are the defaults of inheriting roles, zero when unset
+	TokenTTL time.Duration `json:"token_ttl,omitempty"`
+	MaxTTL   time.Duration `json:"max_ttl,omitempty"`
+
+	Groups []string `json:"groups,omitempty"`
+
+	RawPermissionTargets string             `json:"raw_permission_targets,omitempty"`
+	PermissionTargets    []PermissionTarget `json:"permission_targets,omitempty"`
+}
+
+// validate checks whether a template has been populated properly before saving
+func (template RoleTemplateEntry) validate() error {
+	var err *multierror.Error
+	if template.Name == "" {
+		err = multierror.Append(err, errors.New("template name is empty"))
+	}
+	if len(template.Groups) == 0 && len(template.PermissionTargets) == 0 {
+		err = multierror.Append(err, errors.New("permission targets and/or groups are required"))
+	}
+	if template.MaxTTL > 0 && template.TokenTTL > template.MaxTTL {
+		err = multierror.Append(err, fmt.Errorf("template token ttl is greater than template max ttl '%d'", template.MaxTTL))
+	}
+	return err.ErrorOrNil()
+}
+
+// save saves a template to storage
+func (template RoleTemplateEntry) save(ctx context.Context, storage logical.Storage) error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", roleTemplatesPrefix, template.Name), template)
+	if err != nil {
+		return err
+	}
+
+	return storage.Put(ctx, entry)
+}
+
+// getRoleTemplateEntry fetches a template from the storage
+func getRoleTemplateEntry(ctx context.Context, storage logical.Storage, templateName string) (*RoleTemplateEntry, error) {
+	var result RoleTemplateEntry
+	if entry, err := storage.Get(ctx, fmt.Sprintf("%s/%s", roleTemplatesPrefix, templateName)); err != nil {
+		return nil, err
+	} else if entry == nil {
+		return nil, nil
+	} else if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// getRoleTemplateEntries fetches the templates a role inherits from, in order. All missing
+// templates are reported together.
+func getRoleTemplateEntries(ctx context.Context, storage logical.Storage, templateNames []string) ([]*RoleTemplateEntry, error) {
+	var merr *multierror.Error
+	templates := make([]*RoleTemplateEntry, 0, len(templateNames))
+	for _, templateName := range templateNames {
+		template, err := getRoleTemplateEntry(ctx, storage, templateName)
+		if err != nil {
+			return nil, err
+		}
+		if template == nil {
+			merr = multierror.Append(merr, fmt.Errorf("role template '%s' does not exist", templateName))
+			continue
+		}
+		templates = append(templates, template)
+	}
+
+	return templates, merr.ErrorOrNil()
+}
+
+// deleteRoleTemplateEntry will remove the template with specified name from storage
+func deleteRoleTemplateEntry(ctx context.Context, storage logical.Storage, templateName string) error {
+	return storage.Delete(ctx, fmt.Sprintf("%s/%s", roleTemplatesPrefix, templateName))
+}
+
+// listRoleTemplateEntries gets all the templates
+func listRoleTemplateEntries(ctx context.Context, storage logical.Storage) ([]string, error) {
+	return storage.List(ctx, fmt.Sprintf("%s/", roleTemplatesPrefix))
+}
+
+// listDependentRoles returns the names of the roles inheriting from a template
+func (backend *ArtifactoryBackend) listDependentRoles(ctx context.Context, storage logical.Storage, templateName string) ([]string, error) {
+	roleNames, err := backend.listRoleEntries(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	var dependents []string
+	for _, roleName := range roleNames {
+		role, err := getRoleEntry(ctx, storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil && slices.Contains(role.Inherits, templateName) {
+			dependents = append(dependents, roleName)
+		}
+	}
+	return dependents, nil
+}
+
+// inheritPermissionTargets computes the effective permission targets of a role: the
+// permission targets of its templates in order, followed by its own. Targets identical to
+// an earlier one are only kept once.
+func inheritPermissionTargets(templates []*RoleTemplateEntry, own []PermissionTarget) ([]PermissionTarget, error) {
+	var effective []PermissionTarget
+	add := func(pt PermissionTarget) {
+		for _, existing := range effective {
+			if existing.equal(pt) {
+				return
+			}
+		}
+		effective = append(effective, pt)
+	}
+
+	for _, template := range templates {
+		for _, pt := range template.PermissionTargets {
+			add(pt)
+		}
+	}
+	for _, pt := range own {
+		add(pt)
+	}
+
+	if err := validatePermissionTargetIdentities(effective); err != nil {
+		return nil, fmt.Errorf("conflicting inherited permission targets - %s", err.Error())
+	}
+	return effective, nil
+}
+
+// inheritGroups returns the static groups of the templates that are not already part of own
+func inheritGroups(templates []*RoleTemplateEntry, own []string) []string {
+	var groups []string
+	for _, template := range templates {
+		for _, group := range template.Groups {
+			if !slices.Contains(own, group) && !slices.Contains(groups, group) {
+				groups = append(groups, group)
+			}
+		}
+	}
+	return groups
+}
+
+// inheritTTLs returns the first token and max TTL defaults set by the templates
+func inheritTTLs(templates []*RoleTemplateEntry) (tokenTTL, maxTTL time.Duration) {
+	for _, template := range templates {
+		if tokenTTL == 0 {
+			tokenTTL = template.TokenTTL
+		}
+		if maxTTL == 0 {
+			maxTTL = template.MaxTTL
+		}
+	}
+	return tokenTTL, maxTTL
+}
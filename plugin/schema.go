@@ -0,0 +1,229 @@
+// Copyright  2024 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// schemaMigration upgrades a stored entry, decoded as a map, to the next schema version.
+// Entries without a schema_version are at version 0.
+type schemaMigration func(raw map[string]interface{})
+
+// configMigrations upgrade a config from the schema version of their index to the next one
+var configMigrations = []schemaMigration{
+	// 0 -> 1: configs saved before max_parallel_requests apply permission targets sequentially
+	func(raw map[string]interface{}) {
+		if n, ok := raw["max_parallel_requests"].(json.Number); !ok || n.String() == "0" {
+			raw["max_parallel_requests"] = 1
+		}
+	},
+	// 1 -> 2: configs saved before expiry_warning_window warn a week before bearer_token expires
+	func(raw map[string]interface{}) {
+		if n, ok := raw["expiry_warning_window"].(json.Number); !ok || n.String() == "0" {
+			raw["expiry_warning_window"] = time.Duration(configSchema["expiry_warning_window"].Default.(int)) * time.Second
+		}
+	},
+}
+
+// roleMigrations upgrade a role from the schema version of their index to the next one
+var roleMigrations = []schemaMigration{
+	// 0 -> 1: the permission targets were stored under their Go field names
+	func(raw map[string]interface{}) {
+		renameKey(raw, "RawPermissionTargets", "raw_permission_targets")
+		renameKey(raw, "PermissionTargets", "permission_targets")
+	},
+	// 1 -> 2: the TTLs of roles saved before explicit TTLs were kept are taken as explicit,
+	// inherited ones included, as template changes never reached them
+	func(raw map[string]interface{}) {
+		if _, ok := raw["explicit_token_ttl"]; !ok {
+			raw["explicit_token_ttl"] = raw["token_ttl"]
+		}
+		if _, ok := raw["explicit_max_ttl"]; !ok {
+			raw["explicit_max_ttl"] = raw["max_ttl"]
+		}
+	},
+}
+
+var (
+	configSchemaVersion = len(configMigrations)
+	roleSchemaVersion   = len(roleMigrations)
+)
+
+func renameKey(raw map[string]interface{}, from, to string) {
+	if value, ok := raw[from]; ok {
+		delete(raw, from)
+		raw[to] = value
+	}
+}
+
+func schemaVersion(raw map[string]interface{}) (int, error) {
+	switch v := raw["schema_version"].(type) {
+	case nil:
+		return 0, nil
+	case json.Number:
+		return strconv.Atoi(v.String())
+	default:
+		return 0, fmt.Errorf("invalid schema_version %v", v)
+	}
+}
+
+// upgradeSchema applies the migrations missing from an entry. It reports whether the entry
+// was upgraded.
+func upgradeSchema(raw map[string]interface{}, migrations []schemaMigration) (bool, error) {
+	version, err := schemaVersion(raw)
+	if err != nil {
+		return false, err
+	}
+	if version > len(migrations) {
+		return false, fmt.Errorf("schema version %d is newer than the supported version %d", version, len(migrations))
+	}
+	if version == len(migrations) {
+		return false, nil
+	}
+
+	for _, migrate := range migrations[version:] {
+		migrate(raw)
+	}
+	raw["schema_version"] = len(migrations)
+	return true, nil
+}
+
+// decodeJSON decodes numbers as json.Number so that they survive re-encoding exactly
+func decodeJSON(data []byte) (map[string]interface{}, error) {
+	raw := map[string]interface{}{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// upgradeEntry returns a stored entry upgraded to the current schema, nil if it is up to date
+func upgradeEntry(data []byte, migrations []schemaMigration) ([]byte, error) {
+	raw, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	if upgraded, err := upgradeSchema(raw, migrations); err != nil || !upgraded {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// upgradeRoleRevision returns a stored revision with its role upgraded to the current schema,
+// nil if it is up to date
+func upgradeRoleRevision(data []byte) ([]byte, error) {
+	raw, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	role, ok := raw["role"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("role revision has no role")
+	}
+	if upgraded, err := upgradeSchema(role, roleMigrations); err != nil || !upgraded {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// decodeEntry decodes a stored entry into out, upgrading it to the current schema first
+func decodeEntry(entry *logical.StorageEntry, upgrade func(data []byte) ([]byte, error), out interface{}) error {
+	upgraded, err := upgrade(entry.Value)
+	if err != nil {
+		return err
+	}
+	if upgraded != nil {
+		return json.Unmarshal(upgraded, out)
+	}
+	return entry.DecodeJSON(out)
+}
+
+func upgradeConfig(data []byte) ([]byte, error) { return upgradeEntry(data, configMigrations) }
+func upgradeRole(data []byte) ([]byte, error)   { return upgradeEntry(data, roleMigrations) }
+
+// migrateStorageSchema upgrades the config, the roles and their revisions to the current
+// schema in place. Each entry is upgraded on its own, so an interrupted migration resumes
+// with the entries left behind on the next run. Entries are upgraded on read in the
+// meantime.
+func (backend *ArtifactoryBackend) migrateStorageSchema(ctx context.Context, storage logical.Storage) error {
+	var merr *multierror.Error
+	if err := backend.migrateEntrySchema(ctx, storage, configPrefix, upgradeConfig); err != nil {
+		merr = multierror.Append(merr, err)
+	}
+
+	roleNames, err := backend.listRoleEntries(ctx, storage)
+	if err != nil {
+		return multierror.Append(merr, err)
+	}
+	for _, roleName := range roleNames {
+		if err := backend.migrateEntrySchema(ctx, storage, fmt.Sprintf("%s/%s", rolesPrefix, roleName), upgradeRole); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+
+	historyRoleNames, err := storage.List(ctx, roleHistoryPrefix+"/")
+	if err != nil {
+		return multierror.Append(merr, err)
+	}
+	for _, roleName := range historyRoleNames {
+		// role names are listed as "<name>/"
+		roleName = roleName[:len(roleName)-1]
+		versions, err := listRoleRevisionVersions(ctx, storage, roleName)
+		if err != nil {
+			merr = multierror.Append(merr, err)
+			continue
+		}
+		for _, version := range versions {
+			if err := backend.migrateEntrySchema(ctx, storage, roleRevisionKey(roleName, version), upgradeRoleRevision); err != nil {
+				merr = multierror.Append(merr, err)
+			}
+		}
+	}
+
+	return merr.ErrorOrNil()
+}
+
+// migrateEntrySchema writes back the entry at key if it isn't at the current schema
+func (backend *ArtifactoryBackend) migrateEntrySchema(ctx context.Context, storage logical.Storage, key string, upgrade func(data []byte) ([]byte, error)) error {
+	entry, err := storage.Get(ctx, key)
+	if err != nil || entry == nil {
+		return err
+	}
+
+	upgraded, err := upgrade(entry.Value)
+	if err != nil {
+		return fmt.Errorf("failed to upgrade the schema of %s - %s", key, err.Error())
+	}
+	if upgraded == nil {
+		return nil
+	}
+
+	if err := storage.Put(ctx, &logical.StorageEntry{Key: key, Value: upgraded}); err != nil {
+		return fmt.Errorf("failed to save the upgraded %s - %s", key, err.Error())
+	}
+	backend.Logger().Info("upgraded the schema of storage entry", "key", key)
+	return nil
+}
@@ -0,0 +1,252 @@
+// Copyright  2024 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// historical on-disk formats of roles
+const (
+	// roleFormatBaseline is a role saved by the first releases
+	roleFormatBaseline = `{
+		"role_id": "0123456789abcdef0123456789abcdef",
+		"token_ttl": 600000000000,
+		"max_ttl": 3600000000000,
+		"name": "legacy",
+		"groups": ["static"],
+		"RawPermissionTargets": "[{\"repo\": {\"repositories\": [\"ANY\"], \"operations\": [\"read\"]}}]",
+		"PermissionTargets": [{"repo": {"repositories": ["ANY"], "operations": ["read"]}}]
+	}`
+
+	// roleFormatStableNames is a role saved with stable permission target names, object
+	// prefixes and versions, before the permission targets had JSON tags
+	roleFormatStableNames = `{
+		"role_id": "0123456789abcdef0123456789abcdef",
+		"token_ttl": 600000000000,
+		"max_ttl": 3600000000000,
+		"name": "legacy",
+		"groups": ["static"],
+		"docker_registries": ["registry.example.com"],
+		"max_active_tokens": 5,
+		"RawPermissionTargets": "[{\"repo\": {\"repositories\": [\"ANY\"], \"operations\": [\"read\"]}}]",
+		"PermissionTargets": [{"repo": {"repositories": ["ANY"], "operations": ["read"]}}],
+		"permission_target_names": ["vault-plugin.mount1.pt-3aac6d93dd50.legacy"],
+		"object_prefix": "mount1",
+		"version": 7
+	}`
+)
+
+// historical on-disk formats of the config
+const (
+	// configFormatBaseline is a config saved by the first releases
+	configFormatBaseline = `{
+		"base_url": "https://example.jfrog.io/",
+		"bearer_token": "mybearertoken",
+		"username": "",
+		"password": "",
+		"max_ttl": 3600000000000,
+		"client_timeout": 30000000000
+	}`
+
+	// configFormatObjectPrefix is a config saved with parallel requests and an object prefix
+	configFormatObjectPrefix = `{
+		"base_url": "https://example.jfrog.io/",
+		"bearer_token": "mybearertoken",
+		"username": "",
+		"password": "",
+		"max_ttl": 3600000000000,
+		"client_timeout": 30000000000,
+		"max_parallel_requests": 4,
+		"object_prefix": "mount1"
+	}`
+)
+
+func TestUpgradeRoleSchema(t *testing.T) {
+	t.Parallel()
+
+	expectedPts := []PermissionTarget{{Repo: &Permission{Repositories: []string{"ANY"}, Operations: []string{"read"}}}}
+	tests := []struct {
+		name     string
+		stored   string
+		asserter func(t *testing.T, role *RoleStorageEntry)
+	}{
+		{
+			name:   "baseline",
+			stored: roleFormatBaseline,
+			asserter: func(t *testing.T, role *RoleStorageEntry) {
+				assert.Equal(t, 600*time.Second, role.TokenTTL)
+				assert.Equal(t, 600*time.Second, role.ExplicitTokenTTL, "stored TTLs are kept as explicit")
+				assert.Equal(t, time.Hour, role.ExplicitMaxTTL)
+				assert.Equal(t, []string{"static"}, role.Groups)
+				assert.Equal(t, []string{"vault-plugin.pt0.legacy"}, role.permissionTargetNames())
+			},
+		},
+		{
+			name:   "stable_names",
+			stored: roleFormatStableNames,
+			asserter: func(t *testing.T, role *RoleStorageEntry) {
+				assert.Equal(t, []string{"registry.example.com"}, role.DockerRegistries)
+				assert.Equal(t, 5, role.MaxActiveTokens)
+				assert.Equal(t, []string{"vault-plugin.mount1.pt-3aac6d93dd50.legacy"}, role.PermissionTargetNames)
+				assert.Equal(t, "mount1", role.ObjectPrefix)
+				assert.Equal(t, 7, role.Version)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			storage := &logical.InmemStorage{}
+			require.NoError(t, storage.Put(ctx, &logical.StorageEntry{Key: "roles/legacy", Value: []byte(test.stored)}))
+
+			// entries are upgraded on read before being migrated
+			role, err := getRoleEntry(ctx, storage, "legacy")
+			require.NoError(t, err)
+			require.NotNil(t, role)
+			assert.Equal(t, roleSchemaVersion, role.SchemaVersion)
+			assert.Equal(t, expectedPts, role.PermissionTargets)
+			assert.Contains(t, role.RawPermissionTargets, `"ANY"`)
+			test.asserter(t, role)
+
+			backend := Backend(logical.TestBackendConfig())
+			require.NoError(t, backend.migrateStorageSchema(ctx, storage))
+
+			entry, err := storage.Get(ctx, "roles/legacy")
+			require.NoError(t, err)
+			raw, err := decodeJSON(entry.Value)
+			require.NoError(t, err)
+			assert.NotContains(t, raw, "RawPermissionTargets")
+			assert.NotContains(t, raw, "PermissionTargets")
+			assert.Contains(t, raw, "raw_permission_targets")
+
+			migrated, err := getRoleEntry(ctx, storage, "legacy")
+			require.NoError(t, err)
+			assert.Equal(t, role, migrated)
+
+			// migrating again is a no-op
+			require.NoError(t, backend.migrateStorageSchema(ctx, storage))
+			again, err := storage.Get(ctx, "roles/legacy")
+			require.NoError(t, err)
+			assert.Equal(t, entry.Value, again.Value)
+		})
+	}
+}
+
+func TestUpgradeConfigSchema(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		stored   string
+		expected ConfigStorageEntry
+	}{
+		{
+			name:   "baseline",
+			stored: configFormatBaseline,
+			expected: ConfigStorageEntry{
+				BaseURL:             "https://example.jfrog.io/",
+				BearerToken:         "mybearertoken",
+				MaxTTL:              time.Hour,
+				ClientTimeout:       30 * time.Second,
+				MaxParallelRequests: 1,
+				ExpiryWarningWindow: 7 * 24 * time.Hour,
+				SchemaVersion:       configSchemaVersion,
+			},
+		},
+		{
+			name:   "object_prefix",
+			stored: configFormatObjectPrefix,
+			expected: ConfigStorageEntry{
+				BaseURL:             "https://example.jfrog.io/",
+				BearerToken:         "mybearertoken",
+				MaxTTL:              time.Hour,
+				ClientTimeout:       30 * time.Second,
+				MaxParallelRequests: 4,
+				ObjectPrefix:        "mount1",
+				ExpiryWarningWindow: 7 * 24 * time.Hour,
+				SchemaVersion:       configSchemaVersion,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			storage := &logical.InmemStorage{}
+			require.NoError(t, storage.Put(ctx, &logical.StorageEntry{Key: configPrefix, Value: []byte(test.stored)}))
+
+			backend := Backend(logical.TestBackendConfig())
+			cfg, err := backend.getConfig(ctx, storage)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, *cfg)
+
+			require.NoError(t, backend.migrateStorageSchema(ctx, storage))
+			entry, err := storage.Get(ctx, configPrefix)
+			require.NoError(t, err)
+			raw, err := decodeJSON(entry.Value)
+			require.NoError(t, err)
+			assert.Equal(t, json.Number(strconv.Itoa(configSchemaVersion)), raw["schema_version"])
+
+			migrated, err := backend.getConfig(ctx, storage)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, *migrated)
+		})
+	}
+}
+
+func TestUpgradeRoleRevisionSchema(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+
+	stored := `{"version": 1, "created_at": "2024-05-01T10:00:00Z", "role": ` + roleFormatBaseline + `}`
+	require.NoError(t, storage.Put(ctx, &logical.StorageEntry{Key: roleRevisionKey("legacy", 1), Value: []byte(stored)}))
+
+	backend := Backend(logical.TestBackendConfig())
+	require.NoError(t, backend.migrateStorageSchema(ctx, storage))
+
+	revision, err := getRoleRevision(ctx, storage, "legacy", 1)
+	require.NoError(t, err)
+	require.NotNil(t, revision)
+	assert.Equal(t, 1, revision.Version)
+	assert.Equal(t, roleSchemaVersion, revision.Role.SchemaVersion)
+	assert.Len(t, revision.Role.PermissionTargets, 1)
+	assert.Contains(t, revision.Role.RawPermissionTargets, `"ANY"`)
+}
+
+func TestUpgradeSchemaNewerVersion(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	require.NoError(t, storage.Put(ctx, &logical.StorageEntry{Key: "roles/future", Value: []byte(`{"name": "future", "schema_version": 99}`)}))
+
+	_, err := getRoleEntry(ctx, storage, "future")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "newer than the supported version")
+}
@@ -16,14 +16,21 @@ package artifactorysecrets
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"time"
 
+	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
 const (
 	tokenPrefix = "token"
+
+	tokenFormatDockerConfigJSON = "dockerconfigjson"
+	secretDockerConfigJSONType  = "artifactory_dockerconfigjson"
+	dockerConfigJSONKey         = ".dockerconfigjson"
 )
 
 // TokenCreateEntry is the structure for creating a token
@@ -45,7 +52,93 @@ func (backend *ArtifactoryBackend) createTokenEntry(ctx context.Context, storage
 	tokenOutput := map[string]interface{}{
 		"access_token": token.AccessToken,
 		"username":     tokenUsername(roleEntry.Name),
+		"token_id":     token.TokenId,
+	}
+	if token.ReferenceToken != "" {
+		tokenOutput["reference_token"] = token.ReferenceToken
 	}
 
 	return tokenOutput, nil
 }
+
+// dockerConfigAuth is a single registry entry of a .dockerconfigjson payload
+type dockerConfigAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+// dockerConfigJSON is the payload of a kubernetes.io/dockerconfigjson secret
+type dockerConfigJSON struct {
+	Auths map[string]dockerConfigAuth `json:"auths"`
+}
+
+// newDockerConfigJSON builds a .dockerconfigjson payload authenticating with the same
+// username and access token against every given registry host.
+func newDockerConfigJSON(registries []string, username, accessToken string) (string, error) {
+	cfg := dockerConfigJSON{
+		Auths: make(map[string]dockerConfigAuth, len(registries)),
+	}
+	for _, registry := range registries {
+		cfg.Auths[registry] = dockerConfigAuth{
+			Username: username,
+			Password: accessToken,
+			Auth:     base64.StdEncoding.EncodeToString([]byte(username + ":" + accessToken)),
+		}
+	}
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// secretDockerConfigJSON is leased so that consumers such as Vault Secrets Operator
+// know when to rotate the generated pull secret.
+func secretDockerConfigJSON(backend *ArtifactoryBackend) *framework.Secret {
+	return &framework.Secret{
+		Type: secretDockerConfigJSONType,
+		Fields: map[string]*framework.FieldSchema{
+			dockerConfigJSONKey: {
+				Type:        framework.TypeString,
+				Description: "Docker config JSON with registry auths for the generated access token",
+			},
+		},
+		Revoke: backend.secretDockerConfigJSONRevoke,
+	}
+}
+
+// secretDockerConfigJSONRevoke revokes the access token of the lease in Artifactory
+// and removes its issued token record. Leases created before the token ID was kept
+// in the internal data only expire.
+func (backend *ArtifactoryBackend) secretDockerConfigJSONRevoke(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	tokenID, _ := req.Secret.InternalData["token_id"].(string)
+	roleName, _ := req.Secret.InternalData["role_name"].(string)
+	if tokenID == "" {
+		return nil, nil
+	}
+
+	token, err := getIssuedTokenEntry(ctx, req.Storage, roleName, tokenID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read issued token - %w", err)
+	}
+	if token != nil {
+		role, err := getRoleEntry(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read role - %w", err)
+		}
+		return nil, backend.revokeIssuedToken(ctx, req.Storage, role, token)
+	}
+
+	// the record is gone when the token was revoked through the issued tokens
+	// path or wasn't recorded, Artifactory ignores unknown token IDs
+	ac, err := backend.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain artifactory client - %s", err.Error())
+	}
+	if err := ac.RevokeToken(tokenID); err != nil {
+		return nil, fmt.Errorf("failed to revoke token %s - %s", tokenID, err.Error())
+	}
+	return nil, nil
+}
@@ -0,0 +1,195 @@
+// Copyright  2024 Splunk, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifactorysecrets
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	tokenUsagePrefix = "token-usage"
+)
+
+// TokenUsageEntry tracks the tokens issued under a role to enforce its quotas
+type TokenUsageEntry struct {
+	// ActiveTokenExpirations are the expiry times of the issued tokens that haven't expired yet
+	ActiveTokenExpirations []time.Time `json:"active_token_expirations,omitempty"`
+
+	// IssueBucket is the number of tokens left in the issuance token bucket as of BucketUpdatedAt
+	IssueBucket     float64   `json:"issue_bucket"`
+	BucketUpdatedAt time.Time `json:"bucket_updated_at"`
+}
+
+// prune drops the expired tokens and refills the issuance bucket of a role allowing
+// maxIssueRate tokens per minute
+func (usage *TokenUsageEntry) prune(now time.Time, maxIssueRate int) {
+	active := usage.ActiveTokenExpirations[:0]
+	for _, expiry := range usage.ActiveTokenExpirations {
+		if expiry.After(now) {
+			active = append(active, expiry)
+		}
+	}
+	usage.ActiveTokenExpirations = active
+
+	// a bucket enabled later starts full
+	if maxIssueRate <= 0 {
+		usage.IssueBucket = 0
+		usage.BucketUpdatedAt = time.Time{}
+		return
+	}
+
+	capacity := float64(maxIssueRate)
+	if usage.BucketUpdatedAt.IsZero() {
+		usage.IssueBucket = capacity
+	} else {
+		refill := now.Sub(usage.BucketUpdatedAt).Minutes() * capacity
+		usage.IssueBucket = math.Min(capacity, usage.IssueBucket+refill)
+	}
+	usage.BucketUpdatedAt = now
+}
+
+func tokenUsageKey(roleName string) string {
+	return fmt.Sprintf("%s/%s", tokenUsagePrefix, roleName)
+}
+
+// getTokenUsageEntry fetches the token usage of a role, pruned as of now
+func getTokenUsageEntry(ctx context.Context, storage logical.Storage, role *RoleStorageEntry, now time.Time) (*TokenUsageEntry, error) {
+	var usage TokenUsageEntry
+	if entry, err := storage.Get(ctx, tokenUsageKey(role.Name)); err != nil {
+		return nil, err
+	} else if entry != nil {
+		if err := entry.DecodeJSON(&usage); err != nil {
+			return nil, err
+		}
+	}
+
+	usage.prune(now, role.MaxIssueRate)
+	return &usage, nil
+}
+
+func (usage TokenUsageEntry) save(ctx context.Context, storage logical.Storage, roleName string) error {
+	entry, err := logical.StorageEntryJSON(tokenUsageKey(roleName), usage)
+	if err != nil {
+		return err
+	}
+
+	return storage.Put(ctx, entry)
+}
+
+// deleteTokenUsageEntry removes the token usage of a role
+func deleteTokenUsageEntry(ctx context.Context, storage logical.Storage, roleName string) error {
+	return storage.Delete(ctx, tokenUsageKey(roleName))
+}
+
+// tokenUsageLock returns the lock serializing the token issuance of a role
+func (backend *ArtifactoryBackend) tokenUsageLock(roleName string) *locksutil.LockEntry {
+	return locksutil.LockForKey(backend.tokenLocks, roleName)
+}
+
+// hasTokenQuotas reports whether the token issuance of the role is limited. Token
+// usage is only tracked for roles with quotas.
+func (role *RoleStorageEntry) hasTokenQuotas() bool {
+	return role.MaxActiveTokens > 0 || role.MaxIssueRate > 0
+}
+
+// reserveTokenSlot checks the quotas of the role and records a token expiring at
+// expiry in its usage. The usage lock is only held for the storage round trip so
+// that the token request to Artifactory doesn't serialize the issuance of a role.
+func (backend *ArtifactoryBackend) reserveTokenSlot(ctx context.Context, storage logical.Storage, role *RoleStorageEntry, now, expiry time.Time) error {
+	lock := backend.tokenUsageLock(role.Name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	usage, err := getTokenUsageEntry(ctx, storage, role, now)
+	if err != nil {
+		return fmt.Errorf("failed to read token usage of role - %w", err)
+	}
+	if err := checkTokenQuotas(role, usage); err != nil {
+		return err
+	}
+	usage.recordIssuedToken(role, expiry)
+	if err := usage.save(ctx, storage, role.Name); err != nil {
+		return fmt.Errorf("failed to record token usage of role - %w", err)
+	}
+	return nil
+}
+
+// releaseTokenSlot gives back a slot reserved by reserveTokenSlot for a token that
+// failed to be issued
+func (backend *ArtifactoryBackend) releaseTokenSlot(ctx context.Context, storage logical.Storage, role *RoleStorageEntry, expiry time.Time) error {
+	lock := backend.tokenUsageLock(role.Name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	usage, err := getTokenUsageEntry(ctx, storage, role, time.Now())
+	if err != nil {
+		return err
+	}
+	usage.releaseToken(expiry)
+	if role.MaxIssueRate > 0 {
+		usage.IssueBucket = math.Min(float64(role.MaxIssueRate), usage.IssueBucket+1)
+	}
+	return usage.save(ctx, storage, role.Name)
+}
+
+// checkTokenQuotas returns an HTTP 429 error if issuing one more token would exceed
+// the quotas of the role
+func checkTokenQuotas(role *RoleStorageEntry, usage *TokenUsageEntry) error {
+	if role.MaxActiveTokens > 0 && len(usage.ActiveTokenExpirations) >= role.MaxActiveTokens {
+		return logical.CodedError(http.StatusTooManyRequests, fmt.Sprintf(
+			"role '%s' has reached its limit of %d active tokens", role.Name, role.MaxActiveTokens))
+	}
+	if role.MaxIssueRate > 0 && usage.IssueBucket < 1 {
+		return logical.CodedError(http.StatusTooManyRequests, fmt.Sprintf(
+			"role '%s' has reached its limit of %d tokens issued per minute", role.Name, role.MaxIssueRate))
+	}
+	return nil
+}
+
+// recordIssuedToken adds a token expiring at expiry to the usage
+func (usage *TokenUsageEntry) recordIssuedToken(role *RoleStorageEntry, expiry time.Time) {
+	usage.ActiveTokenExpirations = append(usage.ActiveTokenExpirations, expiry)
+	if role.MaxIssueRate > 0 {
+		usage.IssueBucket--
+	}
+}
+
+// releaseToken removes a revoked token expiring at expiry from the active tokens
+func (usage *TokenUsageEntry) releaseToken(expiry time.Time) {
+	for idx, active := range usage.ActiveTokenExpirations {
+		if active.Equal(expiry) {
+			usage.ActiveTokenExpirations = append(usage.ActiveTokenExpirations[:idx], usage.ActiveTokenExpirations[idx+1:]...)
+			return
+		}
+	}
+}
+
+// tokenUsageDetails describes the current usage of a role against its quotas
+func tokenUsageDetails(role *RoleStorageEntry, usage *TokenUsageEntry) map[string]interface{} {
+	details := map[string]interface{}{
+		"active_tokens": len(usage.ActiveTokenExpirations),
+	}
+	if role.MaxIssueRate > 0 {
+		details["remaining_issue_rate"] = int(math.Max(0, math.Floor(usage.IssueBucket)))
+	}
+	return details
+}
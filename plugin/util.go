@@ -16,11 +16,14 @@ package artifactorysecrets
 
 import (
 	"crypto/sha256"
-	"encoding/base64"
 	"fmt"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/go-version"
 	"github.com/jfrog/jfrog-client-go/artifactory/services"
 )
 
@@ -30,13 +33,69 @@ const (
 	tokenUsernameMaxLen  = 58
 	tokenUsernameHashLen = 8
 	roleIDHashLen        = 32
+	ptHashLen            = 12
+	objectPrefixHashLen  = 8
+
+	// minReferenceTokenVersion is the first Artifactory version issuing reference tokens
+	minReferenceTokenVersion = "7.38.10"
 )
 
+// objectNamePrefix is the common prefix of the Artifactory objects named with objectPrefix.
+// Objects created before object prefixes were introduced have no object prefix.
+func objectNamePrefix(objectPrefix string) string {
+	if objectPrefix == "" {
+		return pluginPrefix
+	}
+	return fmt.Sprintf("%s.%s", pluginPrefix, objectPrefix)
+}
+
+// defaultObjectPrefix derives the object prefix of a mount from its UUID and its mount
+// path, which Vault qualifies with the namespace of the mount (req.MountPoint)
+func defaultObjectPrefix(backendUUID, mountPath string) string {
+	if backendUUID == "" {
+		return ""
+	}
+	seed := backendUUID
+	if mountPath != "" {
+		seed += ":" + mountPath
+	}
+	hash := sha256.Sum256([]byte(seed))
+	return fmt.Sprintf("%x", hash)[:objectPrefixHashLen]
+}
+
 func groupName(roleEntry *RoleStorageEntry) string {
-	return fmt.Sprintf("%s.%s", pluginPrefix, roleEntry.RoleID)
+	return fmt.Sprintf("%s.%s", objectNamePrefix(roleEntry.ObjectPrefix), roleEntry.RoleID)
+}
+
+// tokenScope returns the scope of tokens issued for a role: the generated group, if
+// the role has permission targets, followed by the static groups.
+func tokenScope(roleEntry *RoleStorageEntry) string {
+	var groups []string
+	if len(roleEntry.PermissionTargets) > 0 {
+		groups = append(groups, groupName(roleEntry))
+	}
+	groups = append(groups, roleEntry.allGroups()...)
+
+	return fmt.Sprintf("applied-permissions/groups:%s", strings.Join(groups, ","))
+}
+
+// permissionTargetName names a permission target after its identity, so that the name is
+// independent from its position in the role
+func permissionTargetName(objectPrefix, roleName, id string) string {
+	return fmt.Sprintf("%s.pt-%s.%s", objectNamePrefix(objectPrefix), id, roleName)
 }
 
-func permissionTargetName(roleName string, index int) string {
+func permissionTargetNames(objectPrefix, roleName string, pts []PermissionTarget) []string {
+	names := make([]string, len(pts))
+	for idx, pt := range pts {
+		names[idx] = permissionTargetName(objectPrefix, roleName, pt.identity())
+	}
+	return names
+}
+
+// legacyPermissionTargetName is the index based name of permission targets saved before
+// stable naming was introduced
+func legacyPermissionTargetName(roleName string, index int) string {
 	return fmt.Sprintf("%s.pt%d.%s", pluginPrefix, index, roleName)
 }
 
@@ -110,24 +169,295 @@ func convertPermissionTarget(fromPt *PermissionTarget, toPt *services.Permission
 	toPt.Name = ptName
 }
 
-func validateOperations(ops []string) error {
+// operations are the permission target actions of the Artifactory V2 API, in the order
+// presets list them
+var operations = []string{"read", "annotate", "write", "delete", "manage", "managedXrayMeta", "distribute"}
+
+// operationAliases maps the other names of the actions, lower case, to their V2 name: the
+// single letters of the V1 API, the names of the Access permissions API and the UI
+var operationAliases = map[string]string{
+	"r":                    "read",
+	"n":                    "annotate",
+	"w":                    "write",
+	"deploy":               "write",
+	"d":                    "delete",
+	"m":                    "manage",
+	"mxm":                  "managedXrayMeta",
+	"managedxraymeta":      "managedXrayMeta",
+	"manage_xray_metadata": "managedXrayMeta",
+	"x":                    "distribute",
+}
+
+// builtinOperationPresets name common sets of operations, usable in place of operations
+var builtinOperationPresets = map[string][]string{
+	"reader":     {"read"},
+	"deployer":   {"read", "annotate", "write"},
+	"maintainer": {"read", "annotate", "write", "delete"},
+	"admin":      {"read", "annotate", "write", "delete", "manage", "managedXrayMeta"},
+}
+
+var operationPresetNameRegex = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// canonicalOperation returns the V2 name of an operation or one of its aliases, matched
+// regardless of case
+func canonicalOperation(op string) (string, bool) {
+	lower := strings.ToLower(op)
+	for _, canonical := range operations {
+		if lower == strings.ToLower(canonical) {
+			return canonical, true
+		}
+	}
+	canonical, ok := operationAliases[lower]
+	return canonical, ok
+}
+
+// normalizeOperations expands presets and aliases into V2 operations, dropping duplicates
+// and otherwise keeping the order of ops
+func normalizeOperations(ops []string, presets map[string][]string) ([]string, error) {
 	var err *multierror.Error
+	normalized := make([]string, 0, len(ops))
+	add := func(op string) {
+		if !slices.Contains(normalized, op) {
+			normalized = append(normalized, op)
+		}
+	}
 
 	for _, op := range ops {
-		switch op {
-		case "read", "write", "annotate",
-			"delete", "manage", "managedXrayMeta",
-			"distribute":
+		if canonical, ok := canonicalOperation(op); ok {
+			add(canonical)
 			continue
-		default:
+		}
+		preset, ok := presets[op]
+		if !ok {
 			err = multierror.Append(err, fmt.Errorf("operation '%s' is not allowed", op))
+			continue
+		}
+		for _, presetOp := range preset {
+			add(presetOp)
+		}
+	}
+
+	return normalized, err.ErrorOrNil()
+}
+
+// validateOperations checks that ops are operations or their aliases
+func validateOperations(ops []string) error {
+	_, err := normalizeOperations(ops, nil)
+	return err
+}
+
+// validateOperationPresets checks the names of mount presets and normalizes their operations.
+// Presets are made of operations, they can't refer to other presets.
+func validateOperationPresets(presets map[string][]string) error {
+	var err *multierror.Error
+
+	for name, ops := range presets {
+		if !operationPresetNameRegex.MatchString(name) {
+			err = multierror.Append(err, fmt.Errorf("operation preset name '%s' must be 1-32 lowercase alphanumeric, '-' or '_' characters, starting with a letter", name))
+			continue
+		}
+		if _, ok := canonicalOperation(name); ok {
+			err = multierror.Append(err, fmt.Errorf("operation preset name '%s' is an operation", name))
+			continue
+		}
+		if len(ops) == 0 {
+			err = multierror.Append(err, fmt.Errorf("operation preset '%s' has no operations", name))
+			continue
+		}
+		normalized, e := normalizeOperations(ops, nil)
+		if e != nil {
+			err = multierror.Append(err, fmt.Errorf("operation preset '%s': %w", name, e))
+			continue
+		}
+		presets[name] = normalized
+	}
+
+	return err.ErrorOrNil()
+}
+
+// validateDockerRegistries checks that registries are bare hostnames (with optional port)
+func validateDockerRegistries(registries []string) error {
+	var err *multierror.Error
+
+	for _, registry := range registries {
+		if registry == "" || strings.Contains(registry, "/") {
+			err = multierror.Append(err, fmt.Errorf("docker registry '%s' must be a hostname", registry))
 		}
 	}
 
 	return err.ErrorOrNil()
 }
 
-func getStringHash(ptsRaw string) string {
-	ssum := sha256.Sum256([]byte(ptsRaw))
-	return base64.StdEncoding.EncodeToString(ssum[:])
+// isPseudoRepository reports whether repoKey is one of the "ANY" placeholders
+// Artifactory accepts in permission targets instead of a repository name
+func isPseudoRepository(repoKey string) bool {
+	switch repoKey {
+	case "ANY", "ANY LOCAL", "ANY REMOTE", "ANY DISTRIBUTION":
+		return true
+	}
+	return false
+}
+
+// antPathMatch matches an Artifactory path against an Ant-style pattern: "**" matches any
+// number of directories, "*" any characters within a directory and "?" a single character.
+// A pattern ending with "/" matches everything below that directory.
+func antPathMatch(pattern, path string) bool {
+	path = strings.Trim(path, "/")
+
+	return antMatchSegments(antPatternSegments(pattern), strings.Split(path, "/"))
+}
+
+// antPatternSegments splits an Ant-style pattern into its path segments, expanding a trailing
+// "/" into "**"
+func antPatternSegments(pattern string) []string {
+	pattern = strings.TrimPrefix(pattern, "/")
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	return strings.Split(pattern, "/")
+}
+
+// validateAntPattern checks the syntax of a non-empty Ant-style pattern: segments are
+// separated by a single "/" and "**" is a segment of its own
+func validateAntPattern(pattern string) error {
+	if strings.Contains(pattern, "\\") {
+		return fmt.Errorf("pattern '%s' must separate directories with '/'", pattern)
+	}
+	trimmed := strings.TrimPrefix(pattern, "/")
+	if trimmed == "" {
+		return fmt.Errorf("pattern '%s' has no path", pattern)
+	}
+	if strings.HasSuffix(trimmed, "**/") {
+		return fmt.Errorf("pattern '%s' must not end with '**/', use '**' instead", pattern)
+	}
+
+	for _, segment := range strings.Split(strings.TrimSuffix(trimmed, "/"), "/") {
+		switch {
+		case segment == "":
+			return fmt.Errorf("pattern '%s' has an empty directory", pattern)
+		case segment != "**" && strings.Contains(segment, "**"):
+			return fmt.Errorf("pattern '%s' must use '**' as a whole directory, e.g. 'a/**/b'", pattern)
+		}
+	}
+	return nil
+}
+
+// antPatternCovers reports whether every path matching pattern also matches cover. It only
+// reports coverage it can prove, so it may miss patterns covered by a combination of wildcards.
+func antPatternCovers(cover, pattern string) bool {
+	return antCoverSegments(antPatternSegments(cover), antPatternSegments(pattern))
+}
+
+func antCoverSegments(cover, pattern []string) bool {
+	for len(cover) > 0 {
+		if cover[0] == "**" {
+			for idx := 0; idx <= len(pattern); idx++ {
+				if antCoverSegments(cover[1:], pattern[idx:]) {
+					return true
+				}
+			}
+			return false
+		}
+		// only "**" covers any number of directories
+		if len(pattern) == 0 || pattern[0] == "**" || !wildcardCovers(cover[0], pattern[0]) {
+			return false
+		}
+		cover, pattern = cover[1:], pattern[1:]
+	}
+	return len(pattern) == 0
+}
+
+// wildcardCovers reports whether every path segment matching pattern also matches cover
+func wildcardCovers(cover, pattern string) bool {
+	switch {
+	case cover == pattern, cover == "*":
+		return true
+	case !strings.ContainsAny(pattern, "*?"):
+		return wildcardMatch(cover, pattern)
+	default:
+		return false
+	}
+}
+
+func antMatchSegments(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for idx := 0; idx <= len(path); idx++ {
+				if antMatchSegments(pattern[1:], path[idx:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(path) == 0 || !wildcardMatch(pattern[0], path[0]) {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+	return len(path) == 0
+}
+
+// wildcardMatch matches a single path segment, supporting "*" and "?"
+func wildcardMatch(pattern, s string) bool {
+	p, i := 0, 0
+	starP, starI := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			starP, starI = p, i
+			p++
+		case starP >= 0:
+			p = starP + 1
+			starI++
+			i = starI
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// supportsReferenceTokens checks whether an Artifactory version can issue reference tokens
+func supportsReferenceTokens(artifactoryVersion string) (bool, error) {
+	v, err := version.NewVersion(artifactoryVersion)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse artifactory version '%s' - %w", artifactoryVersion, err)
+	}
+	return v.GreaterThanOrEqual(version.Must(version.NewVersion(minReferenceTokenVersion))), nil
+}
+
+// runParallel calls fn for every item with at most limit calls in flight. Errors are
+// returned together, in the order of the items.
+func runParallel(limit int, items []string, fn func(item string) error) error {
+	if limit < 1 {
+		limit = 1
+	}
+
+	errs := make([]error, len(items))
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for idx, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(idx int, item string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[idx] = fn(item)
+		}(idx, item)
+	}
+	wg.Wait()
+
+	var merr *multierror.Error
+	for _, err := range errs {
+		if err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	return merr.ErrorOrNil()
 }
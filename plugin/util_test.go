@@ -15,7 +15,9 @@
 package artifactorysecrets
 
 import (
+	"fmt"
 	"os"
+	"sync"
 	"testing"
 
 	"github.com/hashicorp/go-multierror"
@@ -59,6 +61,26 @@ func TestValidatePermissionTarget(t *testing.T) {
 		assert.Contains(t, err.Error(), "'repo.repositories' field must be supplied")
 	})
 
+	t.Run("invalid_patterns", func(t *testing.T) {
+		t.Parallel()
+		pt := PermissionTarget{
+			Repo: &Permission{
+				IncludePatterns: []string{"myprefix/**/"},
+				Repositories:    []string{"repo"},
+				Operations:      []string{"read"},
+			},
+			Build: &Permission{
+				ExcludePatterns: []string{"builds//*"},
+				Repositories:    []string{"artifactory-build-info"},
+				Operations:      []string{"read"},
+			},
+		}
+		err := pt.assertValid()
+		require.Error(t, err, "expecting error")
+		assert.Contains(t, err.Error(), "'repo.include_patterns' - pattern 'myprefix/**/' must not end with '**/'")
+		assert.Contains(t, err.Error(), "'build.exclude_patterns' - pattern 'builds//*' has an empty directory")
+	})
+
 	t.Run("empty_repo_opeartions", func(t *testing.T) {
 		t.Parallel()
 		perm := &Permission{
@@ -85,6 +107,22 @@ func TestValidateOperations(t *testing.T) {
 		require.NoError(t, err, "not expecting error: %s", err)
 	})
 
+	t.Run("aliases", func(t *testing.T) {
+		t.Parallel()
+
+		aliases := []string{"r", "n", "w", "d", "m", "mxm", "x", "deploy", "READ", "MANAGE_XRAY_METADATA"}
+		err := validateOperations(aliases)
+
+		require.NoError(t, err, "not expecting error: %s", err)
+	})
+
+	t.Run("presets", func(t *testing.T) {
+		t.Parallel()
+
+		err := validateOperations([]string{"reader"})
+		require.Error(t, err, "presets are only expanded with the presets of the mount")
+	})
+
 	t.Run("invalid_operations", func(t *testing.T) {
 		t.Parallel()
 
@@ -100,6 +138,464 @@ func TestValidateOperations(t *testing.T) {
 	})
 }
 
+func TestNormalizeOperations(t *testing.T) {
+	t.Parallel()
+
+	presets := map[string][]string{
+		"reader":   {"read"},
+		"promoter": {"read", "annotate"},
+	}
+	tests := []struct {
+		name     string
+		ops      []string
+		expected []string
+		errMsg   string
+	}{
+		{
+			name:     "canonical",
+			ops:      []string{"write", "read"},
+			expected: []string{"write", "read"},
+		},
+		{
+			name:     "v1_letters",
+			ops:      []string{"r", "n", "w", "d", "m", "mxm", "x"},
+			expected: []string{"read", "annotate", "write", "delete", "manage", "managedXrayMeta", "distribute"},
+		},
+		{
+			name:     "access_api_names",
+			ops:      []string{"READ", "WRITE", "MANAGE_XRAY_METADATA"},
+			expected: []string{"read", "write", "managedXrayMeta"},
+		},
+		{
+			name:     "ui_names",
+			ops:      []string{"Deploy", "Delete"},
+			expected: []string{"write", "delete"},
+		},
+		{
+			name:     "preset",
+			ops:      []string{"promoter"},
+			expected: []string{"read", "annotate"},
+		},
+		{
+			name:     "duplicates",
+			ops:      []string{"promoter", "r", "write", "reader"},
+			expected: []string{"read", "annotate", "write"},
+		},
+		{
+			name:   "presets_are_case_sensitive",
+			ops:    []string{"Promoter"},
+			errMsg: "operation 'Promoter' is not allowed",
+		},
+		{
+			name:   "unknown",
+			ops:    []string{"read", "publish"},
+			errMsg: "operation 'publish' is not allowed",
+		},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			ops, err := normalizeOperations(test.ops, presets)
+			if test.errMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, ops)
+		})
+	}
+}
+
+func TestValidateOperationPresets(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		presets  map[string][]string
+		expected map[string][]string
+		errMsg   string
+	}{
+		{
+			name:     "normalized",
+			presets:  map[string][]string{"promoter": {"r", "ANNOTATE", "read"}},
+			expected: map[string][]string{"promoter": {"read", "annotate"}},
+		},
+		{
+			name:     "overrides_builtin",
+			presets:  map[string][]string{"deployer": {"read", "write"}},
+			expected: map[string][]string{"deployer": {"read", "write"}},
+		},
+		{
+			name:    "invalid_name",
+			presets: map[string][]string{"Promoter": {"read"}},
+			errMsg:  "operation preset name 'Promoter' must be",
+		},
+		{
+			name:    "operation_name",
+			presets: map[string][]string{"deploy": {"read"}},
+			errMsg:  "operation preset name 'deploy' is an operation",
+		},
+		{
+			name:    "empty",
+			presets: map[string][]string{"nothing": {}},
+			errMsg:  "operation preset 'nothing' has no operations",
+		},
+		{
+			name:    "nested",
+			presets: map[string][]string{"promoter": {"reader", "annotate"}},
+			errMsg:  "operation 'reader' is not allowed",
+		},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			err := validateOperationPresets(test.presets)
+			if test.errMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, test.presets)
+		})
+	}
+}
+
+func TestNormalizePermissionTargets(t *testing.T) {
+	t.Parallel()
+
+	pts := []PermissionTarget{
+		{Repo: &Permission{Repositories: []string{"libs"}, Operations: []string{"deployer", "d"}}},
+		{Build: &Permission{Repositories: []string{"artifactory-build-info"}, Operations: []string{"reader"}}},
+	}
+	require.NoError(t, normalizePermissionTargets(pts, builtinOperationPresets))
+	assert.Equal(t, []string{"read", "annotate", "write", "delete"}, pts[0].Repo.Operations)
+	assert.Equal(t, []string{"read"}, pts[1].Build.Operations)
+
+	err := normalizePermissionTargets([]PermissionTarget{
+		{Repo: &Permission{Repositories: []string{"libs"}, Operations: []string{"publish"}}},
+	}, builtinOperationPresets)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "'repo.operations' - ")
+}
+
+func TestDiffPermissionTargets(t *testing.T) {
+	t.Parallel()
+
+	read := PermissionTarget{Repo: &Permission{Repositories: []string{"repo"}, Operations: []string{"read"}}}
+	write := PermissionTarget{Repo: &Permission{Repositories: []string{"repo"}, Operations: []string{"write"}}}
+	deploy := PermissionTarget{Name: "deploy", Repo: &Permission{Repositories: []string{"repo"}, Operations: []string{"write"}}}
+	deployDelete := PermissionTarget{Name: "deploy", Repo: &Permission{Repositories: []string{"repo"}, Operations: []string{"write", "delete"}}}
+
+	name := func(pt PermissionTarget) string { return permissionTargetName("", "role", pt.identity()) }
+	role := func(pts ...PermissionTarget) *RoleStorageEntry {
+		return &RoleStorageEntry{Name: "role", PermissionTargets: pts, PermissionTargetNames: permissionTargetNames("", "role", pts)}
+	}
+
+	tests := []struct {
+		name         string
+		role         *RoleStorageEntry
+		objectPrefix string
+		pts          []PermissionTarget
+		expected     permissionTargetsDiff
+	}{
+		{
+			name:     "insert_at_front_keeps_others",
+			role:     role(read),
+			pts:      []PermissionTarget{write, read},
+			expected: permissionTargetsDiff{creates: []string{name(write)}},
+		},
+		{
+			name:     "remove_from_front",
+			role:     role(write, read),
+			pts:      []PermissionTarget{read},
+			expected: permissionTargetsDiff{deletes: []string{name(write)}},
+		},
+		{
+			name:     "named_target_changed",
+			role:     role(deploy, read),
+			pts:      []PermissionTarget{deployDelete, read},
+			expected: permissionTargetsDiff{updates: []string{"vault-plugin.pt-deploy.role"}},
+		},
+		{
+			name: "legacy_names_renamed",
+			role: &RoleStorageEntry{Name: "role", PermissionTargets: []PermissionTarget{read, write}},
+			pts:  []PermissionTarget{read},
+			expected: permissionTargetsDiff{
+				creates: []string{name(read)},
+				deletes: []string{"vault-plugin.pt1.role"},
+				renames: []string{"vault-plugin.pt0.role"},
+			},
+		},
+		{
+			name:         "object_prefix_changed",
+			role:         role(read, write),
+			objectPrefix: "mount1",
+			pts:          []PermissionTarget{read, deployDelete},
+			expected: permissionTargetsDiff{
+				creates: []string{
+					permissionTargetName("mount1", "role", read.identity()),
+					permissionTargetName("mount1", "role", deployDelete.identity()),
+				},
+				deletes: []string{name(write)},
+				renames: []string{name(read)},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, test.expected, diffPermissionTargets(test.role, test.objectPrefix, test.pts))
+		})
+	}
+}
+
+func TestValidatePermissionTargetIdentities(t *testing.T) {
+	t.Parallel()
+
+	read := PermissionTarget{Repo: &Permission{Repositories: []string{"repo"}, Operations: []string{"read"}}}
+
+	require.NoError(t, validatePermissionTargetIdentities([]PermissionTarget{read}))
+
+	err := validatePermissionTargetIdentities([]PermissionTarget{read, read})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "identical permission targets must be given distinct names")
+
+	named := read
+	named.Name = "reader"
+	require.NoError(t, validatePermissionTargetIdentities([]PermissionTarget{read, named}))
+
+	err = validatePermissionTargetIdentities([]PermissionTarget{named, named})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "permission target name 'reader' is used more than once")
+}
+
+func TestAntPathMatch(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"**", "com/foo/bar.jar", true},
+		{"**", "", true},
+		{"com/**", "com/foo/bar.jar", true},
+		{"/com/**", "com/foo/bar.jar", true},
+		{"com/**", "org/foo/bar.jar", false},
+		{"com/", "com/foo/bar.jar", true},
+		{"**/*.jar", "com/foo/bar.jar", true},
+		{"**/*.jar", "bar.jar", true},
+		{"**/*.jar", "com/foo/bar.pom", false},
+		{"com/*/bar.jar", "com/foo/bar.jar", true},
+		{"com/*/bar.jar", "com/foo/baz/bar.jar", false},
+		{"com/**/bar.jar", "com/bar.jar", true},
+		{"com/f?o/*", "com/foo/bar.jar", true},
+		{"com/f?o/*", "com/fooo/bar.jar", false},
+		{"*-SNAPSHOT/**", "1.0-SNAPSHOT/a.jar", true},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.pattern+"|"+test.path, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, test.want, antPathMatch(test.pattern, test.path))
+		})
+	}
+}
+
+func TestValidateAntPattern(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		pattern string
+		errMsg  string
+	}{
+		{pattern: "**"},
+		{pattern: "com/**"},
+		{pattern: "/myprefix/**"},
+		{pattern: "com/"},
+		{pattern: "com/**/*.jar"},
+		{pattern: "*-SNAPSHOT/**"},
+		{pattern: "com/f?o/*"},
+		{pattern: "myprefix/**/", errMsg: "must not end with '**/'"},
+		{pattern: "/", errMsg: "has no path"},
+		{pattern: "com//foo", errMsg: "has an empty directory"},
+		{pattern: "com/**.jar", errMsg: "must use '**' as a whole directory"},
+		{pattern: "**foo/bar", errMsg: "must use '**' as a whole directory"},
+		{pattern: `com\foo\**`, errMsg: "must separate directories with '/'"},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.pattern, func(t *testing.T) {
+			t.Parallel()
+			err := validateAntPattern(test.pattern)
+			if test.errMsg == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.errMsg)
+		})
+	}
+}
+
+func TestAntPatternCovers(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		cover   string
+		pattern string
+		want    bool
+	}{
+		{"**", "com/foo/**", true},
+		{"**", "**", true},
+		{"com/**", "com/secret/**", true},
+		{"com/", "com/secret/*.jar", true},
+		{"/com/**", "com/foo", true},
+		{"com/*/bar.jar", "com/foo/bar.jar", true},
+		{"com/*", "com/f?o", true},
+		{"**/secret/**", "com/secret/**", true},
+		{"com/f?o/**", "com/foo/bar", true},
+		{"com/secret/**", "com/**", false},
+		{"com/*", "com/**", false},
+		{"com/*", "com/foo/bar", false},
+		{"**/*.jar", "com/**", false},
+		{"com/f?o", "com/f*o", false},
+		{"org/**", "com/**", false},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.cover+"|"+test.pattern, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, test.want, antPatternCovers(test.cover, test.pattern))
+		})
+	}
+}
+
+func TestLintPermissionTargets(t *testing.T) {
+	t.Parallel()
+
+	repo := func(name string, includes, excludes, repos, ops []string) PermissionTarget {
+		return PermissionTarget{Name: name, Repo: &Permission{IncludePatterns: includes, ExcludePatterns: excludes, Repositories: repos, Operations: ops}}
+	}
+	tests := []struct {
+		name     string
+		pts      []PermissionTarget
+		warnings []string
+	}{
+		{
+			name: "clean",
+			pts: []PermissionTarget{
+				repo("", []string{"com/**"}, []string{"com/secret/**"}, []string{"libs"}, []string{"read"}),
+				repo("", nil, nil, []string{"libs"}, []string{"write"}),
+				{Build: &Permission{Repositories: []string{"artifactory-build-info"}, Operations: []string{"read"}}},
+			},
+		},
+		{
+			name: "empty_include",
+			pts:  []PermissionTarget{repo("docs", []string{""}, []string{""}, []string{"libs"}, []string{"read"})},
+			warnings: []string{
+				"permission target 'docs': the empty pattern in 'repo.include_patterns' grants nothing",
+			},
+		},
+		{
+			name: "duplicates_within_target",
+			pts:  []PermissionTarget{repo("", []string{"com/**", "com/**"}, nil, []string{"libs", "docker", "libs"}, []string{"read"})},
+			warnings: []string{
+				"permission target 0: 'com/**' is listed more than once in 'repo.include_patterns'",
+				"permission target 0: 'libs' is listed more than once in 'repo.repositories'",
+			},
+		},
+		{
+			name: "include_shadowed",
+			pts:  []PermissionTarget{repo("", []string{"com/secret/**", "org/**"}, []string{"com/**"}, []string{"libs"}, []string{"read"})},
+			warnings: []string{
+				"permission target 0: include pattern 'com/secret/**' in 'repo.include_patterns' is entirely excluded by 'com/**'",
+			},
+		},
+		{
+			name: "everything_excluded",
+			pts:  []PermissionTarget{repo("", nil, []string{"/**"}, []string{"libs"}, []string{"read"})},
+			warnings: []string{
+				"permission target 0: exclude pattern '/**' in 'repo.exclude_patterns' excludes every path",
+			},
+		},
+		{
+			name: "granted_twice",
+			pts: []PermissionTarget{
+				repo("readers", []string{"com/**", "org/**"}, nil, []string{"libs", "docker"}, []string{"read"}),
+				repo("deployers", []string{"org/**", "com/**"}, nil, []string{"libs"}, []string{"read", "write"}),
+			},
+			warnings: []string{
+				"permission target 'deployers': 'read' on 'libs' is already granted by permission target 'readers' with the same patterns",
+			},
+		},
+		{
+			name: "same_repository_other_patterns",
+			pts: []PermissionTarget{
+				repo("", []string{"com/**"}, nil, []string{"libs"}, []string{"read"}),
+				repo("", []string{"org/**"}, nil, []string{"libs"}, []string{"read"}),
+			},
+		},
+		{
+			name: "repo_and_build_sections",
+			pts: []PermissionTarget{
+				repo("", nil, nil, []string{"artifactory-build-info"}, []string{"read"}),
+				{Build: &Permission{Repositories: []string{"artifactory-build-info"}, Operations: []string{"read"}}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			for _, pt := range test.pts {
+				require.NoError(t, pt.assertValid(), "linted permission targets should be valid")
+			}
+			assert.Equal(t, test.warnings, lintPermissionTargets(test.pts))
+		})
+	}
+}
+
+func TestPermissionAllows(t *testing.T) {
+	t.Parallel()
+
+	perm := &Permission{
+		IncludePatterns: []string{"com/**"},
+		ExcludePatterns: []string{"com/secret/**"},
+		Repositories:    []string{"libs-release", "ANY REMOTE"},
+		Operations:      []string{"read", "write"},
+	}
+
+	allowed, assumed := perm.allows("libs-release", "com/foo/bar.jar", "write")
+	assert.True(t, allowed)
+	assert.False(t, assumed)
+
+	allowed, _ = perm.allows("libs-release", "com/secret/bar.jar", "write")
+	assert.False(t, allowed, "excluded path should be denied")
+
+	allowed, _ = perm.allows("libs-release", "com/foo/bar.jar", "delete")
+	assert.False(t, allowed, "missing operation should be denied")
+
+	allowed, assumed = perm.allows("maven-remote", "com/foo/bar.jar", "read")
+	assert.True(t, allowed)
+	assert.True(t, assumed, "repository type should be assumed through ANY REMOTE")
+
+	allowed, _ = (&Permission{ExcludePatterns: []string{""}, Repositories: []string{"ANY"}, Operations: []string{"read"}}).allows("any-repo", "a/b", "read")
+	assert.True(t, allowed, "empty include patterns should default to **")
+}
+
 func TestConvertPermissionTarget(t *testing.T) {
 	t.Parallel()
 
@@ -181,6 +677,32 @@ func TestTokenUserName(t *testing.T) {
 	}
 }
 
+func TestSupportsReferenceTokens(t *testing.T) {
+	tests := []struct {
+		version string
+		want    bool
+		wantErr bool
+	}{
+		{version: "7.38.10", want: true},
+		{version: "7.77.3", want: true},
+		{version: "7.38.9", want: false},
+		{version: "6.23.42", want: false},
+		{version: "not-a-version", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.version, func(t *testing.T) {
+			got, err := supportsReferenceTokens(test.version)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
 func checkTokenUsernameLength(t *testing.T, username string) {
 	if len(username) > tokenUsernameMaxLen {
 		t.Errorf("Expected token username to be less than or equal to %v, actual name '%v'", tokenUsernameMaxLen, username)
@@ -194,3 +716,89 @@ func envOrDefault(key, d string) string {
 	}
 	return env
 }
+
+func TestRunParallel(t *testing.T) {
+	t.Parallel()
+
+	items := []string{"a", "b", "c", "d", "e"}
+	tests := []struct {
+		name  string
+		limit int
+	}{
+		{name: "sequential", limit: 1},
+		{name: "bounded", limit: 2},
+		{name: "unbounded", limit: 10},
+		{name: "invalid_limit", limit: 0},
+	}
+
+	for _, test := range tests {
+		test := test // capture range var
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var mu sync.Mutex
+			var seen []string
+			err := runParallel(test.limit, items, func(item string) error {
+				mu.Lock()
+				defer mu.Unlock()
+				seen = append(seen, item)
+				return nil
+			})
+			require.NoError(t, err)
+			assert.ElementsMatch(t, items, seen)
+		})
+	}
+
+	t.Run("errors_in_item_order", func(t *testing.T) {
+		t.Parallel()
+
+		err := runParallel(3, items, func(item string) error {
+			if item == "b" || item == "e" {
+				return fmt.Errorf("failed %s", item)
+			}
+			return nil
+		})
+		require.Error(t, err)
+		merr, ok := err.(*multierror.Error)
+		require.True(t, ok)
+		require.Len(t, merr.Errors, 2)
+		assert.Equal(t, "failed b", merr.Errors[0].Error())
+		assert.Equal(t, "failed e", merr.Errors[1].Error())
+	})
+}
+
+func TestObjectNames(t *testing.T) {
+	t.Parallel()
+
+	role := &RoleStorageEntry{Name: "ci-role", RoleID: roleID("ci-role")}
+	pt := PermissionTarget{Repo: &Permission{Repositories: []string{"repo"}, Operations: []string{"read"}}}
+
+	t.Run("legacy", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, "vault-plugin."+role.RoleID, groupName(role))
+		assert.Equal(t, "vault-plugin.pt-"+pt.identity()+".ci-role", permissionTargetName("", role.Name, pt.identity()))
+	})
+
+	t.Run("prefixed", func(t *testing.T) {
+		t.Parallel()
+		prefixed := *role
+		prefixed.ObjectPrefix = "mount1"
+		assert.Equal(t, "vault-plugin.mount1."+role.RoleID, groupName(&prefixed))
+		assert.Equal(t, "vault-plugin.mount1.pt-"+pt.identity()+".ci-role", permissionTargetName("mount1", role.Name, pt.identity()))
+	})
+
+	t.Run("default_object_prefix", func(t *testing.T) {
+		t.Parallel()
+		assert.Empty(t, defaultObjectPrefix("", "artifactory/"))
+
+		prefix := defaultObjectPrefix("5d6b5dc3-6d6e-4f63-9e7c-0a7b2b5c8f31", "ns1/artifactory/")
+		assert.Len(t, prefix, objectPrefixHashLen)
+		assert.Equal(t, prefix, defaultObjectPrefix("5d6b5dc3-6d6e-4f63-9e7c-0a7b2b5c8f31", "ns1/artifactory/"), "default prefix should be stable")
+		assert.NotEqual(t, prefix, defaultObjectPrefix("0c9e8e4a-2f0b-4c2e-8f6d-3b1d7a9e5c42", "ns1/artifactory/"))
+		assert.NotEqual(t, prefix, defaultObjectPrefix("5d6b5dc3-6d6e-4f63-9e7c-0a7b2b5c8f31", "ns2/artifactory/"), "the namespace should be part of the default")
+
+		prefixed := *role
+		prefixed.ObjectPrefix = prefix
+		assert.LessOrEqual(t, len(groupName(&prefixed)), maxArtifactoryNameLen)
+	})
+}